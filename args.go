@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pborman/getopt"
@@ -10,26 +12,135 @@ import (
 
 var verboseLog bool
 var quietLog bool
-var connectAddress string
-var username string
-var password string
-var civAddress byte
-var serialTCPPort uint16
-var enableSerialDevice bool
-var rigctldPort uint16
-var runCmd string
-var runCmdOnSerialPortCreated string
 var statusLogInterval time.Duration
-var setDataModeOnTx bool
+var discoverMode bool
+var captureFile string
+var statsFilePath string
+var metricsPort uint16
+var simulateMode bool
+var simLossPercent uint
+var simReorderPercent uint
+var simLatency time.Duration
+var simDisconnectAfter time.Duration
+var replayFile string
+var replayFast bool
+var replayExpect string
+
+// Contains the settings of each radio, filled by parseArgs().
+var sessionConfigs []sessionConfig
+
+type argSource struct {
+	name   string
+	value  string
+	source string
+}
+
+type argsParser struct {
+	conf    *configFile
+	creds   *configFile
+	profile string
+	sources []argSource
+	err     error
+}
+
+// Returns the value for the given setting, considering the command line, the environment, the credentials
+// file, the config file and the default value, in this order. For the files, the selected profile is checked
+// before the global section.
+func (p *argsParser) resolve(name, cmdLineValue string) string {
+	v := cmdLineValue
+	source := "default"
+	if getopt.IsSet(name) {
+		source = "command line"
+	} else if env, ok := argEnvVars[name]; ok && os.Getenv(env) != "" {
+		v = os.Getenv(env)
+		source = "environment variable " + env
+	} else {
+		for _, c := range []*configFile{p.creds, p.conf} {
+			if c == nil {
+				continue
+			}
+			if confValue, confSource, found := c.lookup(p.profile, name); found {
+				v = confValue
+				source = confSource
+				break
+			}
+		}
+	}
+
+	shownValue := v
+	if name == "password" {
+		shownValue = "***"
+	}
+	p.sources = append(p.sources, argSource{name: name, value: shownValue, source: source})
+	return v
+}
+
+// Changes the logged source of an already resolved setting.
+func (p *argsParser) setSource(name, source string) {
+	for i := range p.sources {
+		if p.sources[i].name == name {
+			p.sources[i].source = source
+		}
+	}
+}
+
+// Returns true if the setting's value did not come from the default value.
+func (p *argsParser) isSet(name string) bool {
+	for _, a := range p.sources {
+		if a.name == name {
+			return a.source != "default"
+		}
+	}
+	return false
+}
+
+func (p *argsParser) resolveString(name string, cmdLineValue string) string {
+	return p.resolve(name, cmdLineValue)
+}
+
+func (p *argsParser) resolveUint(name string, cmdLineValue uint64, bitSize int) uint64 {
+	s := p.resolve(name, fmt.Sprint(cmdLineValue))
+	v, err := strconv.ParseUint(s, 0, bitSize)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("invalid value for %s: %s", name, s)
+	}
+	return v
+}
+
+func (p *argsParser) resolveInt(name string, cmdLineValue int64, bitSize int) int64 {
+	s := p.resolve(name, fmt.Sprint(cmdLineValue))
+	v, err := strconv.ParseInt(s, 0, bitSize)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("invalid value for %s: %s", name, s)
+	}
+	return v
+}
+
+// A bool set to true in a file can be turned off on the command line with --name=false, getopt then reports
+// the argument as set.
+func (p *argsParser) resolveBool(name string, cmdLineValue bool) bool {
+	s := p.resolve(name, fmt.Sprint(cmdLineValue))
+	v, err := strconv.ParseBool(s)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("invalid value for %s: %s", name, s)
+	}
+	return v
+}
 
 func parseArgs() {
 	h := getopt.BoolLong("help", 'h', "display help")
 	v := getopt.BoolLong("verbose", 'v', "Enable verbose (debug) logging")
 	q := getopt.BoolLong("quiet", 'q', "Disable logging")
+	f := getopt.StringLong("config", 'f', getDefaultConfigFilePath(), "Read settings from this config file")
+	n := getopt.ListLong("profile", 'n', "Use settings from this profile of the config file, can be set multiple times to connect to multiple radios")
 	a := getopt.StringLong("address", 'a', "IC-705", "Connect to address")
 	u := getopt.StringLong("username", 'u', "beer", "Username")
-	p := getopt.StringLong("password", 'p', "beerbeer", "Password")
-	c := getopt.UintLong("civ-address", 'c', 0xa4, "CI-V address")
+	p := getopt.StringLong("password", 'p', "beerbeer", "Password (visible to other users, use the credentials file or "+
+		argEnvVars["password"]+" instead)")
+	credentialsFile := getopt.StringLong("credentials-file", 0, getDefaultCredentialsFilePath(),
+		"Read username and password from this file, its mode must be 0600")
+	passwordPrompt := getopt.BoolLong("password-prompt", 0, "Ask for the password on the terminal")
+	c := getopt.UintLong("civ-address", 'c', 0xa4, "CI-V address, if not set then the address reported by the radio is used")
 	t := getopt.Uint16Long("serial-tcp-port", 't', 4531, "Expose radio's serial port on this TCP port")
 	s := getopt.BoolLong("enable-serial-device", 's', "Expose radio's serial port as a virtual serial port")
 	r := getopt.Uint16Long("rigctld-port", 'r', 4532, "Use this TCP port for the internal rigctld")
@@ -37,26 +148,323 @@ func parseArgs() {
 	o := getopt.StringLong("exec-serial", 'o', "socat /tmp/kappanhang-IC-705.pty /tmp/vmware.pty", "Exec cmd when virtual serial port is created, set to - to disable")
 	i := getopt.Uint16Long("log-interval", 'i', 100, "Status bar/log interval in milliseconds")
 	d := getopt.BoolLong("set-data-tx", 'd', "Automatically enable data mode on TX")
+	waitIfBusy := getopt.BoolLong("wait-if-busy", 0, "Keep polling the radio if it's in use by another client")
+	capturePath := getopt.StringLong("capture", 0, "", "Capture the packets to this pcapng file")
+	metricsPortArg := getopt.Uint16Long("metrics-port", 0, 0, "Serve Prometheus metrics over HTTP on this TCP port, 0 disables")
+	statsPath := getopt.StringLong("stats-file", 0, "", "Periodically write per-stream network statistics to this JSON file")
+	discover := getopt.BoolLong("discover", 0, "List the RS-BA1 servers on the local networks and exit")
+	simLoss := getopt.UintLong("sim-loss", 0, 0, "Simulator: drop this percent of the packets")
+	simReorder := getopt.UintLong("sim-reorder", 0, 0, "Simulator: reorder this percent of the sent packets")
+	simLatencyMs := getopt.UintLong("sim-latency", 0, 0, "Simulator: delay the sent packets by this many milliseconds")
+	simDisconnectAfterSec := getopt.UintLong("sim-disconnect-after", 0, 0,
+		"Simulator: disconnect the client after streaming for this many seconds, 0 disables")
+	replayFastArg := getopt.BoolLong("replay-fast", 0, "Replay: feed the packets as fast as possible instead of with their original timing")
+	replayExpectArg := getopt.StringLong("replay-expect", 0, "", "Replay: comma separated name=value list of the expected results, like freq=7074000,lost=0")
+	audioCodec := getopt.StringLong("audio-codec", 0, defaultAudioCodec, "Audio codec requested from the radio: "+
+		strings.Join(getAudioCodecNames(), ", "))
+	sampleRate := getopt.UintLong("audio-sample-rate", 0, audioSampleRate, "Audio sample rate requested from the radio")
+	jitterBufferMin := getopt.UintLong("jitter-buffer-min", 0, uint(defaultAudioJitterBufferMin.Milliseconds()),
+		"Min. audio jitter buffer depth in milliseconds")
+	jitterBufferMax := getopt.UintLong("jitter-buffer-max", 0, uint(defaultAudioJitterBufferMax.Milliseconds()),
+		"Max. audio jitter buffer depth in milliseconds")
+	audioPLC := getopt.StringLong("audio-plc", 0, defaultAudioPLCMode, "Replace lost audio packets with: "+
+		strings.Join(audioPLCModeNames, ", "))
+	virtualDevName := getopt.StringLong("virtual-device-name", 0, "", "Name of the virtual sound card's source and sink, "+
+		"kappanhang-<radio name> if not set")
+	virtualDevDesc := getopt.StringLong("virtual-device-description", 0, "", "Description of the virtual sound card, "+
+		"kappanhang: <radio name> if not set")
+	virtualDevFormat := getopt.StringLong("virtual-device-format", 0, defaultSoundcardSampleFormat,
+		"Sample format of the virtual sound card: "+strings.Join(getSoundcardSampleFormatNames(), ", "))
+	virtualDevRate := getopt.UintLong("virtual-device-rate", 0, audioSampleRate, "Sample rate of the virtual sound card")
+	monitorDevice := getopt.StringLong("monitor-device", 0, "", "PulseAudio sink used for monitoring (l), the default sink if not set")
+	recordDevice := getopt.StringLong("record-device", 0, "", "PulseAudio source used for transmitting (space), the default source if not set")
+	wavDir := getopt.StringLong("wav-dir", 0, ".", "Save the WAV recordings (w) to this directory")
+	wavTx := getopt.BoolLong("wav-tx", 0, "Also record the transmitted audio, in the right channel of the WAV file")
+	wavMaxSize := getopt.UintLong("wav-max-size", 0, 0, "Start a new WAV file after this many megabytes (less than 4096), 0 means no limit")
+	wavMaxLength := getopt.UintLong("wav-max-length", 0, 0, "Start a new WAV file after this many minutes, 0 means no limit")
+	voiceKeyer := getopt.StringLong("voice-keyer", 0, "", "Comma separated list of WAV or raw 48kHz s16le mono files "+
+		"transmitted by pressing F1, F2...")
+	voiceKeyerRepeat := getopt.UintLong("voice-keyer-repeat", 0, 0, "Repeat the voice keyer messages after this many seconds, 0 disables")
+	vox := getopt.BoolLong("vox", 0, "Turn on PTT automatically when audio is coming from the virtual sound card")
+	voxThreshold := getopt.IntLong("vox-threshold", 0, defaultVOXThreshold, "VOX threshold in dBFS")
+	voxAttack := getopt.UintLong("vox-attack", 0, uint(defaultVOXAttack.Milliseconds()),
+		"Turn on PTT after the audio is above the VOX threshold for this many milliseconds")
+	voxHang := getopt.UintLong("vox-hang", 0, uint(defaultVOXHang.Milliseconds()),
+		"Turn off PTT after the audio is below the VOX threshold for this many milliseconds")
+	voxMaxTx := getopt.UintLong("vox-max-tx", 0, uint(defaultVOXMaxTx.Seconds()),
+		"Turn off PTT if the audio does not stop in this many seconds")
+	txDSP := getopt.BoolLong("tx-dsp", 0, "Process the transmitted audio with the TX audio chain")
+	txDSPModes := getopt.StringLong("tx-dsp-modes", 0, defaultTxDSPModes,
+		"Comma separated list of modes where the TX audio chain is used, data modes have a -D suffix (like USB-D)")
+	txGain := getopt.IntLong("tx-gain", 0, 0, "TX audio gain in dB")
+	txHighpass := getopt.UintLong("tx-highpass", 0, defaultTxHighpass, "TX audio highpass filter frequency in Hz, 0 disables")
+	txLowpass := getopt.UintLong("tx-lowpass", 0, defaultTxLowpass, "TX audio lowpass filter frequency in Hz, 0 disables")
+	txEQ := getopt.StringLong("tx-eq", 0, "", "Comma separated list of TX audio EQ bands in freq:gain[:q] format, like 300:-3,2000:4:1.5")
+	txCompThreshold := getopt.IntLong("tx-comp-threshold", 0, defaultTxCompThreshold,
+		"TX audio compressor threshold in dBFS, 0 disables the compressor")
+	txCompRatio := getopt.UintLong("tx-comp-ratio", 0, defaultTxCompRatio, "TX audio compressor ratio")
+	txCompMakeup := getopt.IntLong("tx-comp-makeup", 0, defaultTxCompMakeup, "TX audio compressor makeup gain in dB")
+	txLimit := getopt.IntLong("tx-limit", 0, defaultTxLimit, "TX audio limiter ceiling in dBFS")
+	rxNR := getopt.BoolLong("rx-nr", 0, "Turn on the noise reduction of the received audio on startup (N)")
+	rxANF := getopt.BoolLong("rx-anf", 0, "Turn on the auto-notch filter of the received audio on startup (A)")
+	rxBandpass := getopt.UintLong("rx-bandpass", 0, 0, "Use this bandpass filter for the received audio on startup (F), "+
+		"1 is the first filter, 0 is off")
+	rxBandpassFilters := getopt.StringLong("rx-bandpass-filters", 0, defaultRxBandpassFilters,
+		"Comma separated list of bandpass filters for the received audio in low-high Hz format")
+	controlPort := getopt.Uint16Long("control-port", 0, defaultControlStreamPort, "Radio's UDP port for the control stream")
+	serialPort := getopt.Uint16Long("serial-port", 0, defaultSerialStreamPort, "Radio's UDP port for the serial stream")
+	audioPort := getopt.Uint16Long("audio-port", 0, defaultAudioStreamPort, "Radio's UDP port for the audio stream")
+	localAddress := getopt.StringLong("local-address", 0, "", "Bind to this local address")
+	localControlPort := getopt.Uint16Long("local-control-port", 0, 0, "Local UDP port for the control stream, 0 means same as the radio's port")
+	localSerialPort := getopt.Uint16Long("local-serial-port", 0, 0, "Local UDP port for the serial stream, 0 means same as the radio's port")
+	localAudioPort := getopt.Uint16Long("local-audio-port", 0, 0, "Local UDP port for the audio stream, 0 means same as the radio's port")
 
-	getopt.Parse()
+	getopt.SetParameters("[simulate | replay file.pcapng]")
+	args := os.Args
+	var replayMode bool
+	if len(args) > 1 && args[1] == "simulate" {
+		simulateMode = true
+		args = append([]string{args[0]}, args[2:]...)
+	} else if len(args) > 1 && args[1] == "replay" {
+		replayMode = true
+		args = append([]string{args[0]}, args[2:]...)
+	}
+	getopt.CommandLine.Parse(args)
+
+	if replayMode {
+		if getopt.NArgs() != 1 {
+			fmt.Println("replay needs a capture file")
+			os.Exit(1)
+		}
+		replayFile = getopt.Arg(0)
+	}
 
-	if *h || *a == "" || (*q && *v) {
+	if *h || (*q && *v) {
 		fmt.Println(getAboutStr())
 		getopt.Usage()
 		os.Exit(1)
 	}
 
+	var conf *configFile
+	if *f != "" {
+		var err error
+		conf, err = loadConfigFile(*f)
+		if err != nil && (getopt.IsSet("config") || !os.IsNotExist(err)) {
+			fmt.Println("can't load config file:", err)
+			os.Exit(1)
+		}
+	}
+
+	var creds *configFile
+	if *credentialsFile != "" {
+		var err error
+		creds, err = loadCredentialsFile(*credentialsFile)
+		if err != nil && (getopt.IsSet("credentials-file") || !os.IsNotExist(err)) {
+			fmt.Println("can't load credentials file:", err)
+			os.Exit(1)
+		}
+	}
+
+	profiles := *n
+	if len(profiles) == 0 {
+		profiles = []string{""}
+	}
+
 	verboseLog = *v
 	quietLog = *q
-	connectAddress = *a
-	username = *u
-	password = *p
-	civAddress = byte(*c)
-	serialTCPPort = *t
-	enableSerialDevice = *s
-	rigctldPort = *r
-	runCmd = *e
-	runCmdOnSerialPortCreated = *o
-	statusLogInterval = time.Duration(*i) * time.Millisecond
-	setDataModeOnTx = *d
+	discoverMode = *discover
+	captureFile = *capturePath
+	statsFilePath = *statsPath
+	metricsPort = *metricsPortArg
+	simLossPercent = *simLoss
+	simReorderPercent = *simReorder
+	simLatency = time.Duration(*simLatencyMs) * time.Millisecond
+	simDisconnectAfter = time.Duration(*simDisconnectAfterSec) * time.Second
+	replayFast = *replayFastArg
+	replayExpect = *replayExpectArg
+
+	for idx, profile := range profiles {
+		if profile != "" && (conf == nil || !conf.hasProfile(profile)) {
+			fmt.Println("profile not found in config file:", profile)
+			os.Exit(1)
+		}
+
+		parser := argsParser{conf: conf, creds: creds, profile: profile}
+		var sc sessionConfig
+		sc.connectAddress = parser.resolveString("address", *a)
+		sc.username = parser.resolveString("username", *u)
+		sc.password = parser.resolveString("password", *p)
+		if parser.resolveBool("password-prompt", *passwordPrompt) {
+			prompt := "password: "
+			if profile != "" {
+				prompt = profile + " password: "
+			}
+			var err error
+			if sc.password, err = readPasswordFromTerminal(prompt); err != nil {
+				fmt.Println("can't read password:", err)
+				os.Exit(1)
+			}
+			parser.setSource("password", "terminal")
+		}
+		sc.civAddress = byte(parser.resolveUint("civ-address", uint64(*c), 8))
+		sc.civAddressSet = parser.isSet("civ-address")
+		sc.serialTCPPort = uint16(parser.resolveUint("serial-tcp-port", uint64(*t), 16))
+		sc.enableSerialDevice = parser.resolveBool("enable-serial-device", *s)
+		sc.rigctldPort = uint16(parser.resolveUint("rigctld-port", uint64(*r), 16))
+		sc.runCmd = parser.resolveString("exec", *e)
+		sc.runCmdOnSerialPortCreated = parser.resolveString("exec-serial", *o)
+		sc.setDataModeOnTx = parser.resolveBool("set-data-tx", *d)
+		var err error
+		sc.audioFormat, err = newAudioFormat(parser.resolveString("audio-codec", *audioCodec),
+			int(parser.resolveUint("audio-sample-rate", uint64(*sampleRate), 32)))
+		if err != nil && parser.err == nil {
+			parser.err = err
+		}
+		sc.audioJitterBufferMin = time.Duration(parser.resolveUint("jitter-buffer-min", uint64(*jitterBufferMin), 16)) * time.Millisecond
+		sc.audioJitterBufferMax = time.Duration(parser.resolveUint("jitter-buffer-max", uint64(*jitterBufferMax), 16)) * time.Millisecond
+		if sc.audioJitterBufferMin > sc.audioJitterBufferMax && parser.err == nil {
+			parser.err = fmt.Errorf("jitter-buffer-min can't be larger than jitter-buffer-max")
+		}
+		sc.audioPLCMode, err = getAudioPLCMode(parser.resolveString("audio-plc", *audioPLC))
+		if err != nil && parser.err == nil {
+			parser.err = err
+		}
+		sc.virtualSoundcardName = parser.resolveString("virtual-device-name", *virtualDevName)
+		sc.virtualSoundcardDesc = parser.resolveString("virtual-device-description", *virtualDevDesc)
+		sc.virtualSoundcardFormat, err = newSoundcardFormat(parser.resolveString("virtual-device-format", *virtualDevFormat),
+			int(parser.resolveUint("virtual-device-rate", uint64(*virtualDevRate), 32)))
+		if err != nil && parser.err == nil {
+			parser.err = err
+		}
+		sc.monitorDevice = parser.resolveString("monitor-device", *monitorDevice)
+		sc.recordDevice = parser.resolveString("record-device", *recordDevice)
+		sc.wavDir = parser.resolveString("wav-dir", *wavDir)
+		sc.wavRecordTx = parser.resolveBool("wav-tx", *wavTx)
+		sc.wavMaxSize = int64(parser.resolveUint("wav-max-size", uint64(*wavMaxSize), 16)) * 1024 * 1024
+		if sc.wavMaxSize >= recorderMaxFileSize && parser.err == nil {
+			parser.err = fmt.Errorf("wav-max-size must be less than 4096, WAV files can't be larger than 4GB")
+		}
+		sc.wavMaxDuration = time.Duration(parser.resolveUint("wav-max-length", uint64(*wavMaxLength), 16)) * time.Minute
+		if files := parser.resolveString("voice-keyer", *voiceKeyer); files != "" {
+			for _, f := range strings.Split(files, ",") {
+				sc.voiceKeyerFiles = append(sc.voiceKeyerFiles, strings.TrimSpace(f))
+			}
+		}
+		sc.voiceKeyerRepeat = time.Duration(parser.resolveUint("voice-keyer-repeat", uint64(*voiceKeyerRepeat), 16)) * time.Second
+		sc.voxEnabled = parser.resolveBool("vox", *vox)
+		sc.voxThreshold = float64(parser.resolveInt("vox-threshold", int64(*voxThreshold), 16))
+		sc.voxAttack = time.Duration(parser.resolveUint("vox-attack", uint64(*voxAttack), 16)) * time.Millisecond
+		sc.voxHang = time.Duration(parser.resolveUint("vox-hang", uint64(*voxHang), 16)) * time.Millisecond
+		sc.voxMaxTx = time.Duration(parser.resolveUint("vox-max-tx", uint64(*voxMaxTx), 16)) * time.Second
+		if sc.voxMaxTx == 0 && parser.err == nil {
+			parser.err = fmt.Errorf("vox-max-tx can't be 0")
+		}
+		sc.txDSP.enabled = parser.resolveBool("tx-dsp", *txDSP)
+		for _, m := range strings.Split(parser.resolveString("tx-dsp-modes", *txDSPModes), ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				sc.txDSP.modes = append(sc.txDSP.modes, m)
+			}
+		}
+		sc.txDSP.gainDB = float64(parser.resolveInt("tx-gain", int64(*txGain), 16))
+		sc.txDSP.highpass = float64(parser.resolveUint("tx-highpass", uint64(*txHighpass), 16))
+		sc.txDSP.lowpass = float64(parser.resolveUint("tx-lowpass", uint64(*txLowpass), 16))
+		if sc.txDSP.highpass >= audioSampleRate/2 || sc.txDSP.lowpass >= audioSampleRate/2 {
+			if parser.err == nil {
+				parser.err = fmt.Errorf("tx-highpass and tx-lowpass must be below %d Hz", audioSampleRate/2)
+			}
+		}
+		sc.txDSP.eq, err = parseTxEQBands(parser.resolveString("tx-eq", *txEQ))
+		if err != nil && parser.err == nil {
+			parser.err = err
+		}
+		sc.txDSP.compThresholdDB = float64(parser.resolveInt("tx-comp-threshold", int64(*txCompThreshold), 16))
+		if sc.txDSP.compThresholdDB > 0 && parser.err == nil {
+			parser.err = fmt.Errorf("tx-comp-threshold can't be above 0")
+		}
+		sc.txDSP.compRatio = float64(parser.resolveUint("tx-comp-ratio", uint64(*txCompRatio), 16))
+		if sc.txDSP.compRatio < 1 && parser.err == nil {
+			parser.err = fmt.Errorf("tx-comp-ratio can't be 0")
+		}
+		sc.txDSP.compMakeupDB = float64(parser.resolveInt("tx-comp-makeup", int64(*txCompMakeup), 16))
+		sc.txDSP.limitDB = float64(parser.resolveInt("tx-limit", int64(*txLimit), 16))
+		if sc.txDSP.limitDB > 0 && parser.err == nil {
+			parser.err = fmt.Errorf("tx-limit can't be above 0")
+		}
+		sc.rxDSP.nr = parser.resolveBool("rx-nr", *rxNR)
+		sc.rxDSP.anf = parser.resolveBool("rx-anf", *rxANF)
+		sc.rxDSP.bandpassFilters, err = parseRxBandpassFilters(parser.resolveString("rx-bandpass-filters", *rxBandpassFilters))
+		if err != nil && parser.err == nil {
+			parser.err = err
+		}
+		sc.rxDSP.bandpassIdx = int(parser.resolveUint("rx-bandpass", uint64(*rxBandpass), 16))
+		if sc.rxDSP.bandpassIdx > len(sc.rxDSP.bandpassFilters) && parser.err == nil {
+			parser.err = fmt.Errorf("rx-bandpass is larger than the count of rx-bandpass-filters")
+		}
+		sc.waitIfBusy = parser.resolveBool("wait-if-busy", *waitIfBusy)
+		sc.controlStreamPort = uint16(parser.resolveUint("control-port", uint64(*controlPort), 16))
+		sc.serialStreamPort = uint16(parser.resolveUint("serial-port", uint64(*serialPort), 16))
+		sc.audioStreamPort = uint16(parser.resolveUint("audio-port", uint64(*audioPort), 16))
+		sc.localAddress = parser.resolveString("local-address", *localAddress)
+		sc.localControlStreamPort = uint16(parser.resolveUint("local-control-port", uint64(*localControlPort), 16))
+		sc.localSerialStreamPort = uint16(parser.resolveUint("local-serial-port", uint64(*localSerialPort), 16))
+		sc.localAudioStreamPort = uint16(parser.resolveUint("local-audio-port", uint64(*localAudioPort), 16))
+		// The status bar is shared between the radios, so the interval is only taken from the first profile.
+		if idx == 0 {
+			statusLogInterval = time.Duration(parser.resolveUint("log-interval", uint64(*i), 16)) * time.Millisecond
+		}
+
+		if parser.err != nil {
+			fmt.Println(parser.err)
+			os.Exit(1)
+		}
+
+		if sc.connectAddress == "" {
+			fmt.Println(getAboutStr())
+			getopt.Usage()
+			os.Exit(1)
+		}
+
+		sc.name = profile
+		if sc.name == "" {
+			sc.name = sc.connectAddress
+		}
+		sc.argSources = parser.sources
+		sessionConfigs = append(sessionConfigs, sc)
+	}
+
+	if len(sessionConfigs) > 1 {
+		// Radios of the same type would get the same device names, so the profile names are used instead.
+		for i := range sessionConfigs {
+			sessionConfigs[i].deviceName = sessionConfigs[i].name
+		}
+		if err := checkProfileConflicts(sessionConfigs); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+}
+
+// Returns an error if multiple profiles would use the same TCP port or virtual sound card.
+func checkProfileConflicts(configs []sessionConfig) error {
+	rigctldPorts := make(map[uint16]string)
+	serialTCPPorts := make(map[uint16]string)
+	soundcardNames := make(map[string]string)
+	for _, sc := range configs {
+		if other, ok := rigctldPorts[sc.rigctldPort]; ok {
+			return fmt.Errorf("profiles %s and %s use the same rigctld-port %d", other, sc.name, sc.rigctldPort)
+		}
+		rigctldPorts[sc.rigctldPort] = sc.name
+		if other, ok := serialTCPPorts[sc.serialTCPPort]; ok {
+			return fmt.Errorf("profiles %s and %s use the same serial-tcp-port %d", other, sc.name, sc.serialTCPPort)
+		}
+		serialTCPPorts[sc.serialTCPPort] = sc.name
+		if sc.virtualSoundcardName != "" {
+			if other, ok := soundcardNames[sc.virtualSoundcardName]; ok {
+				return fmt.Errorf("profiles %s and %s use the same virtual-device-name %s", other, sc.name,
+					sc.virtualSoundcardName)
+			}
+			soundcardNames[sc.virtualSoundcardName] = sc.name
+		}
+	}
+	return nil
 }
@@ -19,9 +19,9 @@ const audioSampleBytes = 2
 const pulseAudioBufferLength = 100 * time.Millisecond
 const audioFrameLength = 20 * time.Millisecond
 const audioFrameSize = int((audioSampleRate * audioSampleBytes * audioFrameLength) / time.Second)
-const maxPlayBufferSize = audioFrameSize*5 + int((audioSampleRate*audioSampleBytes*audioRxSeqBufLength)/time.Second)
 
 type audioStruct struct {
+	sess    *session
 	devName string
 
 	deinitNeededChan   chan bool
@@ -36,6 +36,10 @@ type audioStruct struct {
 		source papipes.Source
 		sink   papipes.Sink
 
+		format  soundcardFormat
+		encoder *soundcardConverter
+		decoder *soundcardConverter
+
 		mutex   sync.Mutex
 		playBuf *bytes.Buffer
 		canPlay chan bool
@@ -55,7 +59,11 @@ type audioStruct struct {
 	}
 }
 
-var audio audioStruct
+// The play buffer has to be able to hold the audio which is received in a burst after the rx seqbuf waited
+// for missing packets.
+func (a *audioStruct) getMaxPlayBufferSize(f soundcardFormat) int {
+	return f.getDataLength(audioFrameLength*5 + a.sess.conf.audioJitterBufferMax)
+}
 
 func (a *audioStruct) defaultSoundCardPlayStreamDeinit() {
 	_ = a.defaultSoundcardStream.playStream.Drain()
@@ -91,21 +99,16 @@ func (a *audioStruct) toggleRecFromDefaultSoundcard() {
 		battr := pulse.NewBufferAttr()
 		battr.Fragsize = uint32(audioFrameSize)
 		var err error
-		a.defaultSoundcardStream.recStream, err = pulse.NewStream("", "kappanhang", pulse.STREAM_RECORD, "", a.devName,
-			&ss, nil, battr)
+		a.defaultSoundcardStream.recStream, err = pulse.NewStream("", "kappanhang", pulse.STREAM_RECORD,
+			a.sess.conf.recordDevice, a.devName, &ss, nil, battr)
 		if err == nil {
 			a.defaultSoundcardStream.recLoopDeinitNeededChan = make(chan bool)
 			a.defaultSoundcardStream.recLoopDeinitFinishedChan = make(chan bool)
 			go a.recLoopFromDefaultSoundcard()
 			log.Print("turned on audio rec")
-			statusLog.reportAudioRec(true)
+			a.sess.statusLog.reportAudioRec(true)
 
-			if setDataModeOnTx {
-				if err := civControl.setDataMode(true); err != nil {
-					log.Error("can't enable data mode: ", err)
-				}
-			}
-			if err := civControl.setPTT(true); err != nil {
+			if err := a.sess.civControl.setPTTWithDataMode(true); err != nil {
 				log.Error("can't turn on ptt: ", err)
 			}
 		} else {
@@ -114,9 +117,9 @@ func (a *audioStruct) toggleRecFromDefaultSoundcard() {
 		}
 	} else {
 		a.defaultSoundCardRecStreamDeinit()
-		statusLog.reportAudioRec(false)
+		a.sess.statusLog.reportAudioRec(false)
 		log.Print("turned off audio rec")
-		if err := civControl.setPTT(false); err != nil {
+		if err := a.sess.civControl.setPTT(false); err != nil {
 			log.Error("can't turn off ptt: ", err)
 		}
 	}
@@ -124,14 +127,21 @@ func (a *audioStruct) toggleRecFromDefaultSoundcard() {
 
 func (a *audioStruct) doTogglePlaybackToDefaultSoundcard() {
 	if a.defaultSoundcardStream.playStream == nil {
-		log.Print("turned on audio playback")
-		statusLog.reportAudioMon(true)
 		ss := pulse.SampleSpec{Format: pulse.SAMPLE_S16LE, Rate: audioSampleRate, Channels: 1}
-		a.defaultSoundcardStream.playStream, _ = pulse.Playback("kappanhang", a.devName, &ss)
+		var err error
+		a.defaultSoundcardStream.playStream, err = pulse.NewStream("", "kappanhang", pulse.STREAM_PLAYBACK,
+			a.sess.conf.monitorDevice, a.devName, &ss, nil, nil)
+		if err != nil {
+			log.Error("can't turn on playback: ", err)
+			a.defaultSoundcardStream.playStream = nil
+			return
+		}
+		log.Print("turned on audio playback")
+		a.sess.statusLog.reportAudioMon(true)
 	} else {
 		a.defaultSoundCardPlayStreamDeinit()
 		log.Print("turned off audio playback")
-		statusLog.reportAudioMon(false)
+		a.sess.statusLog.reportAudioMon(false)
 	}
 }
 
@@ -169,7 +179,7 @@ func (a *audioStruct) playLoopToDefaultSoundcard(deinitNeededChan, deinitFinishe
 				written, err := a.defaultSoundcardStream.playStream.Write(d)
 				if err != nil {
 					if _, ok := err.(*os.PathError); !ok {
-						reportError(err)
+						a.sess.reportError(err)
 					}
 					break
 				}
@@ -197,10 +207,16 @@ func (a *audioStruct) recLoopFromDefaultSoundcard() {
 		n, err := a.defaultSoundcardStream.recStream.Read(frameBuf)
 		if err != nil {
 			if _, ok := err.(*os.PathError); !ok {
-				reportError(err)
+				a.sess.reportError(err)
 			}
 		}
 
+		// The voice keyer's message is being sent.
+		if a.sess.voiceKeyer.isSending() {
+			buf.Reset()
+			continue
+		}
+
 		// Do not send silence frames to the radio unnecessarily
 		if isAllZero(frameBuf[:n]) {
 			continue
@@ -212,10 +228,10 @@ func (a *audioStruct) recLoopFromDefaultSoundcard() {
 			b := make([]byte, len(frameBuf))
 			n, err = buf.Read(b)
 			if err != nil {
-				reportError(err)
+				a.sess.reportError(err)
 			}
 			if n != len(frameBuf) {
-				reportError(errors.New("audio buffer read error"))
+				a.sess.reportError(errors.New("audio buffer read error"))
 			}
 
 			select {
@@ -228,6 +244,8 @@ func (a *audioStruct) recLoopFromDefaultSoundcard() {
 }
 
 func (a *audioStruct) playLoopToVirtualSoundcard(deinitNeededChan, deinitFinishedChan chan bool) {
+	frameSize := a.virtualSoundcardStream.format.getDataLength(audioFrameLength)
+
 	for {
 		select {
 		case <-a.virtualSoundcardStream.canPlay:
@@ -238,12 +256,12 @@ func (a *audioStruct) playLoopToVirtualSoundcard(deinitNeededChan, deinitFinishe
 
 		for {
 			a.virtualSoundcardStream.mutex.Lock()
-			if a.virtualSoundcardStream.playBuf.Len() < audioFrameSize {
+			if a.virtualSoundcardStream.playBuf.Len() < frameSize {
 				a.virtualSoundcardStream.mutex.Unlock()
 				break
 			}
 
-			d := make([]byte, audioFrameSize)
+			d := make([]byte, frameSize)
 			bytesToWrite, err := a.virtualSoundcardStream.playBuf.Read(d)
 			a.virtualSoundcardStream.mutex.Unlock()
 			if err != nil {
@@ -259,7 +277,7 @@ func (a *audioStruct) playLoopToVirtualSoundcard(deinitNeededChan, deinitFinishe
 				written, err := a.virtualSoundcardStream.source.Write(d)
 				if err != nil {
 					if _, ok := err.(*os.PathError); !ok {
-						reportError(err)
+						a.sess.reportError(err)
 					}
 					break
 				}
@@ -274,7 +292,7 @@ func (a *audioStruct) recLoopFromVirtualSoundcard(deinitNeededChan, deinitFinish
 		deinitFinishedChan <- true
 	}()
 
-	frameBuf := make([]byte, audioFrameSize)
+	frameBuf := make([]byte, a.virtualSoundcardStream.format.getDataLength(audioFrameLength))
 	buf := bytes.NewBuffer([]byte{})
 
 	for {
@@ -287,7 +305,7 @@ func (a *audioStruct) recLoopFromVirtualSoundcard(deinitNeededChan, deinitFinish
 		n, err := a.virtualSoundcardStream.sink.Read(frameBuf)
 		if err != nil {
 			if _, ok := err.(*os.PathError); !ok {
-				reportError(err)
+				a.sess.reportError(err)
 				if err == io.EOF {
 					<-deinitNeededChan
 					return
@@ -295,21 +313,33 @@ func (a *audioStruct) recLoopFromVirtualSoundcard(deinitNeededChan, deinitFinish
 			}
 		}
 
+		d := frameBuf[:n]
+		if !a.virtualSoundcardStream.format.isNative() {
+			d = a.virtualSoundcardStream.decoder.decode(d)
+		}
+
+		// The voice keyer's message is being sent, VOX is also off meanwhile.
+		if a.sess.voiceKeyer.isSending() {
+			buf.Reset()
+			continue
+		}
+		a.sess.vox.process(d)
+
 		// Do not send silence frames to the radio unnecessarily
-		if isAllZero(frameBuf[:n]) {
+		if isAllZero(d) {
 			continue
 		}
-		buf.Write(frameBuf[:n])
+		buf.Write(d)
 
-		for buf.Len() >= len(frameBuf) {
+		for buf.Len() >= audioFrameSize {
 			// We need to create a new []byte slice for each chunk to be able to send it through the rec chan.
-			b := make([]byte, len(frameBuf))
+			b := make([]byte, audioFrameSize)
 			n, err = buf.Read(b)
 			if err != nil {
-				reportError(err)
+				a.sess.reportError(err)
 			}
-			if n != len(frameBuf) {
-				reportError(errors.New("audio buffer read error"))
+			if n != audioFrameSize {
+				a.sess.reportError(errors.New("audio buffer read error"))
 			}
 
 			select {
@@ -356,13 +386,19 @@ func (a *audioStruct) loop() {
 			return
 		}
 
+		a.sess.rxDSP.process(d)
+
+		vd := d
+		if !a.virtualSoundcardStream.format.isNative() {
+			vd = a.virtualSoundcardStream.encoder.encode(d)
+		}
+		maxPlayBufferSize := a.getMaxPlayBufferSize(a.virtualSoundcardStream.format)
 		a.virtualSoundcardStream.mutex.Lock()
 		free := maxPlayBufferSize - a.virtualSoundcardStream.playBuf.Len()
-		if free < len(d) {
-			b := make([]byte, len(d)-free)
-			_, _ = a.virtualSoundcardStream.playBuf.Read(b)
+		if free < len(vd) {
+			a.virtualSoundcardStream.playBuf.Next(len(vd) - free)
 		}
-		a.virtualSoundcardStream.playBuf.Write(d)
+		a.virtualSoundcardStream.playBuf.Write(vd)
 		a.virtualSoundcardStream.mutex.Unlock()
 
 		// Non-blocking notify.
@@ -372,11 +408,11 @@ func (a *audioStruct) loop() {
 		}
 
 		if a.defaultSoundcardStream.playStream != nil {
+			maxPlayBufferSize := a.getMaxPlayBufferSize(nativeSoundcardFormat)
 			a.defaultSoundcardStream.mutex.Lock()
 			free := maxPlayBufferSize - a.defaultSoundcardStream.playBuf.Len()
 			if free < len(d) {
-				b := make([]byte, len(d)-free)
-				_, _ = a.defaultSoundcardStream.playBuf.Read(b)
+				a.defaultSoundcardStream.playBuf.Next(len(d) - free)
 			}
 			a.defaultSoundcardStream.playBuf.Write(d)
 			a.defaultSoundcardStream.mutex.Unlock()
@@ -387,6 +423,25 @@ func (a *audioStruct) loop() {
 			default:
 			}
 		}
+
+		a.sess.rxAudioLevel.process(d)
+		a.sess.recorder.writeRx(d)
+
+		// The audio has been copied to the play buffers.
+		audioBufPool.put(d)
+	}
+}
+
+// Used instead of loop() if the session has no sound card, the received audio is discarded.
+func (a *audioStruct) discardLoop() {
+	for {
+		select {
+		case d := <-a.play:
+			audioBufPool.put(d)
+		case <-a.deinitNeededChan:
+			a.deinitFinishedChan <- true
+			return
+		}
 	}
 }
 
@@ -394,16 +449,36 @@ func (a *audioStruct) loop() {
 // won't have issues with the interface going down while the app is running.
 func (a *audioStruct) initIfNeeded(devName string) error {
 	a.devName = devName
-	bufferSizeInBits := (audioSampleRate * audioSampleBytes * 8) / 1000 * pulseAudioBufferLength.Milliseconds()
+	if a.sess.conf.noSoundcard {
+		if a.play == nil {
+			a.play = make(chan []byte)
+			a.rec = make(chan []byte)
+			a.deinitNeededChan = make(chan bool)
+			a.deinitFinishedChan = make(chan bool)
+			go a.discardLoop()
+		}
+		return nil
+	}
+
+	f := a.sess.conf.virtualSoundcardFormat
+	bufferSizeInBits := f.getDataLength(pulseAudioBufferLength) * 8
+	name := a.sess.conf.virtualSoundcardName
+	if name == "" {
+		name = "kappanhang-" + a.devName
+	}
+	desc := a.sess.conf.virtualSoundcardDesc
+	if desc == "" {
+		desc = "kappanhang: " + a.devName
+	}
 
 	if !a.virtualSoundcardStream.source.IsOpen() {
-		a.virtualSoundcardStream.source.Name = "kappanhang-" + a.devName
-		a.virtualSoundcardStream.source.Filename = "/tmp/kappanhang-" + a.devName + ".source"
-		a.virtualSoundcardStream.source.Rate = audioSampleRate
-		a.virtualSoundcardStream.source.Format = "s16le"
+		a.virtualSoundcardStream.source.Name = name
+		a.virtualSoundcardStream.source.Filename = "/tmp/" + name + ".source"
+		a.virtualSoundcardStream.source.Rate = f.sampleRate
+		a.virtualSoundcardStream.source.Format = f.sampleFormat
 		a.virtualSoundcardStream.source.Channels = 1
 		a.virtualSoundcardStream.source.SetProperty("device.buffering.buffer_size", bufferSizeInBits)
-		a.virtualSoundcardStream.source.SetProperty("device.description", "kappanhang: "+a.devName)
+		a.virtualSoundcardStream.source.SetProperty("device.description", desc)
 
 		// Cleanup previous pipes.
 		sources, err := papipes.GetActiveSources()
@@ -421,14 +496,14 @@ func (a *audioStruct) initIfNeeded(devName string) error {
 	}
 
 	if !a.virtualSoundcardStream.sink.IsOpen() {
-		a.virtualSoundcardStream.sink.Name = "kappanhang-" + a.devName
-		a.virtualSoundcardStream.sink.Filename = "/tmp/kappanhang-" + a.devName + ".sink"
-		a.virtualSoundcardStream.sink.Rate = audioSampleRate
-		a.virtualSoundcardStream.sink.Format = "s16le"
+		a.virtualSoundcardStream.sink.Name = name
+		a.virtualSoundcardStream.sink.Filename = "/tmp/" + name + ".sink"
+		a.virtualSoundcardStream.sink.Rate = f.sampleRate
+		a.virtualSoundcardStream.sink.Format = f.sampleFormat
 		a.virtualSoundcardStream.sink.Channels = 1
 		a.virtualSoundcardStream.sink.UseSystemClockForTiming = true
 		a.virtualSoundcardStream.sink.SetProperty("device.buffering.buffer_size", bufferSizeInBits)
-		a.virtualSoundcardStream.sink.SetProperty("device.description", "kappanhang: "+a.devName)
+		a.virtualSoundcardStream.sink.SetProperty("device.description", desc)
 
 		// Cleanup previous pipes.
 		sinks, err := papipes.GetActiveSinks()
@@ -446,11 +521,14 @@ func (a *audioStruct) initIfNeeded(devName string) error {
 	}
 
 	if a.virtualSoundcardStream.playBuf == nil {
-		log.Print("opened device " + a.virtualSoundcardStream.source.Name)
+		log.Print("opened device " + a.virtualSoundcardStream.source.Name + " (" + f.String() + ")")
 
 		a.play = make(chan []byte)
 		a.rec = make(chan []byte)
 
+		a.virtualSoundcardStream.format = f
+		a.virtualSoundcardStream.encoder = newSoundcardEncoder(f)
+		a.virtualSoundcardStream.decoder = newSoundcardDecoder(f)
 		a.virtualSoundcardStream.playBuf = bytes.NewBuffer([]byte{})
 		a.defaultSoundcardStream.playBuf = bytes.NewBuffer([]byte{})
 		a.virtualSoundcardStream.canPlay = make(chan bool)
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The audio codecs which can be requested from the radio. The code is sent in the serial and audio stream
+// request packet. The stereo codecs (0x08, 0x10 and 0x20, used for dual watch) are not supported, as the
+// sound cards are mono, and mixing down the channels would lose the separation of the dual watch audio.
+type audioCodec struct {
+	name string
+	code byte
+	bits int
+	ulaw bool
+}
+
+var audioCodecs = []audioCodec{
+	{name: "ulaw", code: 0x01, bits: 8, ulaw: true},
+	{name: "pcm8", code: 0x02, bits: 8},
+	{name: "pcm16", code: 0x04, bits: 16},
+}
+
+var audioSampleRates = []int{8000, 16000, 24000, 48000}
+
+const defaultAudioCodec = "pcm16"
+
+func getAudioCodecNames() (res []string) {
+	for _, c := range audioCodecs {
+		res = append(res, c.name)
+	}
+	return
+}
+
+func getAudioCodec(name string) (audioCodec, error) {
+	for _, c := range audioCodecs {
+		if c.name == name {
+			return c, nil
+		}
+	}
+	return audioCodec{}, fmt.Errorf("unknown audio codec %s, available codecs: %s", name,
+		strings.Join(getAudioCodecNames(), ", "))
+}
+
+// The format of the audio transferred between the radio and kappanhang.
+type audioFormat struct {
+	codec      audioCodec
+	sampleRate int
+}
+
+func newAudioFormat(codecName string, sampleRate int) (f audioFormat, err error) {
+	if f.codec, err = getAudioCodec(codecName); err != nil {
+		return
+	}
+	for _, r := range audioSampleRates {
+		if r == sampleRate {
+			f.sampleRate = sampleRate
+			return
+		}
+	}
+	return f, fmt.Errorf("unsupported audio sample rate %d, available rates: %v", sampleRate, audioSampleRates)
+}
+
+// Returns the RX audio format from the 144 bytes long serial and audio stream request packet. See
+// controlStream.sendRequestSerialAndAudio() for the packet format.
+func parseStreamRequestAudioFormat(r []byte) (audioFormat, error) {
+	var codecName string
+	for _, c := range audioCodecs {
+		if c.code == r[114] {
+			codecName = c.name
+		}
+	}
+	return newAudioFormat(codecName, int(binary.BigEndian.Uint16(r[118:120])))
+}
+
+// Returns true if no conversion is needed between the radio and the sound cards.
+func (f audioFormat) isNative() bool {
+	return f.codec.name == defaultAudioCodec && f.sampleRate == audioSampleRate
+}
+
+// Returns the duration of l bytes of audio data in this format.
+func (f audioFormat) getDataDuration(l int) time.Duration {
+	bytesPerSec := f.sampleRate * f.codec.bits / 8
+	return time.Duration(l) * time.Second / time.Duration(bytesPerSec)
+}
+
+// Returns the length of audioFrameLength audio in this format.
+func (f audioFormat) getFrameLength() int {
+	return int(time.Duration(f.sampleRate*f.codec.bits/8) * audioFrameLength / time.Second)
+}
+
+// Returns the length of l bytes of audio data in this format after decoding it to 48kHz, s16le, mono.
+func (f audioFormat) getDecodedLength(l int) int {
+	return l / (f.codec.bits / 8) * (audioSampleRate / f.sampleRate) * audioSampleBytes
+}
+
+func (f audioFormat) String() string {
+	return fmt.Sprint(f.codec.name, " ", f.sampleRate/1000, "kHz")
+}
+
+// Converts between the audio format of the radio and the format of the sound cards (48kHz, s16le, mono).
+type audioConverter struct {
+	format audioFormat
+
+	lastRxSample int16 // Used for interpolation when upsampling.
+
+	// Used by the encoder before decimation to avoid aliasing, created on the first call.
+	txLowpass []biquadFilter
+}
+
+// Cutoff frequency of the lowpass filter which is used before decimating the TX audio, relative to the
+// radio's sample rate. It's a bit below the Nyquist frequency, as the filter does not have a steep slope.
+const audioEncoderLowpassRatio = 0.4
+
+// Returns the 48kHz, s16le, mono version of the audio data received from the radio in a buffer from
+// audioBufPool.
+func (c *audioConverter) decode(d []byte) []byte {
+	if c.format.isNative() {
+		res := audioBufPool.get(len(d))
+		copy(res, d)
+		return res
+	}
+
+	bytesPerSample := c.format.codec.bits / 8
+	upsampleRatio := audioSampleRate / c.format.sampleRate
+
+	res := audioBufPool.get(len(d) / bytesPerSample * upsampleRatio * audioSampleBytes)[:0]
+	for i := 0; i+bytesPerSample <= len(d); i += bytesPerSample {
+		sample := c.decodeSample(d[i:])
+
+		for j := 1; j <= upsampleRatio; j++ {
+			v := int(c.lastRxSample) + (int(sample)-int(c.lastRxSample))*j/upsampleRatio
+			res = append(res, byte(v), byte(v>>8))
+		}
+		c.lastRxSample = sample
+	}
+	return res
+}
+
+func (c *audioConverter) decodeSample(d []byte) int16 {
+	switch {
+	case c.format.codec.ulaw:
+		return ulawDecode(d[0])
+	case c.format.codec.bits == 8:
+		return (int16(d[0]) - 128) << 8
+	default:
+		return int16(binary.LittleEndian.Uint16(d))
+	}
+}
+
+// Returns the 48kHz, s16le, mono audio data converted to the TX format of the radio.
+func (c *audioConverter) encode(d []byte) []byte {
+	if c.format.isNative() {
+		return d
+	}
+
+	txCodec := c.format.codec
+	downsampleRatio := audioSampleRate / c.format.sampleRate
+	samplesLen := len(d) / audioSampleBytes
+
+	if downsampleRatio > 1 && c.txLowpass == nil { // 4th order, 24dB/octave.
+		cutoff := float64(c.format.sampleRate) * audioEncoderLowpassRatio
+		c.txLowpass = []biquadFilter{
+			newLowpassFilterForRate(cutoff, butterworthQ, audioSampleRate),
+			newLowpassFilterForRate(cutoff, butterworthQ, audioSampleRate),
+		}
+	}
+
+	res := make([]byte, 0, samplesLen/downsampleRatio*txCodec.bits/8)
+	for i := 0; i+downsampleRatio <= samplesLen; i += downsampleRatio {
+		// All samples go through the filter to keep its state, but only the last one is kept.
+		var sample int16
+		for j := 0; j < downsampleRatio; j++ {
+			sample = c.filterTx(int16(binary.LittleEndian.Uint16(d[(i+j)*audioSampleBytes:])))
+		}
+
+		switch {
+		case txCodec.ulaw:
+			res = append(res, ulawEncode(sample))
+		case txCodec.bits == 8:
+			res = append(res, byte(int(sample>>8)+128))
+		default:
+			res = append(res, byte(sample), byte(sample>>8))
+		}
+	}
+	return res
+}
+
+func (c *audioConverter) filterTx(sample int16) int16 {
+	if c.txLowpass == nil {
+		return sample
+	}
+	v := float64(sample) / 32768
+	for i := range c.txLowpass {
+		v = c.txLowpass[i].process(v)
+	}
+	return floatToSample(v)
+}
+
+const ulawBias = 0x84
+const ulawClip = 32635
+
+func ulawEncode(s int16) byte {
+	v := int(s)
+	var sign int
+	if v < 0 {
+		v = -v
+		sign = 0x80
+	}
+	if v > ulawClip {
+		v = ulawClip
+	}
+	v += ulawBias
+
+	exponent := 7
+	for mask := 0x4000; v&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (v >> (exponent + 3)) & 0x0f
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+func ulawDecode(u byte) int16 {
+	u = ^u
+	exponent := (u >> 4) & 0x07
+	mantissa := u & 0x0f
+	v := ((int(mantissa) << 3) + ulawBias) << exponent
+	v -= ulawBias
+	if u&0x80 != 0 {
+		return int16(-v)
+	}
+	return int16(v)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Levels below this are shown as this value.
+const audioLevelMinDBFS = -100
+
+// The peak level falls by this much per second after a peak.
+const audioMeterPeakFallDBPerSec = 20
+
+// Time constant of the RMS level averaging.
+const audioMeterRMSTimeConstant = 300 * time.Millisecond
+
+// If no audio arrives for this long, then the meter shows silence.
+const audioMeterTimeout = 500 * time.Millisecond
+
+// The bargraph shows the levels between audioMeterBarMinDBFS and 0 dBFS.
+const audioMeterBarMinDBFS = -60
+const audioMeterBarLength = 10
+
+func getDBFS(linear float64) float64 {
+	if linear <= 0 {
+		return audioLevelMinDBFS
+	}
+	return math.Max(20*math.Log10(linear), audioLevelMinDBFS)
+}
+
+// Returns the peak level of 48kHz, s16le, mono audio in dBFS.
+func getAudioPeakLevel(d []byte) float64 {
+	var peak int
+	for i := 0; i+audioSampleBytes <= len(d); i += audioSampleBytes {
+		s := int(int16(binary.LittleEndian.Uint16(d[i:])))
+		if s < 0 {
+			s = -s
+		}
+		if s > peak {
+			peak = s
+		}
+	}
+	return getDBFS(float64(peak) / 32768)
+}
+
+type audioLevelReport struct {
+	PeakDBFS float64 `json:"peak_dbfs"`
+	RMSDBFS  float64 `json:"rms_dbfs"`
+	Clips    int     `json:"clips"` // Count of audio frames with full scale samples.
+}
+
+// Measures the peak and RMS level of 48kHz, s16le, mono audio.
+type audioLevelMeter struct {
+	mutex      sync.Mutex
+	peak       float64 // Linear, 1 is full scale.
+	meanSquare float64
+	lastUpdate time.Time
+	clips      int
+}
+
+func (m *audioLevelMeter) process(d []byte) {
+	var peak int
+	var sumSquares float64
+	var clipped bool
+	samples := len(d) / audioSampleBytes
+	for i := 0; i+audioSampleBytes <= len(d); i += audioSampleBytes {
+		s := int(int16(binary.LittleEndian.Uint16(d[i:])))
+		if s >= math.MaxInt16 || s <= math.MinInt16 {
+			clipped = true
+		}
+		if s < 0 {
+			s = -s
+		}
+		if s > peak {
+			peak = s
+		}
+		sumSquares += float64(s) * float64(s)
+	}
+	if samples == 0 {
+		return
+	}
+	frameDuration := time.Duration(samples) * time.Second / audioSampleRate
+	framePeak := float64(peak) / 32768
+	frameMeanSquare := sumSquares / float64(samples) / (32768 * 32768)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if time.Since(m.lastUpdate) > audioMeterTimeout {
+		m.peak = 0
+		m.meanSquare = frameMeanSquare
+	}
+	m.peak *= math.Pow(10, -audioMeterPeakFallDBPerSec*frameDuration.Seconds()/20)
+	if framePeak > m.peak {
+		m.peak = framePeak
+	}
+	alpha := math.Min(float64(frameDuration)/float64(audioMeterRMSTimeConstant), 1)
+	m.meanSquare += alpha * (frameMeanSquare - m.meanSquare)
+	m.lastUpdate = time.Now()
+	if clipped {
+		m.clips++
+	}
+}
+
+func (m *audioLevelMeter) get() audioLevelReport {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	r := audioLevelReport{PeakDBFS: audioLevelMinDBFS, RMSDBFS: audioLevelMinDBFS, Clips: m.clips}
+	if time.Since(m.lastUpdate) <= audioMeterTimeout {
+		r.PeakDBFS = getDBFS(m.peak)
+		r.RMSDBFS = getDBFS(math.Sqrt(m.meanSquare))
+	}
+	return r
+}
+
+// Returns a bargraph like [####   |  ], # shows the RMS level, | shows the peak.
+func (r audioLevelReport) getBargraph() string {
+	getPos := func(dbfs float64) int {
+		return int(math.Round((dbfs - audioMeterBarMinDBFS) / -audioMeterBarMinDBFS * audioMeterBarLength))
+	}
+	rms := getPos(r.RMSDBFS)
+	peak := getPos(r.PeakDBFS)
+	var b strings.Builder
+	b.WriteByte('[')
+	for i := 1; i <= audioMeterBarLength; i++ {
+		switch {
+		case i <= rms:
+			b.WriteByte('#')
+		case i == peak:
+			b.WriteByte('|')
+		default:
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteByte(']')
+	return b.String()
+}
@@ -3,15 +3,15 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
-	"fmt"
 	"time"
 )
 
 const audioTimeoutDuration = 5 * time.Second
-const audioRxSeqBufLength = 100 * time.Millisecond
+const audioRxSeqBufLength = 100 * time.Millisecond // Initial length, it's adjusted by the jitter buffer.
+const maxAudioPacketDataLength = 1364
 
 type audioStream struct {
+	sess   *session
 	common streamCommon
 
 	deinitNeededChan   chan bool
@@ -24,18 +24,30 @@ type audioStream struct {
 
 	rxSeqBuf          seqBuf
 	rxSeqBufEntryChan chan seqBufEntry
+	jitterBuffer      jitterBufferStruct
+
+	converter audioConverter
+	concealer audioConcealer
+
+	// The position of the next received packet's data in the audio frame, used for calculating the length of
+	// lost packets.
+	rxFrameOffset int
 
 	audioSendSeq uint16
 }
 
-// sendPart1 expects 1364 bytes of PCM data.
-func (s *audioStream) sendPart1(pcmData []byte) error {
-	err := s.common.pkt0.sendTrackedPacket(&s.common,
-		append([]byte{0x6c, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-			byte(s.common.localSID >> 24), byte(s.common.localSID >> 16), byte(s.common.localSID >> 8), byte(s.common.localSID),
-			byte(s.common.remoteSID >> 24), byte(s.common.remoteSID >> 16), byte(s.common.remoteSID >> 8), byte(s.common.remoteSID),
-			0x80, 0x00, byte((s.audioSendSeq - 1) >> 8), byte(s.audioSendSeq - 1), 0x00, 0x00, byte(len(pcmData) >> 8), byte(len(pcmData))},
-			pcmData...))
+// sendAudioPacket expects at most maxAudioPacketDataLength bytes of audio data in the radio's format.
+// 48kHz 16 bit PCM frames are sent in a 1364 and a 556 bytes long packet.
+func (s *audioStream) sendAudioPacket(data []byte) error {
+	l := 24 + len(data)
+	p := pktBufPool.get(l)
+	copy(p, []byte{byte(l), byte(l >> 8), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		byte(s.common.localSID >> 24), byte(s.common.localSID >> 16), byte(s.common.localSID >> 8), byte(s.common.localSID),
+		byte(s.common.remoteSID >> 24), byte(s.common.remoteSID >> 16), byte(s.common.remoteSID >> 8), byte(s.common.remoteSID),
+		0x80, 0x00, byte((s.audioSendSeq - 1) >> 8), byte(s.audioSendSeq - 1), 0x00, 0x00, byte(len(data) >> 8), byte(len(data))})
+	copy(p[24:], data)
+	err := s.common.pkt0.sendTrackedPacket(&s.common, p)
+	pktBufPool.put(p)
 	if err != nil {
 		return err
 	}
@@ -43,17 +55,19 @@ func (s *audioStream) sendPart1(pcmData []byte) error {
 	return nil
 }
 
-// sendPart2 expects 556 bytes of PCM data.
-func (s *audioStream) sendPart2(pcmData []byte) error {
-	err := s.common.pkt0.sendTrackedPacket(&s.common, append([]byte{0x44, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		byte(s.common.localSID >> 24), byte(s.common.localSID >> 16), byte(s.common.localSID >> 8), byte(s.common.localSID),
-		byte(s.common.remoteSID >> 24), byte(s.common.remoteSID >> 16), byte(s.common.remoteSID >> 8), byte(s.common.remoteSID),
-		0x80, 0x00, byte((s.audioSendSeq - 1) >> 8), byte(s.audioSendSeq - 1), 0x00, 0x00, byte(len(pcmData) >> 8), byte(len(pcmData))},
-		pcmData...))
-	if err != nil {
-		return err
+// Converts a 48kHz s16le mono frame to the radio's format and sends it.
+func (s *audioStream) sendAudioFrame(frame []byte) error {
+	d := s.converter.encode(frame)
+	for len(d) > 0 {
+		n := len(d)
+		if n > maxAudioPacketDataLength {
+			n = maxAudioPacketDataLength
+		}
+		if err := s.sendAudioPacket(d[:n]); err != nil {
+			return err
+		}
+		d = d[n:]
 	}
-	s.audioSendSeq++
 	return nil
 }
 
@@ -63,6 +77,7 @@ func (s *audioStream) handleRxSeqBufEntry(e seqBufEntry) {
 		// Out of order packets can happen if we receive a retransmitted packet, but too late.
 		if s.rxSeqBuf.compareSeq(e.seq, seqNum(s.lastReceivedSeq)) != larger {
 			log.Debug("got out of order pkt seq #", e.seq)
+			pktBufPool.put(e.data)
 			return
 		}
 
@@ -74,18 +89,55 @@ func (s *audioStream) handleRxSeqBufEntry(e seqBufEntry) {
 			} else {
 				missingPkts = int(gotSeq) + 65536 - int(expectedSeq)
 			}
-			netstat.reportLoss(missingPkts)
-			log.Error("lost ", missingPkts, " audio packets")
-			s.serverAudioTime = s.serverAudioTime.Add(time.Duration(10*missingPkts) * time.Millisecond)
+			s.common.stats.reportLoss(missingPkts)
+			log.Error(s.sess.logPrefix(), "lost ", missingPkts, " audio packets")
+			s.concealLoss(missingPkts)
 		}
-		s.serverAudioTime = s.serverAudioTime.Add(10 * time.Millisecond)
+	}
+
+	// The seqbuf entries contain the whole packet.
+	data := e.data[24:]
+	d := s.converter.decode(data)
+
+	if s.receivedAudio {
+		s.serverAudioTime = s.serverAudioTime.Add(time.Duration(len(d)/audioSampleBytes) * time.Second / audioSampleRate)
 	} else {
 		s.serverAudioTime = time.Now()
 	}
 	s.lastReceivedSeq = gotSeq
 	s.receivedAudio = true
+	s.rxFrameOffset = (s.rxFrameOffset + len(data)) % s.converter.format.getFrameLength()
+	pktBufPool.put(e.data)
 
-	audio.play <- e.data
+	s.sess.audio.play <- s.concealer.process(d)
+}
+
+// Plays concealment audio with the length of the missing packets. The radio splits each audio frame to
+// packets with max. maxAudioPacketDataLength bytes of data, so the length of each missing packet can be
+// calculated from its position in the frame. The server audio time and the frame offset are always
+// advanced with the whole missing length, only the concealment audio is capped.
+func (s *audioStream) concealLoss(missingPkts int) {
+	frameLen := s.converter.format.getFrameLength()
+	var missingLen int
+	for i := 0; i < missingPkts; i++ {
+		l := frameLen - s.rxFrameOffset
+		if l > maxAudioPacketDataLength {
+			l = maxAudioPacketDataLength
+		}
+		missingLen += l
+		s.rxFrameOffset = (s.rxFrameOffset + l) % frameLen
+	}
+
+	missingDuration := s.converter.format.getDataDuration(missingLen)
+	s.serverAudioTime = s.serverAudioTime.Add(missingDuration)
+
+	sampleCount := s.converter.format.getDecodedLength(missingLen) / audioSampleBytes
+	if missingDuration > audioPLCMaxLength {
+		missingDuration = audioPLCMaxLength
+		sampleCount = int(audioPLCMaxLength * audioSampleRate / time.Second)
+	}
+	s.sess.netstat.reportConcealed(missingDuration)
+	s.sess.audio.play <- s.concealer.conceal(sampleCount)
 }
 
 // var drop int
@@ -112,13 +164,30 @@ func (s *audioStream) handleAudioPacket(r []byte) error {
 		s.timeoutTimer.Reset(audioTimeoutDuration)
 	}
 
-	return s.rxSeqBuf.add(seqNum(gotSeq), r[24:])
+	dataLen := len(r) - 24
+	depth := s.jitterBuffer.reportPacket(gotSeq, s.converter.format.getDataDuration(dataLen), dataLen == maxAudioPacketDataLength)
+	s.rxSeqBuf.setLength(depth)
+	s.sess.statusLog.reportJitterBuffer(depth)
+
+	s.common.stats.reportRxSeq(gotSeq, s.rxSeqBuf.isLate(seqNum(gotSeq)))
+	return s.rxSeqBuf.add(seqNum(gotSeq), r)
+}
+
+func (s *audioStream) requestRetransmit(r seqNumRange) error {
+	if err := s.common.requestRetransmit(r); err != nil {
+		return err
+	}
+	s.jitterBuffer.reportRetransmitRequest(r)
+	return nil
 }
 
 func (s *audioStream) handleRead(r []byte) error {
-	if len(r) >= 580 && (bytes.Equal(r[:6], []byte{0x6c, 0x05, 0x00, 0x00, 0x00, 0x00}) || bytes.Equal(r[:6], []byte{0x44, 0x02, 0x00, 0x00, 0x00, 0x00})) {
+	// The packet length depends on the audio format, 48kHz 16 bit PCM audio comes in 1388 and 580 bytes
+	// long packets.
+	if len(r) > 24 && binary.LittleEndian.Uint16(r[:2]) == uint16(len(r)) && bytes.Equal(r[2:6], []byte{0x00, 0x00, 0x00, 0x00}) {
 		return s.handleAudioPacket(r)
 	}
+	pktBufPool.put(r)
 	return nil
 }
 
@@ -127,19 +196,19 @@ func (s *audioStream) loop() {
 		select {
 		case r := <-s.common.readChan:
 			if err := s.handleRead(r); err != nil {
-				reportError(err)
+				s.sess.reportError(err)
 			}
 		case <-s.timeoutTimer.C:
-			reportError(errors.New(fmt.Sprint("audio stream timeout after ",
-				time.Since(statusLog.data.startTime), ", try rebooting the radio")))
+			s.sess.reportError(newConnError(connErrorTimeout, "audio stream timeout after ",
+				time.Since(s.sess.statusLog.data.startTime), ", try rebooting the radio"))
 		case e := <-s.rxSeqBufEntryChan:
 			s.handleRxSeqBufEntry(e)
-		case d := <-audio.rec:
-			if err := s.sendPart1(d[:1364]); err != nil {
-				reportError(err)
-			}
-			if err := s.sendPart2(d[1364:1920]); err != nil {
-				reportError(err)
+		case d := <-s.sess.audio.rec:
+			s.sess.txDSP.process(d)
+			s.sess.txAudioLevel.process(d)
+			s.sess.recorder.writeTx(d)
+			if err := s.sendAudioFrame(d); err != nil {
+				s.sess.reportError(err)
 			}
 		case <-s.deinitNeededChan:
 			s.deinitFinishedChan <- true
@@ -149,11 +218,14 @@ func (s *audioStream) loop() {
 }
 
 func (s *audioStream) init(devName string) error {
-	if err := s.common.init("audio", audioStreamPort); err != nil {
+	s.converter = audioConverter{format: s.sess.conf.audioFormat}
+	s.concealer.init(s.sess.conf.audioPLCMode)
+
+	if err := s.common.init(s.sess, "audio", s.sess.conf.audioStreamPort, s.sess.conf.localAudioStreamPort); err != nil {
 		return err
 	}
 
-	if err := audio.initIfNeeded(devName); err != nil {
+	if err := s.sess.audio.initIfNeeded(devName); err != nil {
 		return err
 	}
 
@@ -168,7 +240,8 @@ func (s *audioStream) init(devName string) error {
 	log.Print("stream started")
 
 	s.rxSeqBufEntryChan = make(chan seqBufEntry)
-	s.rxSeqBuf.init(audioRxSeqBufLength, 0xffff, 0, s.rxSeqBufEntryChan, s.common.requestRetransmit)
+	s.jitterBuffer.init(s.sess.conf.audioJitterBufferMin, s.sess.conf.audioJitterBufferMax)
+	s.rxSeqBuf.init(s.sess, s.jitterBuffer.getDepth(), 0xffff, 0, s.rxSeqBufEntryChan, s.requestRetransmit)
 
 	s.timeoutTimer = time.NewTimer(audioTimeoutDuration)
 
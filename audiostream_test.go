@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Feeds 48kHz 16 bit PCM audio packets through the receive path: streamCommon.read(), the seqbuf,
+// handleRxSeqBufEntry() and audio.play. The played audio is discarded.
+func BenchmarkAudioStreamRx(b *testing.B) {
+	audioFormat, err := newAudioFormat("pcm16", audioSampleRate)
+	if err != nil {
+		b.Fatal(err)
+	}
+	sess := &session{}
+	sess.init(sessionConfig{
+		audioFormat:          audioFormat,
+		audioJitterBufferMin: defaultAudioJitterBufferMin,
+		audioJitterBufferMax: defaultAudioJitterBufferMax,
+		audioPLCMode:         audioPLCModeSilence,
+		noSoundcard:          true,
+	})
+	if err := sess.audio.initIfNeeded("test"); err != nil {
+		b.Fatal(err)
+	}
+	defer sess.audio.deinit()
+
+	s := &audioStream{sess: sess}
+	radio := initTestStream(b, &s.common, sess, "audio")
+	defer s.common.conn.Close()
+	defer radio.Close()
+	s.converter = audioConverter{format: audioFormat}
+	s.concealer.init(audioPLCModeSilence)
+	s.rxSeqBufEntryChan = make(chan seqBufEntry)
+	s.jitterBuffer.init(defaultAudioJitterBufferMin, defaultAudioJitterBufferMax)
+	s.rxSeqBuf.init(sess, s.jitterBuffer.getDepth(), 0xffff, 0, s.rxSeqBufEntryChan, s.requestRetransmit)
+	defer s.rxSeqBuf.deinit()
+
+	// A 20ms frame is sent by the radio in two packets.
+	frameLen := audioFormat.getFrameLength()
+	packets := [][]byte{
+		make([]byte, 24+maxAudioPacketDataLength),
+		make([]byte, 24+frameLen-maxAudioPacketDataLength),
+	}
+	for _, p := range packets {
+		binary.LittleEndian.PutUint16(p[0:2], uint16(len(p)))
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(frameLen))
+	b.ResetTimer()
+	var seq uint16
+	for i := 0; i < b.N; i++ {
+		for _, p := range packets {
+			binary.LittleEndian.PutUint16(p[6:8], seq)
+			seq++
+			if _, err := radio.Write(p); err != nil {
+				b.Fatal(err)
+			}
+
+			r, err := s.common.read()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if !s.common.handleCommonPacket(r) {
+				b.Fatal("audio packet handled as a common packet")
+			}
+			if err := s.handleRead(r); err != nil {
+				b.Fatal(err)
+			}
+			s.handleRxSeqBufEntry(<-s.rxSeqBufEntryChan)
+		}
+	}
+}
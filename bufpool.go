@@ -0,0 +1,65 @@
+package main
+
+// Buffers on the packet receive path are reused, so the GC does not have to collect about 100 buffers
+// per second for each stream.
+//
+// Ownership rules:
+//  - A buffer returned by get() is owned by the caller. The owner can return it to the pool with put()
+//    when it's done with it, or it can hand the ownership over to someone else.
+//  - streamCommon.read() returns a pooled buffer. Packets sent to readChan are owned by the receiver.
+//  - seqBuf.add() takes over the ownership of the data, also if it drops it. Entries coming out of the
+//    seqbuf on entryChan are owned by the receiver.
+//  - txSeqBuf.add() copies the data to its own buffer, txSeqBuf.get() returns a pooled copy.
+//  - Audio sent to audio.play is owned by the receiver.
+//  - Only whole buffers can be returned to the pool, so keep the slice returned by get() (reslicing the
+//    end is fine). Buffers which are not returned are simply garbage collected, so it's always safe not to
+//    call put(), but a buffer must not be used after calling put() on it.
+
+// Max. size of a datagram received from the radio.
+const pktBufSize = 1500
+
+// Fits a 20ms frame of 48kHz, s16le, mono audio with room to spare.
+const audioBufSize = 4096
+
+// Max. count of free buffers kept in a pool.
+const bufPoolLength = 128
+
+type bufPool struct {
+	size int
+	free chan []byte
+}
+
+var pktBufPool = newBufPool(pktBufSize)
+var audioBufPool = newBufPool(audioBufSize)
+
+func newBufPool(size int) *bufPool {
+	return &bufPool{
+		size: size,
+		free: make(chan []byte, bufPoolLength),
+	}
+}
+
+// Returns a buffer with length l. Its contents are undefined. If l is larger than the size of the pool's
+// buffers, then a new buffer is allocated which won't be accepted by put().
+func (p *bufPool) get(l int) []byte {
+	if l > p.size {
+		return make([]byte, l)
+	}
+	select {
+	case b := <-p.free:
+		return b[:l]
+	default:
+		return make([]byte, l, p.size)
+	}
+}
+
+// Returns b to the pool. Buffers which were not allocated by the pool are ignored.
+func (p *bufPool) put(b []byte) {
+	if cap(b) != p.size {
+		return
+	}
+	select {
+	case p.free <- b[:0]:
+	default: // The pool is full, the buffer will be garbage collected.
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Captured packets are written to a pcapng file with synthesized IP and UDP headers, so Wireshark can
+// show the ports. Each packet has a comment with the decoded packet type.
+
+const pcapngLinkTypeRaw = 101
+
+type captureStruct struct {
+	mutex sync.Mutex
+	f     *os.File
+}
+
+var capture captureStruct
+
+func (c *captureStruct) writeBlock(blockType uint32, body []byte) error {
+	// Block total length includes the type, the two length fields and the padded body.
+	padding := (4 - len(body)%4) % 4
+	l := uint32(12 + len(body) + padding)
+	b := make([]byte, 0, l)
+	b = c.appendUint32(b, blockType)
+	b = c.appendUint32(b, l)
+	b = append(b, body...)
+	b = append(b, make([]byte, padding)...)
+	b = c.appendUint32(b, l)
+	_, err := c.f.Write(b)
+	return err
+}
+
+func (c *captureStruct) appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func (c *captureStruct) appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (c *captureStruct) appendOption(b []byte, code uint16, value []byte) []byte {
+	b = c.appendUint16(b, code)
+	b = c.appendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, (4-len(value)%4)%4)...)
+}
+
+func (c *captureStruct) writeHeader() error {
+	// Section header block.
+	var shb []byte
+	shb = c.appendUint32(shb, 0x1a2b3c4d)                             // Byte order magic.
+	shb = c.appendUint16(shb, 1)                                      // Major version.
+	shb = c.appendUint16(shb, 0)                                      // Minor version.
+	shb = append(shb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff) // Unspecified section length.
+	shb = c.appendOption(shb, 4, []byte(getAboutStr()))               // shb_userappl
+	shb = c.appendOption(shb, 0, nil)
+	if err := c.writeBlock(0x0a0d0d0a, shb); err != nil {
+		return err
+	}
+
+	// Interface description block.
+	var idb []byte
+	idb = c.appendUint16(idb, pcapngLinkTypeRaw)
+	idb = c.appendUint16(idb, 0) // Reserved.
+	idb = c.appendUint32(idb, 0) // No snap length limit.
+	idb = c.appendOption(idb, 0, nil)
+	return c.writeBlock(1, idb)
+}
+
+func (c *captureStruct) getIPv4Header(src, dst net.IP, payloadLen int) []byte {
+	l := 20 + payloadLen
+	h := []byte{0x45, 0x00, byte(l >> 8), byte(l), 0x00, 0x00, 0x40, 0x00, 64, 17, 0x00, 0x00}
+	h = append(h, src.To4()...)
+	h = append(h, dst.To4()...)
+
+	var sum uint32
+	for i := 0; i < len(h); i += 2 {
+		sum += uint32(h[i])<<8 | uint32(h[i+1])
+	}
+	for sum > 0xffff {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	binary.BigEndian.PutUint16(h[10:12], ^uint16(sum))
+	return h
+}
+
+func (c *captureStruct) getIPv6Header(src, dst net.IP, payloadLen int) []byte {
+	h := []byte{0x60, 0x00, 0x00, 0x00, byte(payloadLen >> 8), byte(payloadLen), 17, 64}
+	h = append(h, src.To16()...)
+	return append(h, dst.To16()...)
+}
+
+// Returns the datagram with IP and UDP headers.
+func (c *captureStruct) getIPPacket(src, dst *net.UDPAddr, d []byte) []byte {
+	udpLen := 8 + len(d)
+	udp := []byte{byte(src.Port >> 8), byte(src.Port), byte(dst.Port >> 8), byte(dst.Port),
+		byte(udpLen >> 8), byte(udpLen), 0x00, 0x00}
+
+	var p []byte
+	if src.IP.To4() != nil && dst.IP.To4() != nil {
+		p = c.getIPv4Header(src.IP, dst.IP, udpLen)
+	} else {
+		p = c.getIPv6Header(src.IP, dst.IP, udpLen)
+	}
+	p = append(p, udp...)
+	return append(p, d...)
+}
+
+// Records a datagram sent or received by the given stream.
+func (c *captureStruct) write(s *streamCommon, d []byte, outgoing bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.f == nil {
+		return
+	}
+
+	local, _ := s.conn.LocalAddr().(*net.UDPAddr)
+	remote, _ := s.conn.RemoteAddr().(*net.UDPAddr)
+	if local == nil || remote == nil {
+		return
+	}
+	var p []byte
+	if outgoing {
+		p = c.getIPPacket(local, remote, d)
+	} else {
+		p = c.getIPPacket(remote, local, d)
+	}
+
+	ts := uint64(time.Now().UnixNano() / 1000)
+	var epb []byte
+	epb = c.appendUint32(epb, 0) // Interface ID.
+	epb = c.appendUint32(epb, uint32(ts>>32))
+	epb = c.appendUint32(epb, uint32(ts))
+	epb = c.appendUint32(epb, uint32(len(p)))
+	epb = c.appendUint32(epb, uint32(len(p)))
+	epb = append(epb, p...)
+	epb = append(epb, make([]byte, (4-len(p)%4)%4)...)
+	epb = c.appendOption(epb, 1, []byte(s.name+": "+getPacketAnnotation(s.kind, d, outgoing))) // opt_comment
+	epb = c.appendOption(epb, 0, nil)
+
+	if err := c.writeBlock(6, epb); err != nil {
+		log.Error("can't write capture file: ", err)
+		c.f.Close()
+		c.f = nil
+	}
+}
+
+func (c *captureStruct) init(path string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var err error
+	c.f, err = os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := c.writeHeader(); err != nil {
+		c.f.Close()
+		c.f = nil
+		return err
+	}
+	log.Print("capturing packets to ", path)
+	return nil
+}
+
+func (c *captureStruct) deinit() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.f != nil {
+		c.f.Close()
+		c.f = nil
+	}
+}
+
+func formatHex(d []byte) string {
+	var sb strings.Builder
+	for i, b := range d {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%.2X", b)
+	}
+	return sb.String()
+}
+
+// Returns a short description of the packet. See the example packets in the stream handlers for the
+// packet formats.
+func getPacketAnnotation(streamKind string, d []byte, outgoing bool) string {
+	if len(d) < 16 {
+		return fmt.Sprint("short packet, len=", len(d))
+	}
+	pktType := binary.LittleEndian.Uint16(d[4:6])
+	seq := binary.LittleEndian.Uint16(d[6:8])
+
+	replyStr := ""
+	if !outgoing {
+		replyStr = " reply"
+	}
+
+	switch {
+	case len(d) == 21 && pktType == 0x07:
+		if d[16] == 0x01 {
+			return fmt.Sprint("pkt7 ping seq=", seq, " reply")
+		}
+		return fmt.Sprint("pkt7 ping seq=", seq, " request")
+	case len(d) == 16 && pktType == 0x00:
+		return fmt.Sprint("idle seq=", seq)
+	case len(d) == 16 && pktType == 0x01:
+		return fmt.Sprint("retransmit request ", seq)
+	case len(d) >= 20 && d[0] == 0x18 && pktType == 0x01:
+		return fmt.Sprint("retransmit request ", binary.LittleEndian.Uint16(d[16:18]), "-",
+			binary.LittleEndian.Uint16(d[18:20]))
+	case len(d) == 16 && pktType == 0x03:
+		return "are you there"
+	case len(d) == 16 && pktType == 0x04:
+		return "i am here"
+	case len(d) == 16 && pktType == 0x05:
+		return "disconnect"
+	case len(d) == 16 && pktType == 0x06:
+		if outgoing {
+			return "are you ready"
+		}
+		return "i am ready"
+	}
+
+	switch {
+	case streamKind == "serial":
+		if len(d) >= 21 && d[16] == 0xc1 && int(d[17]) == len(d)-21 {
+			return "CI-V " + formatHex(d[21:])
+		}
+		if len(d) == 22 && d[16] == 0xc0 {
+			if d[21] == 0x00 {
+				return "serial close"
+			}
+			return "serial open"
+		}
+	case streamKind == "audio":
+		if len(d) > 24 {
+			return fmt.Sprint("audio seq=", seq, " len=", len(d)-24)
+		}
+	default:
+		switch {
+		case len(d) == 128 && d[0] == 0x80:
+			return "login"
+		case len(d) == 96 && d[0] == 0x60:
+			if bytes.Equal(d[48:52], []byte{0xff, 0xff, 0xff, 0xfe}) {
+				return "login reply, invalid username/password"
+			}
+			return "login reply"
+		case len(d) == 64 && d[0] == 0x40:
+			switch d[21] {
+			case 0x01:
+				return "deauth" + replyStr
+			case 0x02:
+				return "auth" + replyStr
+			case 0x05:
+				return "reauth" + replyStr
+			}
+		case len(d) == 168 && d[0] == 0xa8:
+			return "capabilities of " + parseNullTerminatedString(d[82:114])
+		case len(d) == 144 && d[0] == 0x90:
+			if outgoing {
+				return "serial and audio stream request"
+			}
+			return fmt.Sprint("connection info, busy=", d[96], " client=", parseNullTerminatedString(d[100:116]),
+				" ip=", net.IP(d[132:136]))
+		case len(d) == 80 && d[0] == 0x50:
+			return "status"
+		}
+	}
+	return fmt.Sprint("pkt type=", pktType, " seq=", seq, " len=", len(d))
+}
@@ -46,10 +46,9 @@ var civFilters = []civFilter{
 type civBand struct {
 	freqFrom uint
 	freqTo   uint
-	freq     uint
 }
 
-var civBands = []civBand{
+var civBands = [...]civBand{
 	{freqFrom: 1800000, freqTo: 1999999},     // 1.9
 	{freqFrom: 3400000, freqTo: 4099999},     // 3.5
 	{freqFrom: 6900000, freqTo: 7499999},     // 7
@@ -84,7 +83,9 @@ type civCmd struct {
 }
 
 type civControlStruct struct {
+	sess               *session
 	st                 *serialStream
+	civAddress         byte
 	deinitNeeded       chan bool
 	deinitFinished     chan bool
 	resetSReadTimer    chan bool
@@ -165,8 +166,6 @@ type civControlStruct struct {
 	}
 }
 
-var civControl civControlStruct
-
 // Returns false if the message should not be forwarded to the serial port TCP server or the virtual serial port.
 func (s *civControlStruct) decode(d []byte) bool {
 	if len(d) < 6 || d[0] != 0xfe || d[1] != 0xfe || d[len(d)-1] != 0xfd {
@@ -234,13 +233,13 @@ func (s *civControlStruct) decodeFreqData(d []byte) (f uint) {
 // 	}
 
 // 	s.state.freq = s.decodeFreqData(d)
-// 	statusLog.reportFrequency(s.state.freq)
+// 	s.sess.statusLog.reportFrequency(s.state.freq)
 
 // 	s.state.bandIdx = len(civBands) - 1 // Set the band idx to GENE by default.
 // 	for i := range civBands {
 // 		if s.state.freq >= civBands[i].freqFrom && s.state.freq <= civBands[i].freqTo {
 // 			s.state.bandIdx = i
-// 			civBands[s.state.bandIdx].freq = s.state.freq
+// 			s.sess.bandFreqs[s.state.bandIdx] = s.state.freq
 // 			break
 // 		}
 // 	}
@@ -280,7 +279,9 @@ func (s *civControlStruct) decodeMode(d []byte) bool {
 	if len(d) > 1 {
 		s.state.filterIdx = s.decodeFilterValueToFilterIdx(d[1])
 	}
-	statusLog.reportMode(civOperatingModes[s.state.operatingModeIdx].name, s.state.dataMode,
+	s.sess.statusLog.reportMode(civOperatingModes[s.state.operatingModeIdx].name, s.state.dataMode,
+		civFilters[s.state.filterIdx].name)
+	s.sess.metrics.reportMode(civOperatingModes[s.state.operatingModeIdx].name, s.state.dataMode,
 		civFilters[s.state.filterIdx].name)
 
 	if s.state.setMode.pending {
@@ -297,10 +298,10 @@ func (s *civControlStruct) decodeVFO(d []byte) bool {
 
 	if d[0] == 1 {
 		s.state.vfoBActive = true
-		log.Print("active vfo: B")
+		log.Print(s.sess.logPrefix(), "active vfo: B")
 	} else {
 		s.state.vfoBActive = false
-		log.Print("active vfo: A")
+		log.Print(s.sess.logPrefix(), "active vfo: A")
 	}
 
 	if s.state.setVFO.pending {
@@ -331,7 +332,7 @@ func (s *civControlStruct) decodeSplit(d []byte) bool {
 		s.state.splitMode = splitModeDUPPlus
 		str = "DUP+"
 	}
-	statusLog.reportSplit(s.state.splitMode, str)
+	s.sess.statusLog.reportSplit(s.state.splitMode, str)
 
 	if s.state.getSplit.pending {
 		s.removePendingCmd(&s.state.getSplit)
@@ -381,7 +382,7 @@ func (s *civControlStruct) decodeTS(d []byte) bool {
 	case 13:
 		s.state.ts = 100000
 	}
-	statusLog.reportTS(s.state.ts)
+	s.sess.statusLog.reportTS(s.state.ts)
 
 	if s.state.getTS.pending {
 		s.removePendingCmd(&s.state.getTS)
@@ -407,7 +408,9 @@ func (s *civControlStruct) decodeDataModeAndOVF(d []byte) bool {
 			s.state.dataMode = false
 		}
 
-		statusLog.reportMode(civOperatingModes[s.state.operatingModeIdx].name, s.state.dataMode,
+		s.sess.statusLog.reportMode(civOperatingModes[s.state.operatingModeIdx].name, s.state.dataMode,
+			civFilters[s.state.filterIdx].name)
+		s.sess.metrics.reportMode(civOperatingModes[s.state.operatingModeIdx].name, s.state.dataMode,
 			civFilters[s.state.filterIdx].name)
 
 		if s.state.setDataMode.pending {
@@ -419,9 +422,9 @@ func (s *civControlStruct) decodeDataModeAndOVF(d []byte) bool {
 			return !s.state.getOVF.pending
 		}
 		if d[1] != 0 {
-			statusLog.reportOVF(true)
+			s.sess.statusLog.reportOVF(true)
 		} else {
-			statusLog.reportOVF(false)
+			s.sess.statusLog.reportOVF(false)
 		}
 		s.state.lastOVFReceivedAt = time.Now()
 		if s.state.getOVF.pending {
@@ -440,7 +443,7 @@ func (s *civControlStruct) decodePowerRFGainSQLNRPwr(d []byte) bool {
 		}
 		hex := uint16(d[1])<<8 | uint16(d[2])
 		s.state.rfGainPercent = int(math.Round((float64(hex) / 0x0255) * 100))
-		statusLog.reportRFGain(s.state.rfGainPercent)
+		s.sess.statusLog.reportRFGain(s.state.rfGainPercent)
 		if s.state.getRFGain.pending {
 			s.removePendingCmd(&s.state.getRFGain)
 			return false
@@ -455,7 +458,7 @@ func (s *civControlStruct) decodePowerRFGainSQLNRPwr(d []byte) bool {
 		}
 		hex := uint16(d[1])<<8 | uint16(d[2])
 		s.state.sqlPercent = int(math.Round((float64(hex) / 0x0255) * 100))
-		statusLog.reportSQL(s.state.sqlPercent)
+		s.sess.statusLog.reportSQL(s.state.sqlPercent)
 		if s.state.getSQL.pending {
 			s.removePendingCmd(&s.state.getSQL)
 			return false
@@ -470,7 +473,7 @@ func (s *civControlStruct) decodePowerRFGainSQLNRPwr(d []byte) bool {
 		}
 		hex := uint16(d[1])<<8 | uint16(d[2])
 		s.state.nrPercent = int(math.Round((float64(hex) / 0x0255) * 100))
-		statusLog.reportNR(s.state.nrPercent)
+		s.sess.statusLog.reportNR(s.state.nrPercent)
 		if s.state.getNR.pending {
 			s.removePendingCmd(&s.state.getNR)
 			return false
@@ -485,7 +488,8 @@ func (s *civControlStruct) decodePowerRFGainSQLNRPwr(d []byte) bool {
 		}
 		hex := uint16(d[1])<<8 | uint16(d[2])
 		s.state.pwrPercent = int(math.Round((float64(hex) / 0x0255) * 100))
-		statusLog.reportTxPower(s.state.pwrPercent)
+		s.sess.statusLog.reportTxPower(s.state.pwrPercent)
+		s.sess.metrics.reportTxPower(s.state.pwrPercent)
 		if s.state.getPwr.pending {
 			s.removePendingCmd(&s.state.getPwr)
 			return false
@@ -516,7 +520,8 @@ func (s *civControlStruct) decodeTransmitStatus(d []byte) bool {
 				_ = s.getVd()
 			}
 		}
-		statusLog.reportPTT(s.state.ptt, s.state.tune)
+		s.sess.statusLog.reportPTT(s.state.ptt, s.state.tune)
+		s.sess.metrics.reportPTT(s.state.ptt, s.state.tune)
 		if s.state.setPTT.pending {
 			s.removePendingCmd(&s.state.setPTT)
 			return false
@@ -537,7 +542,8 @@ func (s *civControlStruct) decodeTransmitStatus(d []byte) bool {
 			}
 		}
 
-		statusLog.reportPTT(s.state.ptt, s.state.tune)
+		s.sess.statusLog.reportPTT(s.state.ptt, s.state.tune)
+		s.sess.metrics.reportPTT(s.state.ptt, s.state.tune)
 		if s.state.setTune.pending {
 			s.removePendingCmd(&s.state.setTune)
 			return false
@@ -592,7 +598,8 @@ func (s *civControlStruct) decodeVdSWRS(d []byte) bool {
 			}
 		}
 		s.state.lastSReceivedAt = time.Now()
-		statusLog.reportS(sStr)
+		s.sess.statusLog.reportS(sStr)
+		s.sess.metrics.reportS(sValue)
 		if s.state.getS.pending {
 			s.removePendingCmd(&s.state.getS)
 			return false
@@ -602,7 +609,9 @@ func (s *civControlStruct) decodeVdSWRS(d []byte) bool {
 			return !s.state.getSWR.pending
 		}
 		s.state.lastSWRReceivedAt = time.Now()
-		statusLog.reportSWR(((float64(int(d[1])<<8)+float64(d[2]))/0x0120)*2 + 1)
+		swr := ((float64(int(d[1])<<8)+float64(d[2]))/0x0120)*2 + 1
+		s.sess.statusLog.reportSWR(swr)
+		s.sess.metrics.reportSWR(swr)
 		if s.state.getSWR.pending {
 			s.removePendingCmd(&s.state.getSWR)
 			return false
@@ -611,7 +620,9 @@ func (s *civControlStruct) decodeVdSWRS(d []byte) bool {
 		if len(d) < 3 {
 			return !s.state.getVd.pending
 		}
-		statusLog.reportVd(((float64(int(d[1])<<8) + float64(d[2])) / 0x0241) * 16)
+		vd := ((float64(int(d[1])<<8) + float64(d[2])) / 0x0241) * 16
+		s.sess.statusLog.reportVd(vd)
+		s.sess.metrics.reportVd(vd)
 		if s.state.getVd.pending {
 			s.removePendingCmd(&s.state.getVd)
 			return false
@@ -627,7 +638,7 @@ func (s *civControlStruct) decodePreampAGCNREnabled(d []byte) bool {
 			return !s.state.getPreamp.pending && !s.state.setPreamp.pending
 		}
 		s.state.preamp = int(d[1])
-		statusLog.reportPreamp(s.state.preamp)
+		s.sess.statusLog.reportPreamp(s.state.preamp)
 		if s.state.getPreamp.pending {
 			s.removePendingCmd(&s.state.getPreamp)
 			return false
@@ -650,7 +661,7 @@ func (s *civControlStruct) decodePreampAGCNREnabled(d []byte) bool {
 		case 3:
 			agc = "S"
 		}
-		statusLog.reportAGC(agc)
+		s.sess.statusLog.reportAGC(agc)
 		if s.state.getAGC.pending {
 			s.removePendingCmd(&s.state.getAGC)
 			return false
@@ -668,7 +679,7 @@ func (s *civControlStruct) decodePreampAGCNREnabled(d []byte) bool {
 		} else {
 			s.state.nrEnabled = false
 		}
-		statusLog.reportNREnabled(s.state.nrEnabled)
+		s.sess.statusLog.reportNREnabled(s.state.nrEnabled)
 		if s.state.getNREnabled.pending {
 			s.removePendingCmd(&s.state.getNREnabled)
 			return false
@@ -690,13 +701,14 @@ func (s *civControlStruct) decodeVFOFreq(d []byte) bool {
 	switch d[0] {
 	default:
 		s.state.freq = f
-		statusLog.reportFrequency(s.state.freq)
+		s.sess.statusLog.reportFrequency(s.state.freq)
+		s.sess.metrics.reportFrequency(s.state.freq)
 
 		s.state.bandIdx = len(civBands) - 1 // Set the band idx to GENE by default.
 		for i := range civBands {
 			if s.state.freq >= civBands[i].freqFrom && s.state.freq <= civBands[i].freqTo {
 				s.state.bandIdx = i
-				civBands[s.state.bandIdx].freq = s.state.freq
+				s.sess.bandFreqs[s.state.bandIdx] = s.state.freq
 				break
 			}
 		}
@@ -711,7 +723,7 @@ func (s *civControlStruct) decodeVFOFreq(d []byte) bool {
 		}
 	case 0x01:
 		s.state.subFreq = f
-		statusLog.reportSubFrequency(s.state.subFreq)
+		s.sess.statusLog.reportSubFrequency(s.state.subFreq)
 		if s.state.getSubVFOFreq.pending {
 			s.removePendingCmd(&s.state.getSubVFOFreq)
 			return false
@@ -752,7 +764,9 @@ func (s *civControlStruct) decodeVFOMode(d []byte) bool {
 		if filterIdx >= 0 {
 			s.state.filterIdx = filterIdx
 		}
-		statusLog.reportMode(civOperatingModes[s.state.operatingModeIdx].name, s.state.dataMode,
+		s.sess.statusLog.reportMode(civOperatingModes[s.state.operatingModeIdx].name, s.state.dataMode,
+			civFilters[s.state.filterIdx].name)
+		s.sess.metrics.reportMode(civOperatingModes[s.state.operatingModeIdx].name, s.state.dataMode,
 			civFilters[s.state.filterIdx].name)
 
 		if s.state.getMainVFOMode.pending {
@@ -763,7 +777,7 @@ func (s *civControlStruct) decodeVFOMode(d []byte) bool {
 		s.state.subOperatingModeIdx = operatingModeIdx
 		s.state.subDataMode = dataMode
 		s.state.subFilterIdx = filterIdx
-		statusLog.reportSubMode(civOperatingModes[s.state.subOperatingModeIdx].name, s.state.subDataMode,
+		s.sess.statusLog.reportSubMode(civOperatingModes[s.state.subOperatingModeIdx].name, s.state.subDataMode,
 			civFilters[s.state.subFilterIdx].name)
 
 		if s.state.getSubVFOMode.pending {
@@ -823,7 +837,7 @@ func (s *civControlStruct) sendCmd(cmd *civCmd) error {
 
 func (s *civControlStruct) setPwr(percent int) error {
 	v := uint16(0x0255 * (float64(percent) / 100))
-	s.initCmd(&s.state.setPwr, "setPwr", []byte{254, 254, civAddress, 224, 0x14, 0x0a, byte(v >> 8), byte(v & 0xff), 253})
+	s.initCmd(&s.state.setPwr, "setPwr", []byte{254, 254, s.civAddress, 224, 0x14, 0x0a, byte(v >> 8), byte(v & 0xff), 253})
 	return s.sendCmd(&s.state.setPwr)
 }
 
@@ -843,7 +857,7 @@ func (s *civControlStruct) decPwr() error {
 
 func (s *civControlStruct) setRFGain(percent int) error {
 	v := uint16(0x0255 * (float64(percent) / 100))
-	s.initCmd(&s.state.setRFGain, "setRFGain", []byte{254, 254, civAddress, 224, 0x14, 0x02, byte(v >> 8), byte(v & 0xff), 253})
+	s.initCmd(&s.state.setRFGain, "setRFGain", []byte{254, 254, s.civAddress, 224, 0x14, 0x02, byte(v >> 8), byte(v & 0xff), 253})
 	return s.sendCmd(&s.state.setRFGain)
 }
 
@@ -863,7 +877,7 @@ func (s *civControlStruct) decRFGain() error {
 
 func (s *civControlStruct) setSQL(percent int) error {
 	v := uint16(0x0255 * (float64(percent) / 100))
-	s.initCmd(&s.state.setSQL, "setSQL", []byte{254, 254, civAddress, 224, 0x14, 0x03, byte(v >> 8), byte(v & 0xff), 253})
+	s.initCmd(&s.state.setSQL, "setSQL", []byte{254, 254, s.civAddress, 224, 0x14, 0x03, byte(v >> 8), byte(v & 0xff), 253})
 	return s.sendCmd(&s.state.setSQL)
 }
 
@@ -888,7 +902,7 @@ func (s *civControlStruct) setNR(percent int) error {
 		}
 	}
 	v := uint16(0x0255 * (float64(percent) / 100))
-	s.initCmd(&s.state.setNR, "setNR", []byte{254, 254, civAddress, 224, 0x14, 0x06, byte(v >> 8), byte(v & 0xff), 253})
+	s.initCmd(&s.state.setNR, "setNR", []byte{254, 254, s.civAddress, 224, 0x14, 0x06, byte(v >> 8), byte(v & 0xff), 253})
 	return s.sendCmd(&s.state.setNR)
 }
 
@@ -944,13 +958,13 @@ func (s *civControlStruct) encodeFreqData(f uint) (b [5]byte) {
 
 func (s *civControlStruct) setMainVFOFreq(f uint) error {
 	b := s.encodeFreqData(f)
-	s.initCmd(&s.state.setMainVFOFreq, "setMainVFOFreq", []byte{254, 254, civAddress, 224, 0x25, 0x00, b[0], b[1], b[2], b[3], b[4], 253})
+	s.initCmd(&s.state.setMainVFOFreq, "setMainVFOFreq", []byte{254, 254, s.civAddress, 224, 0x25, 0x00, b[0], b[1], b[2], b[3], b[4], 253})
 	return s.sendCmd(&s.state.setMainVFOFreq)
 }
 
 func (s *civControlStruct) setSubVFOFreq(f uint) error {
 	b := s.encodeFreqData(f)
-	s.initCmd(&s.state.setSubVFOFreq, "setSubVFOFreq", []byte{254, 254, civAddress, 224, 0x25, 0x01, b[0], b[1], b[2], b[3], b[4], 253})
+	s.initCmd(&s.state.setSubVFOFreq, "setSubVFOFreq", []byte{254, 254, s.civAddress, 224, 0x25, 0x01, b[0], b[1], b[2], b[3], b[4], 253})
 	return s.sendCmd(&s.state.setSubVFOFreq)
 }
 
@@ -959,7 +973,7 @@ func (s *civControlStruct) incOperatingMode() error {
 	if s.state.operatingModeIdx >= len(civOperatingModes) {
 		s.state.operatingModeIdx = 0
 	}
-	return civControl.setOperatingModeAndFilter(civOperatingModes[s.state.operatingModeIdx].code,
+	return s.setOperatingModeAndFilter(civOperatingModes[s.state.operatingModeIdx].code,
 		civFilters[s.state.filterIdx].code)
 }
 
@@ -968,7 +982,7 @@ func (s *civControlStruct) decOperatingMode() error {
 	if s.state.operatingModeIdx < 0 {
 		s.state.operatingModeIdx = len(civOperatingModes) - 1
 	}
-	return civControl.setOperatingModeAndFilter(civOperatingModes[s.state.operatingModeIdx].code,
+	return s.setOperatingModeAndFilter(civOperatingModes[s.state.operatingModeIdx].code,
 		civFilters[s.state.filterIdx].code)
 }
 
@@ -977,7 +991,7 @@ func (s *civControlStruct) incFilter() error {
 	if s.state.filterIdx >= len(civFilters) {
 		s.state.filterIdx = 0
 	}
-	return civControl.setOperatingModeAndFilter(civOperatingModes[s.state.operatingModeIdx].code,
+	return s.setOperatingModeAndFilter(civOperatingModes[s.state.operatingModeIdx].code,
 		civFilters[s.state.filterIdx].code)
 }
 
@@ -986,12 +1000,12 @@ func (s *civControlStruct) decFilter() error {
 	if s.state.filterIdx < 0 {
 		s.state.filterIdx = len(civFilters) - 1
 	}
-	return civControl.setOperatingModeAndFilter(civOperatingModes[s.state.operatingModeIdx].code,
+	return s.setOperatingModeAndFilter(civOperatingModes[s.state.operatingModeIdx].code,
 		civFilters[s.state.filterIdx].code)
 }
 
 func (s *civControlStruct) setOperatingModeAndFilter(modeCode, filterCode byte) error {
-	s.initCmd(&s.state.setMode, "setMode", []byte{254, 254, civAddress, 224, 0x06, modeCode, filterCode, 253})
+	s.initCmd(&s.state.setMode, "setMode", []byte{254, 254, s.civAddress, 224, 0x06, modeCode, filterCode, 253})
 	if err := s.sendCmd(&s.state.setMode); err != nil {
 		return err
 	}
@@ -999,7 +1013,7 @@ func (s *civControlStruct) setOperatingModeAndFilter(modeCode, filterCode byte)
 }
 
 func (s *civControlStruct) setSubVFOMode(modeCode, dataMode, filterCode byte) error {
-	s.initCmd(&s.state.setSubVFOMode, "setSubVFOMode", []byte{254, 254, civAddress, 224, 0x26, 0x01, modeCode, dataMode, filterCode, 253})
+	s.initCmd(&s.state.setSubVFOMode, "setSubVFOMode", []byte{254, 254, s.civAddress, 224, 0x26, 0x01, modeCode, dataMode, filterCode, 253})
 	return s.sendCmd(&s.state.setSubVFOMode)
 }
 
@@ -1011,10 +1025,20 @@ func (s *civControlStruct) setPTT(enable bool) error {
 			_ = s.setPTT(false)
 		})
 	}
-	s.initCmd(&s.state.setPTT, "setPTT", []byte{254, 254, civAddress, 224, 0x1c, 0, b, 253})
+	s.initCmd(&s.state.setPTT, "setPTT", []byte{254, 254, s.civAddress, 224, 0x1c, 0, b, 253})
 	return s.sendCmd(&s.state.setPTT)
 }
 
+// Turns PTT on or off, data mode is also enabled before turning PTT on if it's set in the config.
+func (s *civControlStruct) setPTTWithDataMode(enable bool) error {
+	if enable && s.sess.conf.setDataModeOnTx {
+		if err := s.setDataMode(true); err != nil {
+			log.Error("can't enable data mode: ", err)
+		}
+	}
+	return s.setPTT(enable)
+}
+
 func (s *civControlStruct) setTune(enable bool) error {
 	if s.state.ptt {
 		return nil
@@ -1029,7 +1053,7 @@ func (s *civControlStruct) setTune(enable bool) error {
 	} else {
 		b = 1
 	}
-	s.initCmd(&s.state.setTune, "setTune", []byte{254, 254, civAddress, 224, 0x1c, 1, b, 253})
+	s.initCmd(&s.state.setTune, "setTune", []byte{254, 254, s.civAddress, 224, 0x1c, 1, b, 253})
 	return s.sendCmd(&s.state.setTune)
 }
 
@@ -1047,7 +1071,7 @@ func (s *civControlStruct) setDataMode(enable bool) error {
 		b = 0
 		f = 0
 	}
-	s.initCmd(&s.state.setDataMode, "setDataMode", []byte{254, 254, civAddress, 224, 0x1a, 0x06, b, f, 253})
+	s.initCmd(&s.state.setDataMode, "setDataMode", []byte{254, 254, s.civAddress, 224, 0x1a, 0x06, b, f, 253})
 	return s.sendCmd(&s.state.setDataMode)
 }
 
@@ -1060,7 +1084,7 @@ func (s *civControlStruct) incBand() error {
 	if i >= len(civBands) {
 		i = 0
 	}
-	f := civBands[i].freq
+	f := s.sess.bandFreqs[i]
 	if f == 0 {
 		f = (civBands[i].freqFrom + civBands[i].freqTo) / 2
 	}
@@ -1072,7 +1096,7 @@ func (s *civControlStruct) decBand() error {
 	if i < 0 {
 		i = len(civBands) - 1
 	}
-	f := civBands[i].freq
+	f := s.sess.bandFreqs[i]
 	if f == 0 {
 		f = civBands[i].freqFrom
 	}
@@ -1084,7 +1108,7 @@ func (s *civControlStruct) togglePreamp() error {
 	if b > 2 {
 		b = 0
 	}
-	s.initCmd(&s.state.setPreamp, "setPreamp", []byte{254, 254, civAddress, 224, 0x16, 0x02, b, 253})
+	s.initCmd(&s.state.setPreamp, "setPreamp", []byte{254, 254, s.civAddress, 224, 0x16, 0x02, b, 253})
 	return s.sendCmd(&s.state.setPreamp)
 }
 
@@ -1093,7 +1117,7 @@ func (s *civControlStruct) toggleAGC() error {
 	if b > 3 {
 		b = 1
 	}
-	s.initCmd(&s.state.setAGC, "setAGC", []byte{254, 254, civAddress, 224, 0x16, 0x12, b, 253})
+	s.initCmd(&s.state.setAGC, "setAGC", []byte{254, 254, s.civAddress, 224, 0x16, 0x12, b, 253})
 	return s.sendCmd(&s.state.setAGC)
 }
 
@@ -1102,12 +1126,12 @@ func (s *civControlStruct) toggleNR() error {
 	if !s.state.nrEnabled {
 		b = 1
 	}
-	s.initCmd(&s.state.setNREnabled, "setNREnabled", []byte{254, 254, civAddress, 224, 0x16, 0x40, b, 253})
+	s.initCmd(&s.state.setNREnabled, "setNREnabled", []byte{254, 254, s.civAddress, 224, 0x16, 0x40, b, 253})
 	return s.sendCmd(&s.state.setNREnabled)
 }
 
 func (s *civControlStruct) setTS(b byte) error {
-	s.initCmd(&s.state.setTS, "setTS", []byte{254, 254, civAddress, 224, 0x10, b, 253})
+	s.initCmd(&s.state.setTS, "setTS", []byte{254, 254, s.civAddress, 224, 0x10, b, 253})
 	return s.sendCmd(&s.state.setTS)
 }
 
@@ -1132,7 +1156,7 @@ func (s *civControlStruct) decTS() error {
 }
 
 func (s *civControlStruct) setVFO(nr byte) error {
-	s.initCmd(&s.state.setVFO, "setVFO", []byte{254, 254, civAddress, 224, 0x07, nr, 253})
+	s.initCmd(&s.state.setVFO, "setVFO", []byte{254, 254, s.civAddress, 224, 0x07, nr, 253})
 	if err := s.sendCmd(&s.state.setVFO); err != nil {
 		return err
 	}
@@ -1159,7 +1183,7 @@ func (s *civControlStruct) setSplit(mode splitMode) error {
 	case splitModeDUPPlus:
 		b = 0x12
 	}
-	s.initCmd(&s.state.setSplit, "setSplit", []byte{254, 254, civAddress, 224, 0x0f, b, 253})
+	s.initCmd(&s.state.setSplit, "setSplit", []byte{254, 254, s.civAddress, 224, 0x0f, b, 253})
 	return s.sendCmd(&s.state.setSplit)
 }
 
@@ -1179,109 +1203,109 @@ func (s *civControlStruct) toggleSplit() error {
 }
 
 // func (s *civControlStruct) getFreq() error {
-// 	s.initCmd(&s.state.getFreq, "getFreq", []byte{254, 254, civAddress, 224, 3, 253})
+// 	s.initCmd(&s.state.getFreq, "getFreq", []byte{254, 254, s.civAddress, 224, 3, 253})
 // 	return s.sendCmd(&s.state.getFreq)
 // }
 
 // func (s *civControlStruct) getMode() error {
-// 	s.initCmd(&s.state.getMode, "getMode", []byte{254, 254, civAddress, 224, 4, 253})
+// 	s.initCmd(&s.state.getMode, "getMode", []byte{254, 254, s.civAddress, 224, 4, 253})
 // 	return s.sendCmd(&s.state.getMode)
 // }
 
 // func (s *civControlStruct) getDataMode() error {
-// 	s.initCmd(&s.state.getDataMode, "getDataMode", []byte{254, 254, civAddress, 224, 0x1a, 0x06, 253})
+// 	s.initCmd(&s.state.getDataMode, "getDataMode", []byte{254, 254, s.civAddress, 224, 0x1a, 0x06, 253})
 // 	return s.sendCmd(&s.state.getDataMode)
 // }
 
 func (s *civControlStruct) getPwr() error {
-	s.initCmd(&s.state.getPwr, "getPwr", []byte{254, 254, civAddress, 224, 0x14, 0x0a, 253})
+	s.initCmd(&s.state.getPwr, "getPwr", []byte{254, 254, s.civAddress, 224, 0x14, 0x0a, 253})
 	return s.sendCmd(&s.state.getPwr)
 }
 
 func (s *civControlStruct) getTransmitStatus() error {
-	s.initCmd(&s.state.getTransmitStatus, "getTransmitStatus", []byte{254, 254, civAddress, 224, 0x1c, 0, 253})
+	s.initCmd(&s.state.getTransmitStatus, "getTransmitStatus", []byte{254, 254, s.civAddress, 224, 0x1c, 0, 253})
 	if err := s.sendCmd(&s.state.getTransmitStatus); err != nil {
 		return err
 	}
-	s.initCmd(&s.state.getTuneStatus, "getTuneStatus", []byte{254, 254, civAddress, 224, 0x1c, 1, 253})
+	s.initCmd(&s.state.getTuneStatus, "getTuneStatus", []byte{254, 254, s.civAddress, 224, 0x1c, 1, 253})
 	return s.sendCmd(&s.state.getTuneStatus)
 }
 
 func (s *civControlStruct) getPreamp() error {
-	s.initCmd(&s.state.getPreamp, "getPreamp", []byte{254, 254, civAddress, 224, 0x16, 0x02, 253})
+	s.initCmd(&s.state.getPreamp, "getPreamp", []byte{254, 254, s.civAddress, 224, 0x16, 0x02, 253})
 	return s.sendCmd(&s.state.getPreamp)
 }
 
 func (s *civControlStruct) getAGC() error {
-	s.initCmd(&s.state.getAGC, "getAGC", []byte{254, 254, civAddress, 224, 0x16, 0x12, 253})
+	s.initCmd(&s.state.getAGC, "getAGC", []byte{254, 254, s.civAddress, 224, 0x16, 0x12, 253})
 	return s.sendCmd(&s.state.getAGC)
 }
 
 func (s *civControlStruct) getVd() error {
-	s.initCmd(&s.state.getVd, "getVd", []byte{254, 254, civAddress, 224, 0x15, 0x15, 253})
+	s.initCmd(&s.state.getVd, "getVd", []byte{254, 254, s.civAddress, 224, 0x15, 0x15, 253})
 	return s.sendCmd(&s.state.getVd)
 }
 
 func (s *civControlStruct) getS() error {
-	s.initCmd(&s.state.getS, "getS", []byte{254, 254, civAddress, 224, 0x15, 0x02, 253})
+	s.initCmd(&s.state.getS, "getS", []byte{254, 254, s.civAddress, 224, 0x15, 0x02, 253})
 	return s.sendCmd(&s.state.getS)
 }
 
 func (s *civControlStruct) getOVF() error {
-	s.initCmd(&s.state.getOVF, "getOVF", []byte{254, 254, civAddress, 224, 0x1a, 0x09, 253})
+	s.initCmd(&s.state.getOVF, "getOVF", []byte{254, 254, s.civAddress, 224, 0x1a, 0x09, 253})
 	return s.sendCmd(&s.state.getOVF)
 }
 
 func (s *civControlStruct) getSWR() error {
-	s.initCmd(&s.state.getSWR, "getSWR", []byte{254, 254, civAddress, 224, 0x15, 0x12, 253})
+	s.initCmd(&s.state.getSWR, "getSWR", []byte{254, 254, s.civAddress, 224, 0x15, 0x12, 253})
 	return s.sendCmd(&s.state.getSWR)
 }
 
 func (s *civControlStruct) getTS() error {
-	s.initCmd(&s.state.getTS, "getTS", []byte{254, 254, civAddress, 224, 0x10, 253})
+	s.initCmd(&s.state.getTS, "getTS", []byte{254, 254, s.civAddress, 224, 0x10, 253})
 	return s.sendCmd(&s.state.getTS)
 }
 
 func (s *civControlStruct) getRFGain() error {
-	s.initCmd(&s.state.getRFGain, "getRFGain", []byte{254, 254, civAddress, 224, 0x14, 0x02, 253})
+	s.initCmd(&s.state.getRFGain, "getRFGain", []byte{254, 254, s.civAddress, 224, 0x14, 0x02, 253})
 	return s.sendCmd(&s.state.getRFGain)
 }
 
 func (s *civControlStruct) getSQL() error {
-	s.initCmd(&s.state.getSQL, "getSQL", []byte{254, 254, civAddress, 224, 0x14, 0x03, 253})
+	s.initCmd(&s.state.getSQL, "getSQL", []byte{254, 254, s.civAddress, 224, 0x14, 0x03, 253})
 	return s.sendCmd(&s.state.getSQL)
 }
 
 func (s *civControlStruct) getNR() error {
-	s.initCmd(&s.state.getNR, "getNR", []byte{254, 254, civAddress, 224, 0x14, 0x06, 253})
+	s.initCmd(&s.state.getNR, "getNR", []byte{254, 254, s.civAddress, 224, 0x14, 0x06, 253})
 	return s.sendCmd(&s.state.getNR)
 }
 
 func (s *civControlStruct) getNREnabled() error {
-	s.initCmd(&s.state.getNREnabled, "getNREnabled", []byte{254, 254, civAddress, 224, 0x16, 0x40, 253})
+	s.initCmd(&s.state.getNREnabled, "getNREnabled", []byte{254, 254, s.civAddress, 224, 0x16, 0x40, 253})
 	return s.sendCmd(&s.state.getNREnabled)
 }
 
 func (s *civControlStruct) getSplit() error {
-	s.initCmd(&s.state.getSplit, "getSplit", []byte{254, 254, civAddress, 224, 0x0f, 253})
+	s.initCmd(&s.state.getSplit, "getSplit", []byte{254, 254, s.civAddress, 224, 0x0f, 253})
 	return s.sendCmd(&s.state.getSplit)
 }
 
 func (s *civControlStruct) getBothVFOFreq() error {
-	s.initCmd(&s.state.getMainVFOFreq, "getMainVFOFreq", []byte{254, 254, civAddress, 224, 0x25, 0, 253})
+	s.initCmd(&s.state.getMainVFOFreq, "getMainVFOFreq", []byte{254, 254, s.civAddress, 224, 0x25, 0, 253})
 	if err := s.sendCmd(&s.state.getMainVFOFreq); err != nil {
 		return err
 	}
-	s.initCmd(&s.state.getSubVFOFreq, "getSubVFOFreq", []byte{254, 254, civAddress, 224, 0x25, 1, 253})
+	s.initCmd(&s.state.getSubVFOFreq, "getSubVFOFreq", []byte{254, 254, s.civAddress, 224, 0x25, 1, 253})
 	return s.sendCmd(&s.state.getSubVFOFreq)
 }
 
 func (s *civControlStruct) getBothVFOMode() error {
-	s.initCmd(&s.state.getMainVFOMode, "getMainVFOMode", []byte{254, 254, civAddress, 224, 0x26, 0, 253})
+	s.initCmd(&s.state.getMainVFOMode, "getMainVFOMode", []byte{254, 254, s.civAddress, 224, 0x26, 0, 253})
 	if err := s.sendCmd(&s.state.getMainVFOMode); err != nil {
 		return err
 	}
-	s.initCmd(&s.state.getSubVFOMode, "getSubVFOMode", []byte{254, 254, civAddress, 224, 0x26, 1, 253})
+	s.initCmd(&s.state.getSubVFOMode, "getSubVFOMode", []byte{254, 254, s.civAddress, 224, 0x26, 1, 253})
 	return s.sendCmd(&s.state.getSubVFOMode)
 }
 
@@ -1338,7 +1362,13 @@ func (s *civControlStruct) loop() {
 }
 
 func (s *civControlStruct) init(st *serialStream) error {
+	s.sess = st.sess
 	s.st = st
+	s.civAddress = s.sess.conf.civAddress
+	if !s.sess.conf.civAddressSet && s.sess.radioCaps != nil && s.sess.radioCaps.civAddress != 0 {
+		s.civAddress = s.sess.radioCaps.civAddress
+		log.Print(s.sess.logPrefix(), "using CI-V address 0x", fmt.Sprintf("%.2x", s.civAddress), " reported by the radio")
+	}
 
 	if err := s.getBothVFOFreq(); err != nil {
 		return err
@@ -15,9 +15,6 @@ type cmdRunner struct {
 	runEndFinished chan bool
 }
 
-var runCmdRunner cmdRunner
-var serialCmdRunner cmdRunner
-
 func (c *cmdRunner) kill(cmd *exec.Cmd) {
 	err := cmd.Process.Kill()
 	if err != nil {
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The config file uses a small subset of TOML. Keys are the same as the long command line argument
+// names. Keys outside of any table apply to all profiles, keys in a [profile.<name>] table only apply
+// when the profile is selected. Example:
+//
+//   log-interval = 100
+//
+//   [profile.ic705]
+//   address = "IC-705"
+//   civ-address = 0xa4
+//
+//   [profile.ic9700]
+//   address = "192.168.1.20"
+//   civ-address = 0xa2
+//   set-data-tx = true
+
+var configFileKeys = []string{
+	"address",
+	"username",
+	"civ-address",
+	"serial-tcp-port",
+	"enable-serial-device",
+	"rigctld-port",
+	"exec",
+	"exec-serial",
+	"log-interval",
+	"set-data-tx",
+	"control-port",
+	"serial-port",
+	"audio-port",
+	"local-address",
+	"local-control-port",
+	"local-serial-port",
+	"local-audio-port",
+	"password-prompt",
+	"audio-codec",
+	"audio-sample-rate",
+	"wait-if-busy",
+	"jitter-buffer-min",
+	"jitter-buffer-max",
+	"audio-plc",
+	"virtual-device-name",
+	"virtual-device-description",
+	"virtual-device-format",
+	"virtual-device-rate",
+	"monitor-device",
+	"record-device",
+	"wav-dir",
+	"wav-tx",
+	"wav-max-size",
+	"wav-max-length",
+	"voice-keyer",
+	"voice-keyer-repeat",
+	"vox",
+	"vox-threshold",
+	"vox-attack",
+	"vox-hang",
+	"vox-max-tx",
+	"tx-dsp",
+	"tx-dsp-modes",
+	"tx-gain",
+	"tx-highpass",
+	"tx-lowpass",
+	"tx-eq",
+	"tx-comp-threshold",
+	"tx-comp-ratio",
+	"tx-comp-makeup",
+	"tx-limit",
+	"rx-nr",
+	"rx-anf",
+	"rx-bandpass",
+	"rx-bandpass-filters",
+}
+
+type configFile struct {
+	kind     string
+	path     string
+	keys     []string
+	global   map[string]string
+	profiles map[string]map[string]string
+}
+
+func getDefaultConfigFilePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "kappanhang", "config.toml")
+}
+
+func (c *configFile) isValidKey(key string) bool {
+	for _, k := range c.keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *configFile) parseValue(v string) (string, error) {
+	if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' { // Literal string.
+		return v[1 : len(v)-1], nil
+	}
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' { // Basic string.
+		return strconv.Unquote(v)
+	}
+	if v == "" {
+		return "", errors.New("missing value")
+	}
+	if strings.ContainsAny(v, " \t\"'") {
+		return "", fmt.Errorf("invalid value %s", v)
+	}
+	return v, nil
+}
+
+// Strips comments which are not inside a quoted string.
+func (c *configFile) stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0 && r == quote && (quote == '\'' || i == 0 || line[i-1] != '\\'):
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func (c *configFile) parse(f *os.File) error {
+	current := c.global
+	scanner := bufio.NewScanner(f)
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		line := strings.TrimSpace(c.stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return fmt.Errorf("%s:%d: invalid table header", c.path, lineNr)
+			}
+			table := strings.TrimSpace(line[1 : len(line)-1])
+			if !strings.HasPrefix(table, "profile.") || len(table) == len("profile.") {
+				return fmt.Errorf("%s:%d: unknown table %s", c.path, lineNr, table)
+			}
+			name := strings.Trim(table[len("profile."):], "\"")
+			if _, ok := c.profiles[name]; ok {
+				return fmt.Errorf("%s:%d: duplicate profile %s", c.path, lineNr, name)
+			}
+			current = make(map[string]string)
+			c.profiles[name] = current
+			continue
+		}
+
+		eqIndex := strings.Index(line, "=")
+		if eqIndex < 0 {
+			return fmt.Errorf("%s:%d: expected key = value", c.path, lineNr)
+		}
+		key := strings.TrimSpace(line[:eqIndex])
+		if !c.isValidKey(key) {
+			if key == "password" { // The config file is usually readable by everyone.
+				return fmt.Errorf("%s:%d: password can't be set here, use the credentials file or the %s "+
+					"environment variable", c.path, lineNr, argEnvVars["password"])
+			}
+			return fmt.Errorf("%s:%d: unknown key %s", c.path, lineNr, key)
+		}
+		v, err := c.parseValue(strings.TrimSpace(line[eqIndex+1:]))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", c.path, lineNr, key, err)
+		}
+		current[key] = v
+	}
+	return scanner.Err()
+}
+
+// Returns the value of the given key from the selected profile, or from the global section if the profile
+// does not contain it. The returned source describes where the value came from.
+func (c *configFile) lookup(profile, key string) (value, source string, found bool) {
+	if p, ok := c.profiles[profile]; ok {
+		if value, found = p[key]; found {
+			return value, fmt.Sprint(c.kind, " ", c.path, ", profile ", profile), true
+		}
+	}
+	if value, found = c.global[key]; found {
+		return value, fmt.Sprint(c.kind, " ", c.path), true
+	}
+	return "", "", false
+}
+
+func (c *configFile) hasProfile(profile string) bool {
+	_, ok := c.profiles[profile]
+	return ok
+}
+
+func loadConfigFile(path string) (*configFile, error) {
+	return parseConfigFile("config file", path, configFileKeys)
+}
+
+func parseConfigFile(kind, path string, keys []string) (*configFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &configFile{
+		kind:     kind,
+		path:     path,
+		keys:     keys,
+		global:   make(map[string]string),
+		profiles: make(map[string]map[string]string),
+	}
+	if err := c.parse(f); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
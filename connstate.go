@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type connErrorKind int
+
+const (
+	connErrorOther            connErrorKind = iota
+	connErrorNetwork                        // Socket errors.
+	connErrorClosed                         // The connection was closed by us.
+	connErrorTimeout                        // The radio did not answer in time.
+	connErrorAuthFailed                     // The radio rejected our auth, this can happen after a previous session.
+	connErrorInvalidLogin                   // Invalid username or password.
+	connErrorDisconnected                   // The radio disconnected us.
+	connErrorRadioBusy                      // Another client is using the radio.
+	connErrorUnsupportedAudio               // The radio does not support the requested audio codec.
+)
+
+func (k connErrorKind) String() string {
+	switch k {
+	case connErrorNetwork:
+		return "network"
+	case connErrorClosed:
+		return "closed"
+	case connErrorTimeout:
+		return "timeout"
+	case connErrorAuthFailed:
+		return "auth failed"
+	case connErrorInvalidLogin:
+		return "invalid login"
+	case connErrorDisconnected:
+		return "disconnected"
+	case connErrorRadioBusy:
+		return "radio busy"
+	case connErrorUnsupportedAudio:
+		return "unsupported audio"
+	}
+	return "other"
+}
+
+// Returns true if there's no point in retrying the connection.
+func (k connErrorKind) isFatal() bool {
+	return k == connErrorInvalidLogin || k == connErrorUnsupportedAudio
+}
+
+// Returns true if we need to wait before reconnecting, so the radio can drop our previous session.
+func (k connErrorKind) requireWait() bool {
+	return k != connErrorDisconnected
+}
+
+// Returns true if the error does not need to be logged.
+func (k connErrorKind) isSilent() bool {
+	return k == connErrorClosed
+}
+
+type connError struct {
+	kind connErrorKind
+	err  error
+}
+
+func (e *connError) Error() string {
+	return e.err.Error()
+}
+
+func (e *connError) Unwrap() error {
+	return e.err
+}
+
+func newConnError(kind connErrorKind, a ...interface{}) error {
+	return &connError{kind: kind, err: errors.New(fmt.Sprint(a...))}
+}
+
+// Wraps a socket error. Go 1.14 does not have net.ErrClosed yet, so we have to check the error message to
+// find out if the connection was closed by us.
+func wrapNetError(err error) error {
+	kind := connErrorNetwork
+	if strings.Contains(err.Error(), "use of closed network connection") {
+		kind = connErrorClosed
+	}
+	return &connError{kind: kind, err: err}
+}
+
+func getConnErrorKind(err error) connErrorKind {
+	var ce *connError
+	if errors.As(err, &ce) {
+		return ce.kind
+	}
+	return connErrorOther
+}
+
+type connState int
+
+const (
+	connStateIdle connState = iota
+	connStateConnecting
+	connStateAuthenticating
+	connStateRequestingStreams
+	connStateStreaming
+	connStateReauthing
+	connStateBackoff
+	connStateFailed
+)
+
+func (s connState) String() string {
+	switch s {
+	case connStateConnecting:
+		return "connecting"
+	case connStateAuthenticating:
+		return "authenticating"
+	case connStateRequestingStreams:
+		return "requesting streams"
+	case connStateStreaming:
+		return "streaming"
+	case connStateReauthing:
+		return "reauthing"
+	case connStateBackoff:
+		return "backoff"
+	case connStateFailed:
+		return "failed"
+	}
+	return "idle"
+}
+
+type connStateEvent struct {
+	from connState
+	to   connState
+	err  error // The error which caused the transition, can be nil.
+}
+
+// Subscribers are called synchronously from the goroutine which changed the state, so they should not
+// block.
+type connStateSubscriber func(e connStateEvent)
+
+type connStateMachine struct {
+	mutex       sync.Mutex
+	state       connState
+	subscribers []connStateSubscriber
+}
+
+func (m *connStateMachine) subscribe(cb connStateSubscriber) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.subscribers = append(m.subscribers, cb)
+}
+
+func (m *connStateMachine) get() connState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.state
+}
+
+func (m *connStateMachine) set(state connState, err error) {
+	m.mutex.Lock()
+	if m.state == state {
+		m.mutex.Unlock()
+		return
+	}
+	e := connStateEvent{from: m.state, to: state, err: err}
+	m.state = state
+	subscribers := m.subscribers
+	m.mutex.Unlock()
+
+	for _, cb := range subscribers {
+		cb(e)
+	}
+}
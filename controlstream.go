@@ -4,18 +4,24 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/binary"
-	"errors"
+	"fmt"
+	"net"
 	"time"
 )
 
-const controlStreamPort = 50001
-const serialStreamPort = 50002
-const audioStreamPort = 50003
+const defaultControlStreamPort = 50001
+const defaultSerialStreamPort = 50002
+const defaultAudioStreamPort = 50003
 
 const reauthInterval = time.Minute
 const reauthTimeout = 3 * time.Second
+const deauthTimeout = time.Second
+
+// The status in the 0x50 packet if the radio refused our request.
+const radioStatusRefused = 0xffffffff
 
 type controlStream struct {
+	sess   *session
 	common streamCommon
 	serial serialStream
 	audio  audioStream
@@ -28,11 +34,11 @@ type controlStream struct {
 	gotAuthID        bool
 	authOk           bool
 
-	a8replyID    [16]byte
-	gotA8ReplyID bool
-
 	serialAndAudioStreamOpened bool
-	deinitializing             bool
+	// True if the radio confirmed the deauth on deinit, so we can relogin without waiting.
+	deauthConfirmed bool
+	// Set if the radio reported that another client is using it, contains the client's address.
+	busyClient string
 
 	requestSerialAndAudioTimeout *time.Timer
 	reauthTimeoutTimer           *time.Timer
@@ -44,8 +50,10 @@ func (s *controlStream) sendPktLogin() error {
 	if _, err := rand.Read(authStartID[:]); err != nil {
 		return err
 	}
-	usernameEncoded := passcode(username)
-	passwordEncoded := passcode(password)
+	usernameEncoded := passcode(s.sess.conf.username)
+	passwordEncoded := passcode(s.sess.conf.password)
+	var clientName [16]byte
+	copy(clientName[:len(clientName)-1], s.sess.clientName)
 	p := []byte{0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 		byte(s.common.localSID >> 24), byte(s.common.localSID >> 16), byte(s.common.localSID >> 8), byte(s.common.localSID),
 		byte(s.common.remoteSID >> 24), byte(s.common.remoteSID >> 16), byte(s.common.remoteSID >> 8), byte(s.common.remoteSID),
@@ -63,8 +71,10 @@ func (s *controlStream) sendPktLogin() error {
 		passwordEncoded[4], passwordEncoded[5], passwordEncoded[6], passwordEncoded[7],
 		passwordEncoded[8], passwordEncoded[9], passwordEncoded[10], passwordEncoded[11],
 		passwordEncoded[12], passwordEncoded[13], passwordEncoded[14], passwordEncoded[15],
-		0x69, 0x63, 0x6f, 0x6d, 0x2d, 0x70, 0x63, 0x00, // icom-pc in plain text
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		clientName[0], clientName[1], clientName[2], clientName[3], // Computer name in plain text
+		clientName[4], clientName[5], clientName[6], clientName[7],
+		clientName[8], clientName[9], clientName[10], clientName[11],
+		clientName[12], clientName[13], clientName[14], clientName[15],
 		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
 	if err := s.common.pkt0.sendTrackedPacket(&s.common, p); err != nil {
@@ -113,27 +123,34 @@ func (s *controlStream) sendRequestSerialAndAudio() error {
 	log.Debug("requesting serial and audio stream")
 
 	txSeqBufLengthMs := uint16(txSeqBufLength.Milliseconds())
-
-	usernameEncoded := passcode(username)
+	serialStreamPort := s.sess.conf.serialStreamPort
+	audioStreamPort := s.sess.conf.audioStreamPort
+	rxCodec := s.sess.conf.audioFormat.codec.code
+	txCodec := s.sess.conf.audioFormat.codec.code
+	sampleRate := s.sess.conf.audioFormat.sampleRate
+	a8replyID := s.sess.radioCaps.id
+	name := s.sess.radioCaps.getNameForRequest()
+
+	usernameEncoded := passcode(s.sess.conf.username)
 	p := []byte{0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 		byte(s.common.localSID >> 24), byte(s.common.localSID >> 16), byte(s.common.localSID >> 8), byte(s.common.localSID),
 		byte(s.common.remoteSID >> 24), byte(s.common.remoteSID >> 16), byte(s.common.remoteSID >> 8), byte(s.common.remoteSID),
 		0x00, 0x00, 0x00, 0x80, 0x01, 0x03, 0x00, byte(s.authInnerSendSeq),
 		byte(s.authInnerSendSeq >> 8), 0x00, s.authID[0], s.authID[1], s.authID[2], s.authID[3], s.authID[4], s.authID[5],
-		s.a8replyID[0], s.a8replyID[1], s.a8replyID[2], s.a8replyID[3], s.a8replyID[4], s.a8replyID[5], s.a8replyID[6], s.a8replyID[7],
-		s.a8replyID[8], s.a8replyID[9], s.a8replyID[10], s.a8replyID[11], s.a8replyID[12], s.a8replyID[13], s.a8replyID[14], s.a8replyID[15],
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x49, 0x43, 0x2d, 0x37, 0x30, 0x35, 0x00, 0x00, // IC-705 in plain text
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		a8replyID[0], a8replyID[1], a8replyID[2], a8replyID[3], a8replyID[4], a8replyID[5], a8replyID[6], a8replyID[7],
+		a8replyID[8], a8replyID[9], a8replyID[10], a8replyID[11], a8replyID[12], a8replyID[13], a8replyID[14], a8replyID[15],
 		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		name[0], name[1], name[2], name[3], name[4], name[5], name[6], name[7], // Model name in plain text, like IC-705
+		name[8], name[9], name[10], name[11], name[12], name[13], name[14], name[15],
+		name[16], name[17], name[18], name[19], name[20], name[21], name[22], name[23],
+		name[24], name[25], name[26], name[27], name[28], name[29], name[30], name[31],
 		usernameEncoded[0], usernameEncoded[1], usernameEncoded[2], usernameEncoded[3],
 		usernameEncoded[4], usernameEncoded[5], usernameEncoded[6], usernameEncoded[7],
 		usernameEncoded[8], usernameEncoded[9], usernameEncoded[10], usernameEncoded[11],
 		usernameEncoded[12], usernameEncoded[13], usernameEncoded[14], usernameEncoded[15],
-		0x01, 0x01, 0x04, 0x04, 0x00, 0x00, byte(audioSampleRate >> 8), byte(audioSampleRate & 0xff),
-		0x00, 0x00, byte(audioSampleRate >> 8), byte(audioSampleRate & 0xff),
+		0x01, 0x01, rxCodec, txCodec, 0x00, 0x00, byte(sampleRate >> 8), byte(sampleRate & 0xff),
+		0x00, 0x00, byte(sampleRate >> 8), byte(sampleRate & 0xff),
 		0x00, 0x00, byte(serialStreamPort >> 8), byte(serialStreamPort & 0xff),
 		0x00, 0x00, byte(audioStreamPort >> 8), byte(audioStreamPort & 0xff), 0x00, 0x00,
 		byte(txSeqBufLengthMs >> 8), byte(txSeqBufLengthMs & 0xff), 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
@@ -147,9 +164,9 @@ func (s *controlStream) sendRequestSerialAndAudio() error {
 }
 
 func (s *controlStream) sendRequestSerialAndAudioIfPossible() {
-	if !s.serialAndAudioStreamOpened && s.authOk && s.gotA8ReplyID {
+	if !s.serialAndAudioStreamOpened && s.authOk && s.sess.radioCaps != nil {
 		if err := s.sendRequestSerialAndAudio(); err != nil {
-			reportError(err)
+			s.sess.reportError(err)
 		}
 	}
 }
@@ -180,8 +197,14 @@ func (s *controlStream) handleRead(r []byte) error {
 			// 0x00, 0x00, 0x3f, 0x3f, 0xa4, 0x01, 0xff, 0x01,
 			// 0xff, 0x01, 0x01, 0x01, 0x00, 0x00, 0x4b, 0x00,
 			// 0x01, 0x50, 0x00, 0xb8, 0x0b, 0x00, 0x00, 0x00
-			copy(s.a8replyID[:], r[66:82])
-			s.gotA8ReplyID = true
+			if s.sess.radioCaps == nil {
+				caps := parseRadioCapabilities(r)
+				log.Print(s.sess.logPrefix(), "radio: ", caps)
+				s.sess.radioCaps = &caps
+				if err := caps.checkAudioCodec(s.sess.conf.audioFormat.codec); err != nil {
+					return newConnError(connErrorUnsupportedAudio, err)
+				}
+			}
 		}
 	case 64:
 		if bytes.Equal(r[:6], []byte{0x40, 0x00, 0x00, 0x00, 0x00, 0x00}) {
@@ -200,6 +223,9 @@ func (s *controlStream) handleRead(r []byte) error {
 
 			if r[21] == 0x05 { // Answer for our second auth?
 				s.authOk = true
+				if s.serialAndAudioStreamOpened {
+					s.sess.connState.set(connStateStreaming, nil)
+				}
 				s.sendRequestSerialAndAudioIfPossible()
 			}
 		}
@@ -216,18 +242,29 @@ func (s *controlStream) handleRead(r []byte) error {
 			//							  0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 			//							  0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00
 
-			if bytes.Equal(r[48:51], []byte{0xff, 0xff, 0xff}) {
-				if !s.serialAndAudioStreamOpened {
-					return errors.New("auth failed, try rebooting the radio")
-				}
-				return errors.New("auth failed")
-			}
-			if bytes.Equal(r[48:51], []byte{0x00, 0x00, 0x00}) && r[64] == 0x01 {
-				return errors.New("got radio disconnected")
+			//
+			// Bytes 48-51 are the status, byte 64 is the disconnected flag. The radio refuses our stream
+			// request with status 0xffffffff if it's in use. The other client is in the stream request reply
+			// (see below) which comes before this packet. If the radio did not tell the client, then it can be
+			// our previous session which the radio did not drop yet.
+
+			status := binary.BigEndian.Uint32(r[48:52])
+			inUse := status == radioStatusRefused && !s.serialAndAudioStreamOpened
+			disconnected := r[64] == 0x01
+			switch {
+			case inUse && s.busyClient != "":
+				return newConnError(connErrorRadioBusy, "radio in use by ", s.busyClient)
+			case inUse:
+				return newConnError(connErrorAuthFailed, "radio in use, probably by our previous session, "+
+					"try rebooting the radio")
+			case status == radioStatusRefused:
+				return newConnError(connErrorAuthFailed, "auth failed")
+			case status == 0 && disconnected:
+				return newConnError(connErrorDisconnected, "got radio disconnected")
 			}
 		}
 	case 144:
-		if !s.serialAndAudioStreamOpened && bytes.Equal(r[:6], []byte{0x90, 0x00, 0x00, 0x00, 0x00, 0x00}) && r[96] == 1 {
+		if !s.serialAndAudioStreamOpened && bytes.Equal(r[:6], []byte{0x90, 0x00, 0x00, 0x00, 0x00, 0x00}) {
 			// Example answer:
 			// 0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 0x19, 0x00,
 			// 0xc6, 0x5f, 0x6f, 0x0c, 0x5f, 0x8b, 0x1e, 0x89,
@@ -247,11 +284,32 @@ func (s *controlStream) handleRead(r []byte) error {
 			// 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 			// 0x00, 0x00, 0x00, 0x00, 0xc0, 0xa8, 0x03, 0x03,
 			// 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00
+			//
+			// Bytes 96-99 are the in-use flag (little endian), it's set if a client has the streams open,
+			// followed by the computer name the client sent in its login packet. The IP address of the client
+			// is at byte 132. If the radio accepted our request, then we are the client. The address can't be
+			// used to check this, as the radio sees a translated address if it's reached through NAT, but the
+			// name is echoed back unchanged, and it's unique for each session.
+
+			if binary.LittleEndian.Uint32(r[96:100]) == 0 {
+				log.Debug("got stream request reply, but the radio is not in use yet")
+				return nil
+			}
+			if clientName := parseNullTerminatedString(r[100:116]); clientName != s.sess.clientName {
+				s.busyClient = net.IP(r[132:136]).String()
+				if clientName != "" {
+					s.busyClient += " (" + clientName + ")"
+				}
+				return newConnError(connErrorRadioBusy, "radio in use by ", s.busyClient)
+			}
 
 			s.requestSerialAndAudioTimeout.Stop()
 
 			devName := parseNullTerminatedString(r[64:])
-			log.Print("got serial and audio request success, device name: ", devName)
+			log.Print(s.sess.logPrefix(), "got serial and audio request success, device name: ", devName)
+			if s.sess.conf.deviceName != "" {
+				devName = s.sess.conf.deviceName
+			}
 
 			// Stuff can change in the meantime because of a previous login...
 			s.common.remoteSID = binary.BigEndian.Uint32(r[8:12])
@@ -259,32 +317,25 @@ func (s *controlStream) handleRead(r []byte) error {
 			copy(s.authID[:], r[26:32])
 			s.gotAuthID = true
 
-			statusLog.startPeriodicPrint()
+			s.sess.statusLog.startPeriodicPrint()
 
 			if err := s.serial.init(devName); err != nil {
-				return errors.New("serial/" + err.Error())
+				return fmt.Errorf("serial/%w", err)
 			}
 
 			if err := s.audio.init(devName); err != nil {
-				return errors.New("audio/" + err.Error())
+				return fmt.Errorf("audio/%w", err)
 			}
 
 			s.serialAndAudioStreamOpened = true
-
-			runCmdRunner.startIfNeeded(runCmd)
-			if enableSerialDevice {
-				serialCmdRunner.startIfNeeded(runCmdOnSerialPortCreated)
-			}
-			if err := rigctld.initIfNeeded(); err != nil {
-				return err
-			}
+			s.sess.connState.set(connStateStreaming, nil)
 		}
 	}
 	return nil
 }
 
 func (s *controlStream) loop() {
-	netstat.reset()
+	s.sess.netstat.reset()
 
 	s.reauthTimeoutTimer = time.NewTimer(0)
 	<-s.reauthTimeoutTimer.C
@@ -294,16 +345,18 @@ func (s *controlStream) loop() {
 	for {
 		select {
 		case r := <-s.common.readChan:
-			if !s.deinitializing {
-				if err := s.handleRead(r); err != nil {
-					reportError(err)
-				}
+			if err := s.handleRead(r); err != nil {
+				s.sess.reportError(err)
 			}
+			pktBufPool.put(r)
 		case <-reauthTicker.C:
 			log.Debug("sending auth")
+			if s.serialAndAudioStreamOpened {
+				s.sess.connState.set(connStateReauthing, nil)
+			}
 			s.reauthTimeoutTimer.Reset(reauthTimeout)
 			if err := s.sendPktAuth(0x05); err != nil {
-				reportError(err)
+				s.sess.reportError(err)
 			}
 		case <-s.reauthTimeoutTimer.C:
 			log.Error("auth timeout, audio/serial stream may stop")
@@ -314,17 +367,47 @@ func (s *controlStream) loop() {
 	}
 }
 
-func (s *controlStream) init() error {
-	log.Debug("init")
+func (s *controlStream) init(sess *session) error {
+	s.sess = sess
+	s.serial.sess = sess
+	s.audio.sess = sess
+	s.sess.radioCaps = nil
+	log.Debug(s.sess.logPrefix(), "init")
+	s.sess.connState.set(connStateConnecting, nil)
+
+	if err := s.common.init(sess, "control", sess.conf.controlStreamPort, sess.conf.localControlStreamPort); err != nil {
+		return err
+	}
+
+	if err := s.login(); err != nil {
+		return err
+	}
+
+	s.common.pkt0.startPeriodicSend(&s.common)
 
-	if err := s.common.init("control", controlStreamPort); err != nil {
+	if err := s.sendPktAuth(0x05); err != nil {
 		return err
 	}
+	log.Debug("second auth sent...")
+	s.sess.connState.set(connStateRequestingStreams, nil)
 
+	s.requestSerialAndAudioTimeout = time.AfterFunc(5*time.Second, func() {
+		s.sess.reportError(newConnError(connErrorTimeout, "login/serial/audio request timeout"))
+	})
+
+	s.deinitNeededChan = make(chan bool)
+	s.deinitFinishedChan = make(chan bool)
+	go s.loop()
+	return nil
+}
+
+// Logs in to the radio and sends the first auth packet. The control stream must be already initialized.
+func (s *controlStream) login() error {
 	if err := s.common.start(); err != nil {
 		return err
 	}
 
+	s.sess.connState.set(connStateAuthenticating, nil)
 	s.common.pkt0.init(&s.common)
 	if err := s.sendPktLogin(); err != nil {
 		return err
@@ -348,7 +431,7 @@ func (s *controlStream) init() error {
 		return err
 	}
 	if bytes.Equal(r[48:52], []byte{0xff, 0xff, 0xff, 0xfe}) {
-		return errors.New("invalid username/password")
+		return newConnError(connErrorInvalidLogin, "invalid username/password")
 	}
 
 	s.common.pkt7.startPeriodicSend(&s.common, 2, false)
@@ -359,46 +442,67 @@ func (s *controlStream) init() error {
 		return err
 	}
 	log.Debug("login ok, first auth sent...")
-
-	s.common.pkt0.startPeriodicSend(&s.common)
-
-	if err := s.sendPktAuth(0x05); err != nil {
-		return err
-	}
-	log.Debug("second auth sent...")
-
-	s.requestSerialAndAudioTimeout = time.AfterFunc(5*time.Second, func() {
-		reportError(errors.New("login/serial/audio request timeout"))
-	})
-
-	s.deinitNeededChan = make(chan bool)
-	s.deinitFinishedChan = make(chan bool)
-	go s.loop()
 	return nil
 }
 
 func (s *controlStream) deinit() {
-	s.deinitializing = true
-	s.serialAndAudioStreamOpened = false
-	statusLog.stopPeriodicPrint()
-
+	// Stopping the loop first, so received packets are not handled anymore.
 	if s.deinitNeededChan != nil {
 		s.deinitNeededChan <- true
 		<-s.deinitFinishedChan
 	}
+	s.serialAndAudioStreamOpened = false
+	s.sess.statusLog.stopPeriodicPrint()
 	if s.requestSerialAndAudioTimeout != nil {
 		s.requestSerialAndAudioTimeout.Stop()
 		s.requestSerialAndAudioTimeout = nil
 	}
 
+	// Closing the serial and audio streams before the deauth, the same way as the original software does.
+	s.serial.deinit()
+	s.audio.deinit()
+
 	if s.gotAuthID && s.common.gotRemoteSID && s.common.conn != nil {
 		log.Debug("sending deauth")
-		_ = s.sendPktAuth(0x01)
-		// Waiting a little bit to make sure the radio can send retransmit requests.
-		time.Sleep(500 * time.Millisecond)
+		if err := s.sendPktAuth(0x01); err == nil {
+			s.deauthConfirmed = s.waitForDeauthAnswer()
+		}
+		if s.deauthConfirmed {
+			log.Debug("deauth ok")
+		} else {
+			log.Debug("deauth timeout")
+		}
 	}
 
 	s.common.deinit()
-	s.serial.deinit()
-	s.audio.deinit()
+}
+
+// Waits for the radio to confirm the deauth. Retransmit requests are handled by the stream's reader
+// while waiting.
+func (s *controlStream) waitForDeauthAnswer() bool {
+	timer := time.NewTimer(deauthTimeout)
+	defer timer.Stop()
+
+	for {
+		var r []byte
+		select {
+		case r = <-s.common.readChan:
+		case <-timer.C:
+			return false
+		}
+
+		// Example answer from radio: 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0e, 0x00,
+		//                            0xe6, 0xb2, 0x7b, 0x7b, 0xbb, 0x41, 0x3f, 0x2b,
+		//                            0x00, 0x00, 0x00, 0x30, 0x02, 0x01, 0x00, 0x02,
+		//                            0x00, 0x00, 0x5d, 0x37, 0x12, 0x82, 0x3b, 0xde,
+		//                            0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		//                            0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		//                            0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		//                            0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00
+		isAnswer := len(r) == 64 && bytes.Equal(r[:6], []byte{0x40, 0x00, 0x00, 0x00, 0x00, 0x00}) && r[21] == 0x01
+		pktBufPool.put(r)
+		if isAnswer {
+			return true
+		}
+	}
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The credentials file has the same format as the config file, but it can only contain the username and
+// the password. It can also have [profile.<name>] tables. Example:
+//
+//   username = "beer"
+//
+//   [profile.ic705]
+//   password = "beerbeer"
+
+var credentialsFileKeys = []string{
+	"username",
+	"password",
+}
+
+// Environment variables which can be used to set arguments.
+var argEnvVars = map[string]string{
+	"username": "KAPPANHANG_USERNAME",
+	"password": "KAPPANHANG_PASSWORD",
+}
+
+func getDefaultCredentialsFilePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "kappanhang", "credentials.toml")
+}
+
+func loadCredentialsFile(path string) (*configFile, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s has mode %#o, refusing to use it, it must be 0600 (fix with chmod 600 %s)",
+			path, fi.Mode().Perm(), path)
+	}
+	return parseConfigFile("credentials file", path, credentialsFileKeys)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const discoverTimeout = time.Second
+const discoverCapsTimeout = 2 * time.Second
+
+// Networks larger than this won't be scanned host by host, only the broadcast address is used.
+const discoverMaxHostsPerNetwork = 1024
+
+type discoveredServer struct {
+	addr *net.UDPAddr
+	caps *radioCapabilities
+	err  error
+}
+
+// Returns the broadcast address and all host addresses of the local IPv4 networks. Some servers do not
+// answer broadcasts, that's why the hosts are probed one by one.
+func getDiscoverTargets() (res []net.IP) {
+	res = append(res, net.IPv4bcast)
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Error("can't get local addresses: ", err)
+		return
+	}
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		m := ipNet.Mask
+		if len(m) == net.IPv6len {
+			m = m[12:]
+		}
+		ip := binary.BigEndian.Uint32(ipNet.IP.To4())
+		mask := binary.BigEndian.Uint32(m)
+		network := ip & mask
+		broadcast := network | ^mask
+		if broadcast-network < 2 { // Point-to-point link.
+			continue
+		}
+
+		b := make(net.IP, 4)
+		binary.BigEndian.PutUint32(b, broadcast)
+		res = append(res, b)
+
+		if broadcast-network-1 > discoverMaxHostsPerNetwork {
+			log.Print("network ", ipNet, " is too large, only using its broadcast address")
+			continue
+		}
+		for host := network + 1; host < broadcast; host++ {
+			if host == ip {
+				continue
+			}
+			h := make(net.IP, 4)
+			binary.BigEndian.PutUint32(h, host)
+			res = append(res, h)
+		}
+	}
+	return
+}
+
+// Sends "are you there" (pkt3) packets to the given port of all targets and returns the addresses which
+// answered with a pkt4 packet.
+func probeServers(port uint16) ([]*net.UDPAddr, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	localSID := rand.Uint32()
+	// Same as the packet sent by streamCommon.sendPkt3(), but the remote SID is unknown yet.
+	p := []byte{0x10, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00,
+		byte(localSID >> 24), byte(localSID >> 16), byte(localSID >> 8), byte(localSID),
+		0x00, 0x00, 0x00, 0x00}
+
+	targets := getDiscoverTargets()
+	log.Print("probing ", len(targets), " addresses on port ", port, "...")
+	for _, t := range targets {
+		// Errors are expected here, for example for unreachable hosts.
+		_, _ = conn.WriteToUDP(p, &net.UDPAddr{IP: t, Port: int(port)})
+	}
+
+	var res []*net.UDPAddr
+	found := make(map[string]bool)
+	b := make([]byte, 1500)
+	deadline := time.Now().Add(discoverTimeout)
+	for {
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		n, addr, err := conn.ReadFromUDP(b)
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				break
+			}
+			return nil, err
+		}
+		// Example answer from radio: 0x10, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x8c, 0x7d, 0x45, 0x7a, 0x1d, 0xf6, 0xe9, 0x0b
+		if n != 16 || !bytes.Equal(b[:8], []byte{0x10, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00}) {
+			continue
+		}
+		if found[addr.String()] {
+			continue
+		}
+		found[addr.String()] = true
+		res = append(res, addr)
+	}
+	return res, nil
+}
+
+// Logs in to the server to get the capabilities of the radio, then logs out.
+func getRadioCapabilities(conf sessionConfig, addr *net.UDPAddr) (*radioCapabilities, error) {
+	conf.connectAddress = addr.IP.String()
+	conf.controlStreamPort = uint16(addr.Port)
+
+	sess := &session{}
+	sess.init(conf)
+	ctrl := &controlStream{sess: sess}
+	ctrl.serial.sess = sess
+	ctrl.audio.sess = sess
+	defer ctrl.deinit()
+
+	if err := ctrl.common.init(sess, "discover", conf.controlStreamPort, conf.localControlStreamPort); err != nil {
+		return nil, err
+	}
+	if err := ctrl.login(); err != nil {
+		return nil, err
+	}
+
+	// See controlStream.handleRead() for an example of this packet.
+	r := ctrl.common.tryReceivePacket(discoverCapsTimeout, 168, 0, []byte{0xa8, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if r == nil {
+		return nil, errors.New("no capabilities received")
+	}
+	caps := parseRadioCapabilities(r)
+	return &caps, nil
+}
+
+// Lists the RS-BA1 servers on the local networks. The given config is used to log in to the servers to
+// get the radio names. Returns the exit code.
+func runDiscover(conf sessionConfig) int {
+	rand.Seed(time.Now().UnixNano())
+
+	addrs, err := probeServers(conf.controlStreamPort)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	if len(addrs) == 0 {
+		log.Print("no servers found")
+		return 1
+	}
+
+	var servers []discoveredServer
+	for _, addr := range addrs {
+		log.Print("found server at ", addr, ", logging in to get the radio name...")
+		caps, err := getRadioCapabilities(conf, addr)
+		servers = append(servers, discoveredServer{addr: addr, caps: caps, err: err})
+	}
+
+	fmt.Println()
+	for _, s := range servers {
+		if s.err != nil {
+			fmt.Printf("%-21s unknown radio (%s)\n", s.addr, s.err)
+		} else {
+			fmt.Printf("%-21s %s\n", s.addr, s.caps)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Audio processing building blocks. They work on float samples, 1 is full scale, at audioSampleRate
+// unless the sample rate is given.
+
+func dbToLinear(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// Returns the coefficient of a one pole smoothing filter with the given time constant.
+func getSmoothingCoef(t time.Duration) float64 {
+	if t <= 0 {
+		return 0
+	}
+	return math.Exp(-1 / (t.Seconds() * audioSampleRate))
+}
+
+// Converts a float sample to a clipped 16 bit sample.
+func floatToSample(x float64) int16 {
+	y := math.Round(x * 32768)
+	if y > math.MaxInt16 {
+		y = math.MaxInt16
+	} else if y < math.MinInt16 {
+		y = math.MinInt16
+	}
+	return int16(y)
+}
+
+// Calls process() for each sample of 48kHz, s16le, mono audio, and writes the results back to d.
+func processAudioSamples(d []byte, process func(float64) float64) {
+	for i := 0; i+audioSampleBytes <= len(d); i += audioSampleBytes {
+		x := float64(int16(binary.LittleEndian.Uint16(d[i:]))) / 32768
+		binary.LittleEndian.PutUint16(d[i:], uint16(floatToSample(process(x))))
+	}
+}
+
+// A second order IIR filter, the coefficients are calculated using the formulas from the Audio EQ Cookbook
+// by Robert Bristow-Johnson.
+type biquadFilter struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+const butterworthQ = 0.7071
+
+func newBiquadFilter(b0, b1, b2, a0, a1, a2 float64) biquadFilter {
+	return biquadFilter{b0: b0 / a0, b1: b1 / a0, b2: b2 / a0, a1: a1 / a0, a2: a2 / a0}
+}
+
+func getBiquadParams(freq, q float64, sampleRate int) (cosw0, alpha float64) {
+	w0 := 2 * math.Pi * freq / float64(sampleRate)
+	return math.Cos(w0), math.Sin(w0) / (2 * q)
+}
+
+// Returns a lowpass filter for audio with the given sample rate.
+func newLowpassFilterForRate(freq, q float64, sampleRate int) biquadFilter {
+	c, alpha := getBiquadParams(freq, q, sampleRate)
+	return newBiquadFilter((1-c)/2, 1-c, (1-c)/2, 1+alpha, -2*c, 1-alpha)
+}
+
+func newLowpassFilter(freq, q float64) biquadFilter {
+	return newLowpassFilterForRate(freq, q, audioSampleRate)
+}
+
+func newHighpassFilter(freq, q float64) biquadFilter {
+	c, alpha := getBiquadParams(freq, q, audioSampleRate)
+	return newBiquadFilter((1+c)/2, -(1 + c), (1+c)/2, 1+alpha, -2*c, 1-alpha)
+}
+
+func newPeakingFilter(freq, q, gainDB float64) biquadFilter {
+	c, alpha := getBiquadParams(freq, q, audioSampleRate)
+	a := math.Pow(10, gainDB/40)
+	return newBiquadFilter(1+alpha*a, -2*c, 1-alpha*a, 1+alpha/a, -2*c, 1-alpha/a)
+}
+
+func (f *biquadFilter) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+func (f *biquadFilter) reset() {
+	f.z1 = 0
+	f.z2 = 0
+}
+
+const compressorAttack = 5 * time.Millisecond
+const compressorRelease = 150 * time.Millisecond
+
+// Reduces the gain by the ratio above the threshold, using a peak envelope follower.
+type compressor struct {
+	thresholdDB float64
+	ratio       float64
+	makeup      float64
+
+	attackCoef  float64
+	releaseCoef float64
+	env         float64
+}
+
+func newCompressor(thresholdDB, ratio, makeupDB float64) compressor {
+	return compressor{
+		thresholdDB: thresholdDB,
+		ratio:       ratio,
+		makeup:      dbToLinear(makeupDB),
+		attackCoef:  getSmoothingCoef(compressorAttack),
+		releaseCoef: getSmoothingCoef(compressorRelease),
+	}
+}
+
+func (c *compressor) process(x float64) float64 {
+	level := math.Abs(x)
+	if level > c.env {
+		c.env = c.attackCoef*c.env + (1-c.attackCoef)*level
+	} else {
+		c.env = c.releaseCoef*c.env + (1-c.releaseCoef)*level
+	}
+
+	gain := c.makeup
+	if c.env > 0 {
+		if envDB := 20 * math.Log10(c.env); envDB > c.thresholdDB {
+			gain *= dbToLinear((c.thresholdDB - envDB) * (1 - 1/c.ratio))
+		}
+	}
+	return x * gain
+}
+
+const limiterRelease = 50 * time.Millisecond
+
+// A brickwall limiter, the output never exceeds the ceiling. The gain is reduced instantly and recovers
+// slowly, the remaining overshoots are clipped.
+type limiter struct {
+	ceiling     float64
+	releaseCoef float64
+	gain        float64
+}
+
+func newLimiter(ceilingDB float64) limiter {
+	return limiter{ceiling: dbToLinear(ceilingDB), releaseCoef: getSmoothingCoef(limiterRelease), gain: 1}
+}
+
+func (l *limiter) process(x float64) float64 {
+	l.gain = l.releaseCoef*l.gain + (1 - l.releaseCoef)
+	if level := math.Abs(x) * l.gain; level > l.ceiling {
+		l.gain = l.ceiling / math.Abs(x)
+	}
+	return math.Max(math.Min(x*l.gain, l.ceiling), -l.ceiling)
+}
+
+// An adaptive linear predictor using the normalized LMS algorithm. It predicts the input sample from the input
+// delayed by the decorrelation delay. Periodic components (voice, carriers) are predictable, so they show up in
+// the prediction, while the noise remains in the error.
+type lmsFilter struct {
+	weights []float64
+	history []float64 // The last len(weights)+delay samples, stored twice so the window is always contiguous.
+	pos     int
+	delay   int
+	mu      float64
+	leakage float64
+}
+
+func newLMSFilter(taps, delay int, mu, leakage float64) lmsFilter {
+	return lmsFilter{
+		weights: make([]float64, taps),
+		history: make([]float64, 2*(taps+delay)),
+		delay:   delay,
+		mu:      mu,
+		leakage: leakage,
+	}
+}
+
+// Returns the predicted and the unpredictable part of the input sample.
+func (f *lmsFilter) process(x float64) (predicted, e float64) {
+	l := len(f.history) / 2
+	f.pos = (f.pos + l - 1) % l
+	f.history[f.pos] = x
+	f.history[f.pos+l] = x
+
+	window := f.history[f.pos+f.delay : f.pos+f.delay+len(f.weights)]
+	var power float64
+	for i, v := range window {
+		predicted += f.weights[i] * v
+		power += v * v
+	}
+	e = x - predicted
+
+	g := f.mu * e / (power + 1e-6)
+	for i, v := range window {
+		f.weights[i] = f.leakage*f.weights[i] + g*v
+	}
+	return
+}
@@ -2,200 +2,235 @@ package main
 
 import "fmt"
 
+const hotkeyEsc = 0x1b
+
+// Called with the number of the pressed function key (1 for F1).
+func handleFunctionKey(n int) {
+	sess := sessions.getActive()
+	if sess == nil {
+		return
+	}
+
+	if err := sess.voiceKeyer.play(n); err != nil {
+		log.Error("can't play voice keyer message: ", err)
+	}
+}
+
 func handleHotkey(k byte) {
+	sess := sessions.getActive()
+	if sess == nil {
+		return
+	}
+
 	switch k {
 	case 'l':
-		audio.togglePlaybackToDefaultSoundcard()
+		sess.audio.togglePlaybackToDefaultSoundcard()
 	case ' ':
-		audio.toggleRecFromDefaultSoundcard()
+		sess.audio.toggleRecFromDefaultSoundcard()
+	case 'w':
+		if err := sess.recorder.toggle(); err != nil {
+			log.Error("can't start recording: ", err)
+		}
 	case 't':
-		if err := civControl.toggleTune(); err != nil {
+		if err := sess.civControl.toggleTune(); err != nil {
 			log.Error("can't toggle tune: ", err)
 		}
 	case '+':
-		if err := civControl.incPwr(); err != nil {
+		if err := sess.civControl.incPwr(); err != nil {
 			log.Error("can't increase power: ", err)
 		}
 	case '-':
-		if err := civControl.decPwr(); err != nil {
+		if err := sess.civControl.decPwr(); err != nil {
 			log.Error("can't decrease power: ", err)
 		}
 	case '0':
-		if err := civControl.setPwr(0); err != nil {
+		if err := sess.civControl.setPwr(0); err != nil {
 			log.Error("can't set power: ", err)
 		}
 	case '1':
-		if err := civControl.setPwr(10); err != nil {
+		if err := sess.civControl.setPwr(10); err != nil {
 			log.Error("can't set power: ", err)
 		}
 	case '2':
-		if err := civControl.setPwr(20); err != nil {
+		if err := sess.civControl.setPwr(20); err != nil {
 			log.Error("can't set power: ", err)
 		}
 	case '3':
-		if err := civControl.setPwr(30); err != nil {
+		if err := sess.civControl.setPwr(30); err != nil {
 			log.Error("can't set power: ", err)
 		}
 	case '4':
-		if err := civControl.setPwr(40); err != nil {
+		if err := sess.civControl.setPwr(40); err != nil {
 			log.Error("can't set power: ", err)
 		}
 	case '5':
-		if err := civControl.setPwr(50); err != nil {
+		if err := sess.civControl.setPwr(50); err != nil {
 			log.Error("can't set power: ", err)
 		}
 	case '6':
-		if err := civControl.setPwr(60); err != nil {
+		if err := sess.civControl.setPwr(60); err != nil {
 			log.Error("can't set power: ", err)
 		}
 	case '7':
-		if err := civControl.setPwr(70); err != nil {
+		if err := sess.civControl.setPwr(70); err != nil {
 			log.Error("can't set power: ", err)
 		}
 	case '8':
-		if err := civControl.setPwr(80); err != nil {
+		if err := sess.civControl.setPwr(80); err != nil {
 			log.Error("can't set power: ", err)
 		}
 	case '9':
-		if err := civControl.setPwr(90); err != nil {
+		if err := sess.civControl.setPwr(90); err != nil {
 			log.Error("can't set power: ", err)
 		}
 	case ')':
-		if err := civControl.setPwr(100); err != nil {
+		if err := sess.civControl.setPwr(100); err != nil {
 			log.Error("can't set power: ", err)
 		}
 	case '!':
-		if err := civControl.setRFGain(10); err != nil {
+		if err := sess.civControl.setRFGain(10); err != nil {
 			log.Error("can't set rfgain: ", err)
 		}
 	case '@':
-		if err := civControl.setRFGain(20); err != nil {
+		if err := sess.civControl.setRFGain(20); err != nil {
 			log.Error("can't set rfgain: ", err)
 		}
 	case '#':
-		if err := civControl.setRFGain(30); err != nil {
+		if err := sess.civControl.setRFGain(30); err != nil {
 			log.Error("can't set rfgain: ", err)
 		}
 	case '$':
-		if err := civControl.setRFGain(40); err != nil {
+		if err := sess.civControl.setRFGain(40); err != nil {
 			log.Error("can't set rfgain: ", err)
 		}
 	case '%':
-		if err := civControl.setRFGain(50); err != nil {
+		if err := sess.civControl.setRFGain(50); err != nil {
 			log.Error("can't set rfgain: ", err)
 		}
 	case '^':
-		if err := civControl.setRFGain(60); err != nil {
+		if err := sess.civControl.setRFGain(60); err != nil {
 			log.Error("can't set rfgain: ", err)
 		}
 	case '&':
-		if err := civControl.setRFGain(70); err != nil {
+		if err := sess.civControl.setRFGain(70); err != nil {
 			log.Error("can't set rfgain: ", err)
 		}
 	case '*':
-		if err := civControl.setRFGain(80); err != nil {
+		if err := sess.civControl.setRFGain(80); err != nil {
 			log.Error("can't set rfgain: ", err)
 		}
 	case '(':
-		if err := civControl.setRFGain(90); err != nil {
+		if err := sess.civControl.setRFGain(90); err != nil {
 			log.Error("can't set rfgain: ", err)
 		}
 	case '\'':
-		if err := civControl.incRFGain(); err != nil {
+		if err := sess.civControl.incRFGain(); err != nil {
 			log.Error("can't increase rf gain: ", err)
 		}
 	case ';':
-		if err := civControl.decRFGain(); err != nil {
+		if err := sess.civControl.decRFGain(); err != nil {
 			log.Error("can't decrease rf gain: ", err)
 		}
 	case '"':
-		if err := civControl.incSQL(); err != nil {
+		if err := sess.civControl.incSQL(); err != nil {
 			log.Error("can't increase sql: ", err)
 		}
 	case ':':
-		if err := civControl.decSQL(); err != nil {
+		if err := sess.civControl.decSQL(); err != nil {
 			log.Error("can't decrease sql: ", err)
 		}
 	case '.':
-		if err := civControl.incNR(); err != nil {
+		if err := sess.civControl.incNR(); err != nil {
 			log.Error("can't increase nr: ", err)
 		}
 	case ',':
-		if err := civControl.decNR(); err != nil {
+		if err := sess.civControl.decNR(); err != nil {
 			log.Error("can't decrease nr: ", err)
 		}
 	case '/':
-		if err := civControl.toggleNR(); err != nil {
+		if err := sess.civControl.toggleNR(); err != nil {
 			log.Error("can't toggle nr: ", err)
 		}
 	case ']':
-		if err := civControl.incFreq(); err != nil {
+		if err := sess.civControl.incFreq(); err != nil {
 			log.Error("can't increase freq: ", err)
 		}
 	case '[':
-		if err := civControl.decFreq(); err != nil {
+		if err := sess.civControl.decFreq(); err != nil {
 			log.Error("can't decrease freq: ", err)
 		}
 	case '}':
-		if err := civControl.incTS(); err != nil {
+		if err := sess.civControl.incTS(); err != nil {
 			log.Error("can't increase ts: ", err)
 		}
 	case '{':
-		if err := civControl.decTS(); err != nil {
+		if err := sess.civControl.decTS(); err != nil {
 			log.Error("can't decrease ts: ", err)
 		}
 	case 'm':
-		if err := civControl.incOperatingMode(); err != nil {
+		if err := sess.civControl.incOperatingMode(); err != nil {
 			log.Error("can't change mode: ", err)
 		}
 	case 'n':
-		if err := civControl.decOperatingMode(); err != nil {
+		if err := sess.civControl.decOperatingMode(); err != nil {
 			log.Error("can't change mode: ", err)
 		}
 	case 'f':
-		if err := civControl.incFilter(); err != nil {
+		if err := sess.civControl.incFilter(); err != nil {
 			log.Error("can't change filter: ", err)
 		}
 	case 'd':
-		if err := civControl.decFilter(); err != nil {
+		if err := sess.civControl.decFilter(); err != nil {
 			log.Error("can't change filter: ", err)
 		}
 	case 'D':
-		if err := civControl.toggleDataMode(); err != nil {
+		if err := sess.civControl.toggleDataMode(); err != nil {
 			log.Error("can't change datamode: ", err)
 		}
 	case 'b':
-		if err := civControl.incBand(); err != nil {
+		if err := sess.civControl.incBand(); err != nil {
 			log.Error("can't change band: ", err)
 		}
 	case 'v':
-		if err := civControl.decBand(); err != nil {
+		if err := sess.civControl.decBand(); err != nil {
 			log.Error("can't change band: ", err)
 		}
 	case 'p':
-		if err := civControl.togglePreamp(); err != nil {
+		if err := sess.civControl.togglePreamp(); err != nil {
 			log.Error("can't change preamp: ", err)
 		}
 	case 'a':
-		if err := civControl.toggleAGC(); err != nil {
+		if err := sess.civControl.toggleAGC(); err != nil {
 			log.Error("can't change agc: ", err)
 		}
 	case 'o':
-		if err := civControl.toggleVFO(); err != nil {
+		if err := sess.civControl.toggleVFO(); err != nil {
 			log.Error("can't change vfo: ", err)
 		}
 	case 's':
-		if err := civControl.toggleSplit(); err != nil {
+		if err := sess.civControl.toggleSplit(); err != nil {
 			log.Error("can't change split: ", err)
 		}
 	case '\n':
-		if statusLog.isRealtime() {
-			statusLog.mutex.Lock()
-			statusLog.clearInternal()
+		if sess.statusLog.isRealtime() {
+			sess.statusLog.mutex.Lock()
+			sess.statusLog.clearInternal()
 			fmt.Println()
-			statusLog.mutex.Unlock()
-			statusLog.print()
-		}
+			sess.statusLog.mutex.Unlock()
+			sess.statusLog.print()
+		}
+	case 'N':
+		sess.rxDSP.toggleNR()
+	case 'A':
+		sess.rxDSP.toggleANF()
+	case 'F':
+		sess.rxDSP.nextBandpass()
+	case 'i':
+		sess.netstat.printReport(sess.logPrefix())
+	case hotkeyEsc:
+		sess.voiceKeyer.stop()
+	case '\t':
+		sessions.switchToNext()
 	case 'q':
 		quitChan <- true
 	}
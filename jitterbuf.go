@@ -0,0 +1,158 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultAudioJitterBufferMin = 20 * time.Millisecond
+const defaultAudioJitterBufferMax = 500 * time.Millisecond
+
+// Retransmit round trips are only considered if a retransmit happened in this interval.
+const jitterBufferRetransmitHoldDuration = 30 * time.Second
+
+// The depth is increased immediately, but it's only decreased by this fraction of the difference for each
+// received packet, so it won't shrink because of a few packets which arrived in time.
+const jitterBufferDecreaseDivider = 256
+
+// Sizes the audio rx seqbuf from the observed inter-arrival jitter and the retransmit round trips. The
+// jitter is calculated like in RFC 3550, with the duration of the received audio used as the timestamp.
+type jitterBufferStruct struct {
+	mutex sync.Mutex
+
+	min   time.Duration
+	max   time.Duration
+	depth time.Duration
+
+	gotFirstPacket     bool
+	lastSeq            uint16
+	lastArrivedAt      time.Time
+	lastMediaTime      time.Duration
+	burstDuration      time.Duration
+	lastPacketWasSplit bool
+	jitter             time.Duration
+
+	retransmitPending     bool
+	retransmitRange       seqNumRange
+	retransmitRequestedAt time.Time
+	retransmitRoundTrip   time.Duration
+	lastRetransmitAt      time.Time
+}
+
+func (j *jitterBufferStruct) getDepth() time.Duration {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.depth
+}
+
+func (j *jitterBufferStruct) isInRetransmitRange(seq uint16) bool {
+	if j.retransmitRange[0] <= j.retransmitRange[1] {
+		return seqNum(seq) >= j.retransmitRange[0] && seqNum(seq) <= j.retransmitRange[1]
+	}
+	// The range wraps around.
+	return seqNum(seq) >= j.retransmitRange[0] || seqNum(seq) <= j.retransmitRange[1]
+}
+
+func (j *jitterBufferStruct) updateDepth() {
+	target := 4 * j.jitter
+	if !j.lastRetransmitAt.IsZero() && time.Since(j.lastRetransmitAt) < jitterBufferRetransmitHoldDuration {
+		// Waiting long enough for the retransmitted packets to arrive.
+		if t := j.retransmitRoundTrip + 2*j.jitter; t > target {
+			target = t
+		}
+	}
+	if target < j.min {
+		target = j.min
+	}
+	if target > j.max {
+		target = j.max
+	}
+
+	if target > j.depth {
+		j.depth = target
+	} else {
+		j.depth -= (j.depth - target) / jitterBufferDecreaseDivider
+	}
+}
+
+// Call this function when the seqbuf requests a retransmit.
+func (j *jitterBufferStruct) reportRetransmitRequest(r seqNumRange) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.retransmitPending = true
+	j.retransmitRange = r
+	j.retransmitRequestedAt = time.Now()
+}
+
+// Call this function when an audio packet arrives. dataDuration is the duration of the audio in the
+// packet, and split should be true if the packet had the max. length, so the rest of the frame comes in the
+// next packet. Returns the new depth.
+func (j *jitterBufferStruct) reportPacket(seq uint16, dataDuration time.Duration, split bool) time.Duration {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	now := time.Now()
+
+	if j.retransmitPending && j.isInRetransmitRange(seq) {
+		j.retransmitPending = false
+		rtt := now.Sub(j.retransmitRequestedAt)
+		if j.lastRetransmitAt.IsZero() {
+			j.retransmitRoundTrip = rtt
+		} else {
+			j.retransmitRoundTrip += (rtt - j.retransmitRoundTrip) / 8
+		}
+		j.lastRetransmitAt = now
+	}
+
+	if !j.gotFirstPacket || seq != j.lastSeq+1 {
+		// Only packets in order are used for calculating the jitter, otherwise the timestamp is unknown. After
+		// lost packets the calculation restarts from the newer packet, older packets are ignored.
+		if diff := seq - j.lastSeq; !j.gotFirstPacket || (diff > 0 && diff < 0x8000) {
+			j.gotFirstPacket = true
+			j.lastSeq = seq
+			j.lastArrivedAt = now
+			j.lastMediaTime = 0
+			j.burstDuration = dataDuration
+			j.lastPacketWasSplit = split
+		}
+		j.updateDepth()
+		return j.depth
+	}
+
+	// Parts of a split frame are sent at the same time by the radio, so they have the same timestamp.
+	mediaTime := j.lastMediaTime
+	if j.lastPacketWasSplit {
+		j.burstDuration += dataDuration
+	} else {
+		mediaTime += j.burstDuration
+		j.burstDuration = dataDuration
+	}
+
+	d := now.Sub(j.lastArrivedAt) - (mediaTime - j.lastMediaTime)
+	if d < 0 {
+		d = -d
+	}
+	j.jitter += (d - j.jitter) / 16
+
+	j.lastSeq = seq
+	j.lastArrivedAt = now
+	j.lastMediaTime = mediaTime
+	j.lastPacketWasSplit = split
+
+	j.updateDepth()
+	return j.depth
+}
+
+func (j *jitterBufferStruct) init(min, max time.Duration) {
+	j.min = min
+	j.max = max
+	j.depth = audioRxSeqBufLength
+	if j.depth < min {
+		j.depth = min
+	}
+	if j.depth > max {
+		j.depth = max
+	}
+}
@@ -3,22 +3,83 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// If no more bytes arrive in this time after an escape byte, then the Esc key was pressed.
+const escSeqTimeout = 50 * time.Millisecond
+
+// Function key escape sequences (without the leading escape byte) of xterm compatible terminals and the
+// Linux console.
+var functionKeySeqs = map[string]int{
+	"OP": 1, "OQ": 2, "OR": 3, "OS": 4,
+	"[11~": 1, "[12~": 2, "[13~": 3, "[14~": 4, "[15~": 5, "[17~": 6,
+	"[18~": 7, "[19~": 8, "[20~": 9, "[21~": 10, "[23~": 11, "[24~": 12,
+	"[[A": 1, "[[B": 2, "[[C": 3, "[[D": 4, "[[E": 5,
+}
+
 type keyboardStruct struct {
 	initialized bool
 }
 
 var keyboard keyboardStruct
 
-func (s *keyboardStruct) loop() {
+func (s *keyboardStruct) readLoop(keyChan chan byte) {
 	var b []byte = make([]byte, 1)
 	for {
 		n, err := os.Stdin.Read(b)
 		if n > 0 && err == nil {
-			handleHotkey(b[0])
+			keyChan <- b[0]
+		}
+	}
+}
+
+// Reads the rest of an escape sequence. Returns an empty string if there's nothing after the escape byte.
+func (s *keyboardStruct) readEscSeq(keyChan chan byte) string {
+	var seq []byte
+	for {
+		select {
+		case b := <-keyChan:
+			seq = append(seq, b)
+		case <-time.After(escSeqTimeout):
+			return string(seq)
+		}
+
+		switch {
+		case len(seq) == 1 && seq[0] != '[' && seq[0] != 'O':
+			return string(seq)
+		case len(seq) == 2 && seq[0] == 'O':
+			return string(seq)
+		case len(seq) == 3 && seq[1] == '[':
+			return string(seq)
+		case len(seq) >= 2 && seq[1] != '[' && seq[len(seq)-1] >= 0x40 && seq[len(seq)-1] <= 0x7e:
+			return string(seq)
+		}
+	}
+}
+
+func (s *keyboardStruct) loop() {
+	keyChan := make(chan byte)
+	go s.readLoop(keyChan)
+
+	for b := range keyChan {
+		if b != hotkeyEsc {
+			handleHotkey(b)
+			continue
+		}
+
+		seq := s.readEscSeq(keyChan)
+		if seq == "" {
+			handleHotkey(hotkeyEsc)
+		} else if n, ok := functionKeySeqs[seq]; ok {
+			handleFunctionKey(n)
 		}
 	}
 }
@@ -43,3 +104,43 @@ func (s *keyboardStruct) init() {
 func (s *keyboardStruct) deinit() {
 	_ = exec.Command("stty", "-F", "/dev/tty", "echo").Run()
 }
+
+// Reads a line from the terminal without displaying the entered characters.
+func readPasswordFromTerminal(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	if err := exec.Command("stty", "-F", "/dev/tty", "-echo").Run(); err != nil {
+		return "", err
+	}
+
+	// Restoring echo if we get interrupted while waiting for the password.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	doneChan := make(chan bool)
+	go func() {
+		select {
+		case <-sigChan:
+			_ = exec.Command("stty", "-F", "/dev/tty", "echo").Run()
+			fmt.Fprintln(tty)
+			os.Exit(1)
+		case <-doneChan:
+		}
+	}()
+
+	line, err := bufio.NewReader(tty).ReadString('\n')
+
+	close(doneChan)
+	signal.Stop(sigChan)
+	_ = exec.Command("stty", "-F", "/dev/tty", "echo").Run()
+	fmt.Fprintln(tty)
+
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -26,8 +26,17 @@ func (l *logger) GetCallerFileName(withLine bool) string {
 	return filename[l.filenameTrimChars : len(filename)-len(extension)]
 }
 
+// Returns the status log of the active session if it's displayed as a realtime status bar.
+func (l *logger) getRealtimeStatusLog() *statusLogStruct {
+	sess := sessions.getActive()
+	if sess == nil || !sess.statusLog.isRealtime() {
+		return nil
+	}
+	return &sess.statusLog
+}
+
 func (l *logger) Print(a ...interface{}) {
-	if statusLog.isRealtime() {
+	if statusLog := l.getRealtimeStatusLog(); statusLog != nil {
 		statusLog.mutex.Lock()
 		statusLog.clearInternal()
 		defer func() {
@@ -43,7 +52,7 @@ func (l *logger) PrintStatusLog(a ...interface{}) {
 }
 
 func (l *logger) Debug(a ...interface{}) {
-	if statusLog.isRealtime() {
+	if statusLog := l.getRealtimeStatusLog(); statusLog != nil {
 		statusLog.mutex.Lock()
 		statusLog.clearInternal()
 		defer func() {
@@ -55,7 +64,7 @@ func (l *logger) Debug(a ...interface{}) {
 }
 
 func (l *logger) Error(a ...interface{}) {
-	if statusLog.isRealtime() {
+	if statusLog := l.getRealtimeStatusLog(); statusLog != nil {
 		statusLog.mutex.Lock()
 		statusLog.clearInternal()
 		defer func() {
@@ -67,7 +76,7 @@ func (l *logger) Error(a ...interface{}) {
 }
 
 func (l *logger) ErrorC(a ...interface{}) {
-	if statusLog.isRealtime() {
+	if statusLog := l.getRealtimeStatusLog(); statusLog != nil {
 		statusLog.mutex.Lock()
 		statusLog.clearInternal()
 		defer func() {
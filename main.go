@@ -4,16 +4,9 @@ import (
 	"os"
 	"os/signal"
 	"runtime/debug"
-	"strings"
 	"syscall"
-	"time"
 )
 
-const waitBetweenRetries = time.Second
-const retryCount = 5
-const waitOnRetryFailure = 65 * time.Second
-
-var gotErrChan = make(chan bool)
 var quitChan = make(chan bool)
 
 func getAboutStr() string {
@@ -27,136 +20,74 @@ func getAboutStr() string {
 	return "kappanhang " + v + " by Norbert Varga HA2NON and Akos Marton ES1AKOS https://github.com/nonoo/kappanhang"
 }
 
-func wait(d time.Duration, osSignal chan os.Signal) (shouldExit bool) {
-	for sec := d.Seconds(); sec > 0; sec-- {
-		log.Print("waiting ", sec, " seconds...")
-		select {
-		case <-time.After(time.Second):
-		case <-osSignal:
-			log.Print("sigterm received")
-			return true
-		case <-quitChan:
-			return true
-		}
-	}
-	return false
-}
+func main() {
+	parseArgs()
+	log.Init()
+	log.Print(getAboutStr())
 
-func runControlStream(osSignal chan os.Signal) (requireWait, shouldExit bool, exitCode int) {
-	// Depleting gotErrChan.
-	var finished bool
-	for !finished {
-		select {
-		case <-gotErrChan:
-		default:
-			finished = true
-		}
+	if simulateMode {
+		os.Exit(runSimulate(sessionConfigs[0]))
 	}
-
-	ctrl := &controlStream{}
-
-	if err := ctrl.init(); err != nil {
-		log.Error(err)
-		ctrl.deinit()
-		if strings.Contains(err.Error(), "invalid username/password") {
-			return false, true, 1
-		}
-		return
+	if replayFile != "" {
+		os.Exit(runReplay(sessionConfigs[0], replayFile))
 	}
 
-	select {
-	// Need to wait before reinit because the IC-705 will disconnect our audio stream eventually if we relogin
-	// in a too short interval without a deauth...
-	case requireWait = <-gotErrChan:
-		ctrl.deinit()
-		return
-	case <-osSignal:
-		log.Print("sigterm received")
-		ctrl.deinit()
-		return false, true, 0
-	case <-quitChan:
-		ctrl.deinit()
-		return false, true, 0
+	if captureFile != "" {
+		if err := capture.init(captureFile); err != nil {
+			log.Error("can't create capture file: ", err)
+			os.Exit(1)
+		}
 	}
-}
 
-func reportError(err error) {
-	if !strings.Contains(err.Error(), "use of closed network connection") {
-		log.ErrorC(log.GetCallerFileName(true), ": ", err)
+	if discoverMode {
+		exitCode := runDiscover(sessionConfigs[0])
+		capture.deinit()
+		os.Exit(exitCode)
 	}
 
-	requireWait := true
-	if strings.Contains(err.Error(), "got radio disconnected") {
-		requireWait = false
+	if statsFilePath != "" {
+		if err := statsFile.init(statsFilePath); err != nil {
+			log.Error("can't write stats file: ", err)
+			os.Exit(1)
+		}
 	}
 
-	// Non-blocking notify.
-	select {
-	case gotErrChan <- requireWait:
-	default:
+	if metricsPort != 0 {
+		if err := metricsServer.init(metricsPort); err != nil {
+			log.Error("can't start metrics server: ", err)
+			os.Exit(1)
+		}
 	}
-}
-
-func main() {
-	parseArgs()
-	log.Init()
-	log.Print(getAboutStr())
 
 	osSignal := make(chan os.Signal, 1)
 	signal.Notify(osSignal, os.Interrupt, syscall.SIGTERM)
 
-	var retries int
-	var requireWait bool
-	var shouldExit bool
-	var exitCode int
-
-exit:
-	for {
-		requireWait, shouldExit, exitCode = runControlStream(osSignal)
+	initStatusLog()
 
-		if shouldExit {
-			break
-		}
-
-		select {
-		case <-osSignal:
-			log.Print("sigterm received")
-			break exit
-		case <-quitChan:
-			break exit
-		default:
-		}
+	stopChan := make(chan bool)
+	finishedChan := make(chan int)
+	go sessions.run(sessionConfigs, stopChan, finishedChan)
 
-		if requireWait {
-			if retries < retryCount {
-				retries++
-				shouldExit = wait(waitBetweenRetries, osSignal)
-			} else {
-				retries = 0
-				shouldExit = wait(waitOnRetryFailure, osSignal)
-			}
-		} else {
-			retries = 0
-			shouldExit = wait(time.Second, osSignal)
-		}
-
-		if shouldExit {
-			break
-		}
-		log.Print("restarting control stream...")
+	var exitCode int
+	select {
+	case <-osSignal:
+		log.Print("sigterm received")
+		close(stopChan)
+		exitCode = <-finishedChan
+	case <-quitChan:
+		close(stopChan)
+		exitCode = <-finishedChan
+	case exitCode = <-finishedChan:
 	}
 
-	rigctld.deinit()
-	serialTCPSrv.deinit()
-	runCmdRunner.stop()
-	serialCmdRunner.stop()
-	audio.deinit()
-	serialPort.deinit()
-
-	if statusLog.isRealtimeInternal() {
+	if keyboard.initialized {
 		keyboard.deinit()
 	}
 
+	metricsServer.deinit()
+	statsFile.deinit()
+	capture.deinit()
+
 	log.Print("exiting")
 	os.Exit(exitCode)
 }
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prometheus metrics are served in the text exposition format on /metrics. The radio state values are
+// reported to each session's metrics by civControl, like they are reported to the status log.
+
+type metricsDesc struct {
+	name string
+	typ  string
+	help string
+}
+
+var metricsDescs = []metricsDesc{
+	{"kappanhang_connection_state", "gauge", "Connection state of the session, the current state is 1."},
+	{"kappanhang_reconnects_total", "counter", "Number of times the session reconnected to the radio."},
+	{"kappanhang_rtt_seconds", "gauge", "Last measured ping roundtrip latency of the stream."},
+	{"kappanhang_stream_sent_packets_total", "counter", "Packets sent to the radio."},
+	{"kappanhang_stream_sent_bytes_total", "counter", "UDP payload bytes sent to the radio."},
+	{"kappanhang_stream_received_packets_total", "counter", "Packets received from the radio."},
+	{"kappanhang_stream_received_bytes_total", "counter", "UDP payload bytes received from the radio."},
+	{"kappanhang_stream_lost_packets_total", "counter", "Packets from the radio which were lost even after retransmit requests."},
+	{"kappanhang_stream_retransmit_requests_sent_total", "counter", "Packets requested to be retransmitted by the radio."},
+	{"kappanhang_stream_retransmit_requests_received_total", "counter", "Packets the radio requested to be retransmitted."},
+	{"kappanhang_stream_out_of_order_packets_total", "counter", "Packets from the radio which arrived out of order."},
+	{"kappanhang_stream_late_packets_total", "counter", "Out of order packets which arrived too late and were dropped."},
+	{"kappanhang_stream_duplicate_packets_total", "counter", "Duplicate packets from the radio."},
+	{"kappanhang_stream_jitter_seconds", "histogram", "Interval variation of the ping packets sent by the radio."},
+	{"kappanhang_frequency_hz", "gauge", "Operating frequency."},
+	{"kappanhang_mode_info", "gauge", "Operating mode, data mode and filter."},
+	{"kappanhang_s_meter", "gauge", "S meter level, 9 is S9, values above are S9+10dB, S9+20dB etc. up to 18 (S9+60dB)."},
+	{"kappanhang_swr", "gauge", "Last reported SWR."},
+	{"kappanhang_vd_volts", "gauge", "Drain voltage of the final amplifier MOS-FETs."},
+	{"kappanhang_tx_power_percent", "gauge", "Transmit power setting."},
+	{"kappanhang_ptt", "gauge", "1 if the PTT is active."},
+	{"kappanhang_tune", "gauge", "1 if tuning is in progress."},
+	{"kappanhang_transmit_seconds_total", "counter", "Total time spent transmitting (PTT or tune)."},
+	{"kappanhang_audio_peak_dbfs", "gauge", "Peak level of the received or transmitted audio."},
+	{"kappanhang_audio_rms_dbfs", "gauge", "RMS level of the received or transmitted audio."},
+	{"kappanhang_audio_clips_total", "counter", "Audio frames with full scale (clipped) samples."},
+}
+
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Radio state values of a session.
+type metricsStruct struct {
+	mutex sync.Mutex
+
+	gauges     map[string]float64
+	modeLabels string
+	reconnects int
+
+	transmitting bool
+	txStartedAt  time.Time
+	txTime       time.Duration
+	gotPTTOrTune bool
+	ptt, tune    bool
+}
+
+func (s *metricsStruct) setGauge(name string, v float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.gauges == nil {
+		s.gauges = make(map[string]float64)
+	}
+	s.gauges[name] = v
+}
+
+func (s *metricsStruct) reportFrequency(f uint) {
+	s.setGauge("kappanhang_frequency_hz", float64(f))
+}
+
+func (s *metricsStruct) reportMode(mode string, dataMode bool, filter string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var d string
+	if dataMode {
+		d = "1"
+	} else {
+		d = "0"
+	}
+	s.modeLabels = fmt.Sprintf(`mode="%s",data="%s",filter="%s"`, metricsLabelEscaper.Replace(mode), d,
+		metricsLabelEscaper.Replace(filter))
+}
+
+func (s *metricsStruct) reportS(level int) {
+	s.setGauge("kappanhang_s_meter", float64(level))
+}
+
+func (s *metricsStruct) reportSWR(swr float64) {
+	s.setGauge("kappanhang_swr", swr)
+}
+
+func (s *metricsStruct) reportVd(voltage float64) {
+	s.setGauge("kappanhang_vd_volts", voltage)
+}
+
+func (s *metricsStruct) reportTxPower(percent int) {
+	s.setGauge("kappanhang_tx_power_percent", float64(percent))
+}
+
+func (s *metricsStruct) reportPTT(ptt, tune bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.gotPTTOrTune = true
+	s.ptt = ptt
+	s.tune = tune
+
+	transmitting := ptt || tune
+	if transmitting && !s.transmitting {
+		s.txStartedAt = time.Now()
+	} else if !transmitting && s.transmitting {
+		s.txTime += time.Since(s.txStartedAt)
+	}
+	s.transmitting = transmitting
+}
+
+func (s *metricsStruct) reportReconnect() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.reconnects++
+}
+
+// Collects the samples of each metric, so they can be written grouped by the metric name.
+type metricsWriter struct {
+	samples map[string][]string
+}
+
+func (w *metricsWriter) add(name, labels string, v float64) {
+	w.addSample(name, name, labels, v)
+}
+
+func (w *metricsWriter) addSample(metricName, sampleName, labels string, v float64) {
+	if w.samples == nil {
+		w.samples = make(map[string][]string)
+	}
+	w.samples[metricName] = append(w.samples[metricName], fmt.Sprint(sampleName, "{", labels, "} ",
+		strconv.FormatFloat(v, 'f', -1, 64)))
+}
+
+func (w *metricsWriter) addHistogram(name, labels string, bucketsMs []int, counts []int, sumMs float64) {
+	var count int
+	for i, c := range counts {
+		count += c
+		le := "+Inf"
+		if i < len(bucketsMs) {
+			le = fmt.Sprint(float64(bucketsMs[i]) / 1000)
+		}
+		w.addSample(name, name+"_bucket", labels+`,le="`+le+`"`, float64(count))
+	}
+	w.addSample(name, name+"_sum", labels, sumMs/1000)
+	w.addSample(name, name+"_count", labels, float64(count))
+}
+
+func (w *metricsWriter) String() string {
+	var b strings.Builder
+	for _, d := range metricsDescs {
+		samples := w.samples[d.name]
+		if len(samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.typ)
+		for _, l := range samples {
+			b.WriteString(l)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+func (s *session) writeMetrics(w *metricsWriter) {
+	sessLabel := `session="` + metricsLabelEscaper.Replace(s.conf.name) + `"`
+
+	state := s.connState.get()
+	for st := connStateIdle; st <= connStateFailed; st++ {
+		var v float64
+		if st == state {
+			v = 1
+		}
+		w.add("kappanhang_connection_state", sessLabel+`,state="`+st.String()+`"`, v)
+	}
+
+	for _, r := range s.netstat.getStreamReports() {
+		l := sessLabel + `,stream="` + r.Name + `"`
+		t := &r.Total
+		if r.RTTLastMs > 0 {
+			w.add("kappanhang_rtt_seconds", l, r.RTTLastMs/1000)
+		}
+		w.add("kappanhang_stream_sent_packets_total", l, float64(t.SentPackets))
+		w.add("kappanhang_stream_sent_bytes_total", l, float64(t.SentBytes))
+		w.add("kappanhang_stream_received_packets_total", l, float64(t.ReceivedPackets))
+		w.add("kappanhang_stream_received_bytes_total", l, float64(t.ReceivedBytes))
+		w.add("kappanhang_stream_lost_packets_total", l, float64(t.Lost))
+		w.add("kappanhang_stream_retransmit_requests_sent_total", l, float64(t.RetransmitRequestsSent))
+		w.add("kappanhang_stream_retransmit_requests_received_total", l, float64(t.RetransmitRequestsReceived))
+		w.add("kappanhang_stream_out_of_order_packets_total", l, float64(t.OutOfOrder))
+		w.add("kappanhang_stream_late_packets_total", l, float64(t.Late))
+		w.add("kappanhang_stream_duplicate_packets_total", l, float64(t.Duplicates))
+		if t.JitterHistogram != nil {
+			w.addHistogram("kappanhang_stream_jitter_seconds", l, netstatJitterBucketsMs, t.JitterHistogram,
+				t.JitterSumMs)
+		}
+	}
+
+	for _, l := range []struct {
+		direction string
+		r         audioLevelReport
+	}{{"rx", s.rxAudioLevel.get()}, {"tx", s.txAudioLevel.get()}} {
+		dl := sessLabel + `,direction="` + l.direction + `"`
+		w.add("kappanhang_audio_peak_dbfs", dl, l.r.PeakDBFS)
+		w.add("kappanhang_audio_rms_dbfs", dl, l.r.RMSDBFS)
+		w.add("kappanhang_audio_clips_total", dl, float64(l.r.Clips))
+	}
+
+	m := &s.metrics
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	w.add("kappanhang_reconnects_total", sessLabel, float64(m.reconnects))
+	for name, v := range m.gauges {
+		w.add(name, sessLabel, v)
+	}
+	if m.modeLabels != "" {
+		w.add("kappanhang_mode_info", sessLabel+","+m.modeLabels, 1)
+	}
+	if m.gotPTTOrTune {
+		var ptt, tune float64
+		if m.ptt {
+			ptt = 1
+		}
+		if m.tune {
+			tune = 1
+		}
+		w.add("kappanhang_ptt", sessLabel, ptt)
+		w.add("kappanhang_tune", sessLabel, tune)
+	}
+	txTime := m.txTime
+	if m.transmitting {
+		txTime += time.Since(m.txStartedAt)
+	}
+	w.add("kappanhang_transmit_seconds_total", sessLabel, txTime.Seconds())
+}
+
+type metricsServerStruct struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+var metricsServer metricsServerStruct
+
+func (s *metricsServerStruct) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	sessions.mutex.Lock()
+	list := sessions.list
+	sessions.mutex.Unlock()
+
+	var mw metricsWriter
+	for _, sess := range list {
+		sess.writeMetrics(&mw)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(mw.String()))
+}
+
+func (s *metricsServerStruct) init(port uint16) (err error) {
+	s.listener, err = net.Listen("tcp", fmt.Sprint(":", port))
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	s.server = &http.Server{Handler: mux}
+
+	log.Print("serving prometheus metrics on http://", s.listener.Addr(), "/metrics")
+
+	go func() {
+		if err := s.server.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+			log.Error("metrics server: ", err)
+		}
+	}()
+	return nil
+}
+
+func (s *metricsServerStruct) deinit() {
+	if s.server == nil {
+		return
+	}
+	_ = s.server.Close()
+}
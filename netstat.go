@@ -2,11 +2,305 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
 
+// Per-stream statistics are collected for the whole run, and also for each minute in a rolling history.
+const netstatHistoryLength = 15
+const netstatHistoryInterval = time.Minute
+
+// Upper bounds of the jitter histogram buckets in milliseconds. The last bucket holds larger values.
+var netstatJitterBucketsMs = []int{1, 2, 5, 10, 20, 50, 100, 200}
+
+// Duplicates are detected in this many recently received seqnums.
+const netstatSeqWindowLength = 256
+
+// JSON field names are used in the --stats-file dump.
+type netstatCounters struct {
+	SentPackets                int `json:"sent_packets"`
+	SentBytes                  int `json:"sent_bytes"`
+	ReceivedPackets            int `json:"received_packets"`
+	ReceivedBytes              int `json:"received_bytes"`
+	Lost                       int `json:"lost"`
+	RetransmitRequestsSent     int `json:"retransmit_requests_sent"`
+	RetransmitRequestsReceived int `json:"retransmit_requests_received"`
+	OutOfOrder                 int `json:"out_of_order"`
+	Late                       int `json:"late"`
+	Duplicates                 int `json:"duplicates"`
+
+	RTTMinMs float64 `json:"rtt_min_ms"`
+	RTTAvgMs float64 `json:"rtt_avg_ms"`
+	RTTMaxMs float64 `json:"rtt_max_ms"`
+	rttSum   time.Duration
+	rttCount int
+
+	JitterHistogram []int   `json:"jitter_histogram"`
+	JitterSumMs     float64 `json:"jitter_sum_ms"`
+}
+
+type netstatHistoryEntry struct {
+	Start time.Time `json:"start"`
+	netstatCounters
+}
+
+type netstatStreamReport struct {
+	Name      string                `json:"name"`
+	RTTLastMs float64               `json:"rtt_last_ms"`
+	Total     netstatCounters       `json:"total"`
+	History   []netstatHistoryEntry `json:"history"`
+}
+
+// Statistics of one stream (control, serial or audio). The reports are also forwarded to the session's
+// netstat, which is used by the status bar.
+type netstatStream struct {
+	mutex  sync.Mutex
+	parent *netstatStruct
+	name   string
+
+	total   netstatCounters
+	current netstatHistoryEntry
+	history []netstatHistoryEntry
+
+	gotRxSeq      bool
+	highestRxSeq  uint16
+	recentRxSeqs  [netstatSeqWindowLength]uint16
+	recentRxValid [netstatSeqWindowLength]bool
+
+	lastRTT time.Duration
+
+	lastPingAt       time.Time
+	lastPingSeq      uint16
+	lastPingInterval time.Duration
+}
+
+func (c *netstatCounters) addRTT(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	if c.rttCount == 0 || ms < c.RTTMinMs {
+		c.RTTMinMs = ms
+	}
+	if ms > c.RTTMaxMs {
+		c.RTTMaxMs = ms
+	}
+	c.rttSum += d
+	c.rttCount++
+	c.RTTAvgMs = float64(c.rttSum) / float64(c.rttCount) / float64(time.Millisecond)
+}
+
+func (c *netstatCounters) addJitter(d time.Duration) {
+	if c.JitterHistogram == nil {
+		c.JitterHistogram = make([]int, len(netstatJitterBucketsMs)+1)
+	}
+	i := 0
+	for i < len(netstatJitterBucketsMs) && d >= time.Duration(netstatJitterBucketsMs[i])*time.Millisecond {
+		i++
+	}
+	c.JitterHistogram[i]++
+	c.JitterSumMs += float64(d) / float64(time.Millisecond)
+}
+
+func (c netstatCounters) copy() netstatCounters {
+	if c.JitterHistogram != nil {
+		c.JitterHistogram = append([]int{}, c.JitterHistogram...)
+	}
+	return c
+}
+
+// Moves the current minute to the history if it's over. Expects the mutex to be locked.
+func (n *netstatStream) rotateHistory() {
+	now := time.Now()
+	if n.current.Start.IsZero() {
+		n.current.Start = now
+		return
+	}
+	if now.Sub(n.current.Start) < netstatHistoryInterval {
+		return
+	}
+	n.history = append(n.history, n.current)
+	if len(n.history) > netstatHistoryLength {
+		n.history = n.history[len(n.history)-netstatHistoryLength:]
+	}
+	n.current = netstatHistoryEntry{Start: now}
+}
+
+// Calls f for the total and the current minute's counters.
+func (n *netstatStream) update(f func(c *netstatCounters)) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.rotateHistory()
+	f(&n.total)
+	f(&n.current.netstatCounters)
+}
+
+// Call this function when a packet is sent or received.
+func (n *netstatStream) add(toRadioBytes, fromRadioBytes int) {
+	n.parent.add(toRadioBytes, fromRadioBytes)
+	n.update(func(c *netstatCounters) {
+		if toRadioBytes > 0 {
+			c.SentPackets++
+			c.SentBytes += toRadioBytes
+		}
+		if fromRadioBytes > 0 {
+			c.ReceivedPackets++
+			c.ReceivedBytes += fromRadioBytes
+		}
+	})
+}
+
+func (n *netstatStream) reportLoss(pkts int) {
+	n.parent.reportLoss(pkts)
+	n.update(func(c *netstatCounters) {
+		c.Lost += pkts
+	})
+}
+
+// Call this function when we request retransmit from the radio.
+func (n *netstatStream) reportRetransmitRequestSent(pkts int) {
+	n.parent.reportRetransmit(pkts)
+	n.update(func(c *netstatCounters) {
+		c.RetransmitRequestsSent += pkts
+	})
+}
+
+// Call this function when the radio requests retransmit from us.
+func (n *netstatStream) reportRetransmitRequestReceived(pkts int) {
+	n.parent.reportRetransmit(pkts)
+	n.update(func(c *netstatCounters) {
+		c.RetransmitRequestsReceived += pkts
+	})
+}
+
+func (n *netstatStream) reportRTT(d time.Duration) {
+	n.mutex.Lock()
+	n.lastRTT = d
+	n.mutex.Unlock()
+
+	n.update(func(c *netstatCounters) {
+		c.addRTT(d)
+	})
+}
+
+// Call this function when a ping request arrives from the radio. The radio sends them in a fixed interval,
+// so the variation of the intervals is used as the jitter of the stream. Intervals are only measured
+// between consecutive pings, lost and reordered pings are skipped.
+func (n *netstatStream) reportPing(seq uint16) {
+	n.mutex.Lock()
+	now := time.Now()
+	var interval, jitter time.Duration
+	gotJitter := false
+	if !n.lastPingAt.IsZero() && seq == n.lastPingSeq+1 {
+		interval = now.Sub(n.lastPingAt)
+		if n.lastPingInterval > 0 {
+			jitter = interval - n.lastPingInterval
+			if jitter < 0 {
+				jitter = -jitter
+			}
+			gotJitter = true
+		}
+	}
+	n.lastPingAt = now
+	n.lastPingSeq = seq
+	n.lastPingInterval = interval
+	n.mutex.Unlock()
+
+	if gotJitter {
+		n.update(func(c *netstatCounters) {
+			c.addJitter(jitter)
+		})
+	}
+}
+
+// Call this function when a sequenced packet arrives from the radio, before it's added to the seqbuf. late
+// should be true if the seqbuf has already passed the seqnum.
+func (n *netstatStream) reportRxSeq(seq uint16, late bool) {
+	n.mutex.Lock()
+	idx := seq % netstatSeqWindowLength
+	duplicate := n.recentRxValid[idx] && n.recentRxSeqs[idx] == seq
+	n.recentRxSeqs[idx] = seq
+	n.recentRxValid[idx] = true
+
+	outOfOrder := false
+	if !n.gotRxSeq {
+		n.gotRxSeq = true
+		n.highestRxSeq = seq
+	} else if diff := seq - n.highestRxSeq; diff > 0 && diff < 0x8000 {
+		n.highestRxSeq = seq
+	} else if !duplicate {
+		outOfOrder = true
+	}
+	n.mutex.Unlock()
+
+	if !duplicate && !outOfOrder {
+		return
+	}
+	n.update(func(c *netstatCounters) {
+		switch {
+		case duplicate:
+			c.Duplicates++
+		case late:
+			c.OutOfOrder++
+			c.Late++
+		default:
+			c.OutOfOrder++
+		}
+	})
+}
+
+// Clears the seqnum and ping tracking, as they restart with the stream. Counters and the history are kept.
+func (n *netstatStream) resetTracking() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.gotRxSeq = false
+	n.recentRxValid = [netstatSeqWindowLength]bool{}
+	n.lastPingAt = time.Time{}
+	n.lastPingInterval = 0
+}
+
+func (n *netstatStream) getReport() netstatStreamReport {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.rotateHistory()
+	r := netstatStreamReport{
+		Name:      n.name,
+		RTTLastMs: float64(n.lastRTT) / float64(time.Millisecond),
+		Total:     n.total.copy(),
+	}
+	for _, e := range n.history {
+		r.History = append(r.History, netstatHistoryEntry{Start: e.Start, netstatCounters: e.copy()})
+	}
+	r.History = append(r.History, netstatHistoryEntry{Start: n.current.Start, netstatCounters: n.current.copy()})
+	return r
+}
+
+func (c *netstatCounters) formatJitterHistogram() string {
+	if c.JitterHistogram == nil {
+		return "no data"
+	}
+	var res []string
+	for i, v := range c.JitterHistogram {
+		var bucket string
+		switch {
+		case i == 0:
+			bucket = fmt.Sprint("<", netstatJitterBucketsMs[0])
+		case i == len(netstatJitterBucketsMs):
+			bucket = fmt.Sprint(">=", netstatJitterBucketsMs[i-1])
+		default:
+			bucket = fmt.Sprint(netstatJitterBucketsMs[i-1], "-", netstatJitterBucketsMs[i])
+		}
+		res = append(res, fmt.Sprint(bucket, "ms:", v))
+	}
+	return strings.Join(res, " ")
+}
+
 type netstatStruct struct {
+	mutex sync.Mutex
+
+	streams []*netstatStream
+
 	toRadioBytes   int
 	toRadioPkts    int
 	fromRadioBytes int
@@ -17,22 +311,81 @@ type netstatStruct struct {
 	lastLostReport       time.Time
 	retransmits          int
 	lastRetransmitReport time.Time
+	concealed            time.Duration
+	lastConcealedReport  time.Time
 }
 
-var netstat netstatStruct
-var netstatMutex sync.Mutex
-
 func (b *netstatStruct) reset() {
-	netstatMutex.Lock()
-	defer netstatMutex.Unlock()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 
-	netstat = netstatStruct{}
+	b.toRadioBytes = 0
+	b.toRadioPkts = 0
+	b.fromRadioBytes = 0
+	b.fromRadioPkts = 0
+	b.lastGet = time.Time{}
+	b.lostPkts = 0
+	b.lastLostReport = time.Time{}
+	b.retransmits = 0
+	b.lastRetransmitReport = time.Time{}
+	b.concealed = 0
+	b.lastConcealedReport = time.Time{}
+
+	for _, n := range b.streams {
+		n.resetTracking()
+	}
+}
+
+// Returns the statistics of the given stream, creating them on the first call.
+func (b *netstatStruct) getStream(name string) *netstatStream {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, n := range b.streams {
+		if n.name == name {
+			return n
+		}
+	}
+	n := &netstatStream{parent: b, name: name}
+	b.streams = append(b.streams, n)
+	return n
+}
+
+func (b *netstatStruct) getStreamReports() (res []netstatStreamReport) {
+	b.mutex.Lock()
+	streams := append([]*netstatStream{}, b.streams...)
+	b.mutex.Unlock()
+
+	for _, n := range streams {
+		res = append(res, n.getReport())
+	}
+	return
+}
+
+// Prints a detailed report of the per-stream statistics to the log.
+func (b *netstatStruct) printReport(logPrefix string) {
+	for _, r := range b.getStreamReports() {
+		t := &r.Total
+		log.Print(logPrefix, r.Name, "/rx ", t.ReceivedPackets, " pkts ", b.formatByteCount(t.ReceivedBytes),
+			" tx ", t.SentPackets, " pkts ", b.formatByteCount(t.SentBytes), " lost ", t.Lost,
+			" retx sent ", t.RetransmitRequestsSent, " received ", t.RetransmitRequestsReceived,
+			" out of order ", t.OutOfOrder, " late ", t.Late, " dup ", t.Duplicates,
+			fmt.Sprintf(" rtt min/avg/max %.1f/%.1f/%.1fms", t.RTTMinMs, t.RTTAvgMs, t.RTTMaxMs))
+		log.Print(logPrefix, r.Name, "/jitter ", t.formatJitterHistogram())
+
+		var history []string
+		for _, e := range r.History {
+			history = append(history, fmt.Sprint(e.Start.Format("15:04"), " rx ", e.ReceivedPackets,
+				" lost ", e.Lost, " retx ", e.RetransmitRequestsSent+e.RetransmitRequestsReceived, " late ", e.Late))
+		}
+		log.Print(logPrefix, r.Name, "/history ", strings.Join(history, ", "))
+	}
 }
 
 // Call this function when a packet is sent or received.
 func (b *netstatStruct) add(toRadioBytes, fromRadioBytes int) {
-	netstatMutex.Lock()
-	defer netstatMutex.Unlock()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 
 	b.toRadioBytes += toRadioBytes
 	if toRadioBytes > 0 {
@@ -45,24 +398,34 @@ func (b *netstatStruct) add(toRadioBytes, fromRadioBytes int) {
 }
 
 func (b *netstatStruct) reportLoss(pkts int) {
-	netstatMutex.Lock()
-	defer netstatMutex.Unlock()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 
 	b.lastLostReport = time.Now()
 	b.lostPkts += pkts
 }
 
 func (b *netstatStruct) reportRetransmit(pkts int) {
-	netstatMutex.Lock()
-	defer netstatMutex.Unlock()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 
 	b.lastRetransmitReport = time.Now()
 	b.retransmits += pkts
 }
 
-func (b *netstatStruct) get() (toRadioBytesPerSec, fromRadioBytesPerSec int, lost int, retransmits int) {
-	netstatMutex.Lock()
-	defer netstatMutex.Unlock()
+// Call this function when lost audio is replaced by concealment audio.
+func (b *netstatStruct) reportConcealed(d time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lastConcealedReport = time.Now()
+	b.concealed += d
+}
+
+func (b *netstatStruct) get() (toRadioBytesPerSec, fromRadioBytesPerSec int, lost int, retransmits int,
+	concealed time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 
 	secs := time.Since(b.lastGet).Seconds()
 	toRadioBytesPerSec = int(float64(b.toRadioBytes) / secs)
@@ -88,6 +451,13 @@ func (b *netstatStruct) get() (toRadioBytesPerSec, fromRadioBytesPerSec int, los
 		b.lastRetransmitReport = time.Now()
 	}
 
+	secs = time.Since(b.lastConcealedReport).Seconds()
+	concealed = b.concealed
+	if secs >= 60 {
+		b.concealed = 0
+		b.lastConcealedReport = time.Now()
+	}
+
 	return
 }
 
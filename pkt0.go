@@ -13,7 +13,7 @@ const pkt0IdleSendInterval = time.Second
 
 type pkt0Type struct {
 	sendSeq uint16
-	mutex   sync.Mutex // Protects sendSeq
+	mutex   sync.Mutex // Protects sendSeq and lastTrackedSentAt
 
 	sendTimer         *time.Timer
 	lastTrackedSentAt time.Time
@@ -28,14 +28,16 @@ type pkt0Type struct {
 func (p *pkt0Type) retransmitRange(s *streamCommon, start, end uint16) error {
 	log.Debug(s.name+"/got retransmit request for #", start, "-", end)
 	for {
-		netstat.reportRetransmit(1)
+		s.stats.reportRetransmitRequestReceived(1)
 		d := p.txSeqBuf.get(seqNum(start))
 		if d != nil {
 			log.Debug(s.name+"/retransmitting #", start)
-			if err := s.send(d); err != nil {
-				return err
+			err := s.send(d)
+			if err == nil {
+				err = s.send(d)
 			}
-			if err := s.send(d); err != nil {
+			pktBufPool.put(d)
+			if err != nil {
 				return err
 			}
 		} else {
@@ -69,11 +71,13 @@ func (p *pkt0Type) handle(s *streamCommon, r []byte) error {
 		log.Debug(s.name+"/got retransmit request for #", seq)
 		if d != nil {
 			log.Debug(s.name+"/retransmitting #", seq)
-			netstat.reportRetransmit(1)
-			if err := s.send(d); err != nil {
-				return err
+			s.stats.reportRetransmitRequestReceived(1)
+			err := s.send(d)
+			if err == nil {
+				err = s.send(d)
 			}
-			if err := s.send(d); err != nil {
+			pktBufPool.put(d)
+			if err != nil {
 				return err
 			}
 		} else {
@@ -176,10 +180,13 @@ func (p *pkt0Type) loop(s *streamCommon) {
 			p.sendTimer.Reset(pkt0DefaultSendInterval)
 		case <-p.sendTimer.C:
 			if err := p.sendIdle(s, true, 0); err != nil {
-				reportError(err)
+				s.sess.reportError(err)
 			}
 
-			if time.Since(p.lastTrackedSentAt) >= pkt0IdleAfter {
+			p.mutex.Lock()
+			idle := time.Since(p.lastTrackedSentAt) >= pkt0IdleAfter
+			p.mutex.Unlock()
+			if idle {
 				p.sendTimer.Reset(pkt0IdleSendInterval)
 			} else {
 				p.sendTimer.Reset(pkt0DefaultSendInterval)
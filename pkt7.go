@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/binary"
-	"errors"
+	"sync"
 	"time"
 )
 
@@ -22,13 +22,12 @@ type pkt7Type struct {
 	timeoutTimer *time.Timer
 	latency      time.Duration
 	lastSendAt   time.Time
+	mutex        sync.Mutex // Protects lastSendAt, as the replies are handled by the stream's reader.
 
 	periodicStopNeededChan   chan bool
 	periodicStopFinishedChan chan bool
 }
 
-var controlStreamLatency time.Duration
-
 func (p *pkt7Type) isPkt7(r []byte) bool {
 	return len(r) == 21 && bytes.Equal(r[1:6], []byte{0x00, 0x00, 0x00, 0x07, 0x00}) // Note that the first byte can be 0x15 or 0x00, so we ignore that.
 }
@@ -36,6 +35,8 @@ func (p *pkt7Type) isPkt7(r []byte) bool {
 func (p *pkt7Type) handle(s *streamCommon, r []byte) error {
 	gotSeq := binary.LittleEndian.Uint16(r[6:8])
 	if r[16] == 0x00 { // This is a pkt7 request from the radio.
+		s.stats.reportPing(gotSeq)
+
 		// Replying to the radio.
 		// Example request from radio: 0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x1c, 0x0e, 0xe4, 0x35, 0xdd, 0x72, 0xbe, 0xd9, 0xf2, 0x63, 0x00, 0x57, 0x2b, 0x12, 0x00
 		// Example answer from PC:     0x15, 0x00, 0x00, 0x00, 0x07, 0x00, 0x1c, 0x0e, 0xbe, 0xd9, 0xf2, 0x63, 0xe4, 0x35, 0xdd, 0x72, 0x01, 0x57, 0x2b, 0x12, 0x00
@@ -51,13 +52,18 @@ func (p *pkt7Type) handle(s *streamCommon, r []byte) error {
 				p.timeoutTimer.Reset(pkt7TimeoutDuration)
 			}
 
-			if s.name == "control" { // Only measure latency on the control stream.
+			p.mutex.Lock()
+			rtt := time.Since(p.lastSendAt)
+			p.mutex.Unlock()
+			s.stats.reportRTT(rtt)
+
+			if s.kind == "control" { // Only measure latency on the control stream.
 				// Only measure latency after the timeout has been initialized, so the auth is already done.
-				p.latency += time.Since(p.lastSendAt)
+				p.latency += rtt
 				p.latency /= 2
-				statusLog.reportRTTLatency(p.latency)
+				s.sess.statusLog.reportRTTLatency(p.latency)
 
-				controlStreamLatency = p.latency
+				s.sess.controlStreamLatency = p.latency
 			}
 		}
 
@@ -109,7 +115,9 @@ func (p *pkt7Type) send(s *streamCommon) error {
 	if err := p.sendDo(s, nil, p.sendSeq); err != nil {
 		return err
 	}
+	p.mutex.Lock()
 	p.lastSendAt = time.Now()
+	p.mutex.Unlock()
 	p.sendSeq++
 	return nil
 }
@@ -123,11 +131,11 @@ func (p *pkt7Type) loop(s *streamCommon) {
 		if p.timeoutTimer != nil {
 			select {
 			case <-p.timeoutTimer.C:
-				reportError(errors.New(s.name + "/ping timeout"))
+				s.sess.reportError(newConnError(connErrorTimeout, s.name, "/ping timeout"))
 
 			case <-p.sendTicker.C:
 				if err := p.send(s); err != nil {
-					reportError(err)
+					s.sess.reportError(err)
 				}
 			case <-p.periodicStopNeededChan:
 				p.periodicStopFinishedChan <- true
@@ -137,7 +145,7 @@ func (p *pkt7Type) loop(s *streamCommon) {
 			select {
 			case <-p.sendTicker.C:
 				if err := p.send(s); err != nil {
-					reportError(err)
+					s.sess.reportError(err)
 				}
 			case <-p.periodicStopNeededChan:
 				p.periodicStopFinishedChan <- true
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Packet loss concealment: lost audio packets are replaced by audio of the same length, so the timing of
+// the audio played to the sound cards does not change.
+
+type audioPLCMode int
+
+const (
+	audioPLCModeSilence = audioPLCMode(iota)
+	audioPLCModeRepeat
+)
+
+var audioPLCModeNames = []string{"silence", "repeat"}
+
+const defaultAudioPLCMode = "repeat"
+
+const audioPLCHistoryLength = audioFrameLength
+const audioPLCCrossfadeLength = 2 * time.Millisecond
+
+// Repeated audio is faded out after this long, as repeating the same waveform for long sounds unnatural.
+const audioPLCFadeOutAfter = 60 * time.Millisecond
+const audioPLCFadeOutLength = 100 * time.Millisecond
+
+// Longer losses are only concealed with this much audio.
+const audioPLCMaxLength = time.Second
+
+func getAudioPLCMode(name string) (audioPLCMode, error) {
+	for i, n := range audioPLCModeNames {
+		if n == name {
+			return audioPLCMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown packet loss concealment mode %s, available modes: %s", name,
+		strings.Join(audioPLCModeNames, ", "))
+}
+
+func (m audioPLCMode) String() string {
+	return audioPLCModeNames[m]
+}
+
+func getAudioSampleCount(d time.Duration) int {
+	return int(d * audioSampleRate / time.Second)
+}
+
+// Generates the concealment audio in 48kHz, s16le, mono format.
+type audioConcealer struct {
+	mode audioPLCMode
+
+	// The last received samples, repeated when packets are lost.
+	history []int16
+
+	// The position of the next repeated sample in the history, and the count of concealed samples since the
+	// last received audio.
+	repeatPos     int
+	repeatedCount int
+	concealing    bool
+}
+
+// Returns the next sample of the repeated history. The history is looped with a crossfade at the loop point,
+// and it's faded out if the loss is long.
+func (c *audioConcealer) getRepeatedSample() int16 {
+	if len(c.history) == 0 {
+		return 0
+	}
+
+	crossfadeLen := getAudioSampleCount(audioPLCCrossfadeLength)
+	if crossfadeLen > len(c.history)/2 {
+		crossfadeLen = len(c.history) / 2
+	}
+	loopLen := len(c.history) - crossfadeLen
+
+	var v int
+	if c.repeatPos < crossfadeLen {
+		// Fading in the loop start while fading out the continuation of the previous loop (or the last received
+		// sample for the first loop).
+		var prev int
+		if c.repeatedCount < loopLen {
+			prev = int(c.history[len(c.history)-1])
+		} else {
+			prev = int(c.history[loopLen+c.repeatPos])
+		}
+		v = (int(c.history[c.repeatPos])*c.repeatPos + prev*(crossfadeLen-c.repeatPos)) / crossfadeLen
+	} else {
+		v = int(c.history[c.repeatPos])
+	}
+	c.repeatPos = (c.repeatPos + 1) % loopLen
+
+	fadeOutAfter := getAudioSampleCount(audioPLCFadeOutAfter)
+	fadeOutLen := getAudioSampleCount(audioPLCFadeOutLength)
+	if c.repeatedCount >= fadeOutAfter+fadeOutLen {
+		v = 0
+	} else if c.repeatedCount > fadeOutAfter {
+		v = v * (fadeOutAfter + fadeOutLen - c.repeatedCount) / fadeOutLen
+	}
+	c.repeatedCount++
+	return int16(v)
+}
+
+// Returns sampleCount samples of concealment audio in a buffer from audioBufPool.
+func (c *audioConcealer) conceal(sampleCount int) []byte {
+	res := audioBufPool.get(sampleCount * audioSampleBytes)
+	if c.mode == audioPLCModeSilence {
+		for i := range res {
+			res[i] = 0
+		}
+		return res
+	}
+
+	if !c.concealing {
+		c.concealing = true
+		c.repeatPos = 0
+		c.repeatedCount = 0
+	}
+	for i := 0; i < sampleCount; i++ {
+		v := c.getRepeatedSample()
+		res[i*2] = byte(v)
+		res[i*2+1] = byte(v >> 8)
+	}
+	return res
+}
+
+// Processes the received audio. If it follows concealment audio, then it's crossfaded in place with the
+// continuation of the concealment audio.
+func (c *audioConcealer) process(d []byte) []byte {
+	if c.concealing {
+		c.concealing = false
+
+		crossfadeLen := getAudioSampleCount(audioPLCCrossfadeLength)
+		if crossfadeLen > len(d)/audioSampleBytes {
+			crossfadeLen = len(d) / audioSampleBytes
+		}
+		for i := 0; i < crossfadeLen; i++ {
+			received := int(int16(uint16(d[i*2]) | uint16(d[i*2+1])<<8))
+			v := (received*i + int(c.getRepeatedSample())*(crossfadeLen-i)) / crossfadeLen
+			d[i*2] = byte(v)
+			d[i*2+1] = byte(v >> 8)
+		}
+	}
+
+	historyLen := getAudioSampleCount(audioPLCHistoryLength)
+	for i := 0; i+1 < len(d); i += 2 {
+		c.history = append(c.history, int16(uint16(d[i])|uint16(d[i+1])<<8))
+	}
+	if len(c.history) > historyLen {
+		c.history = append(c.history[:0], c.history[len(c.history)-historyLen:]...)
+	}
+	return d
+}
+
+func (c *audioConcealer) init(mode audioPLCMode) {
+	c.mode = mode
+	c.history = nil
+	c.concealing = false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The capabilities of the radio, parsed from the 0xa8 packet which is sent by the radio after the
+// login.
+type radioCapabilities struct {
+	id         [16]byte // Used in the serial and audio stream request.
+	name       string   // The model name, like "IC-705".
+	audioName  string   // The name of the radio's audio device, like "ICOM_VAUDIO".
+	civAddress byte     // 0 if the radio did not send it.
+	rxCodecs   uint16   // Bitmask of the supported RX audio codecs.
+	txCodecs   uint16   // Bitmask of the supported TX audio codecs.
+	baudRate   uint32   // The baud rate of the radio's serial port.
+}
+
+// Parses the 168 bytes long 0xa8 packet. See controlStream.handleRead() for an example.
+func parseRadioCapabilities(r []byte) (c radioCapabilities) {
+	copy(c.id[:], r[66:82])
+	c.name = parseNullTerminatedString(r[82:114])
+	c.audioName = parseNullTerminatedString(r[114:146])
+	if r[148] != 0xff {
+		c.civAddress = r[148]
+	}
+	c.rxCodecs = binary.BigEndian.Uint16(r[149:151])
+	c.txCodecs = binary.BigEndian.Uint16(r[151:153])
+	c.baudRate = binary.BigEndian.Uint32(r[156:160])
+	return
+}
+
+// Returns an error if the radio does not support the audio codec. The codec masks are not checked if the
+// radio did not send them.
+func (c *radioCapabilities) checkAudioCodec(codec audioCodec) error {
+	if c.rxCodecs != 0 && c.rxCodecs&uint16(codec.code) == 0 {
+		return fmt.Errorf("the radio does not support the %s audio codec for rx (supported codecs: 0x%.4x)",
+			codec.name, c.rxCodecs)
+	}
+	if c.txCodecs != 0 && c.txCodecs&uint16(codec.code) == 0 {
+		return fmt.Errorf("the radio does not support the %s audio codec for tx (supported codecs: 0x%.4x)",
+			codec.name, c.txCodecs)
+	}
+	return nil
+}
+
+// Returns the model name padded with zeros to the length of the name field in the serial and audio stream
+// request packet.
+func (c *radioCapabilities) getNameForRequest() (res [32]byte) {
+	name := c.name
+	if name == "" {
+		name = "IC-705"
+	}
+	copy(res[:], name)
+	return
+}
+
+func (c radioCapabilities) String() string {
+	civAddress := "unknown"
+	if c.civAddress != 0 {
+		civAddress = fmt.Sprintf("0x%.2x", c.civAddress)
+	}
+	return fmt.Sprintf("%s, CI-V address: %s, audio device: %s, rx codecs: 0x%.4x, tx codecs: 0x%.4x, baud rate: %d",
+		c.name, civAddress, c.audioName, c.rxCodecs, c.txCodecs, c.baudRate)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The WAV header is updated with the current data length in this interval, so the file is playable even
+// if kappanhang is killed while recording.
+const recorderHeaderUpdateInterval = 5 * time.Second
+
+// Received and transmitted audio frames are queued for writing to the file in this channel.
+const recorderFrameChanLength = 50
+
+// TX audio is buffered until the matching RX audio arrives, but at most this much.
+const recorderMaxTxBufferLength = time.Second
+
+// The RIFF chunk size is 32 bits, so a new file is started before the file reaches this size.
+const recorderMaxFileSize = 1 << 32
+
+type recorderFrame struct {
+	data []byte
+	tx   bool
+}
+
+// Writes the received audio (and optionally the transmitted audio) to WAV files. If the transmitted audio
+// is also recorded, then the file is stereo with the RX audio in the left and the TX audio in the right
+// channel. The RX audio stream is continuous, so it drives the timing of the file.
+type recorderStruct struct {
+	sess *session
+
+	mutex     sync.Mutex
+	frameChan chan recorderFrame
+
+	file         *os.File
+	writer       *bufio.Writer
+	channels     int
+	headerLength int
+	dataLength   int64
+	startedAt    time.Time
+	txBuf        bytes.Buffer
+
+	deinitNeededChan   chan bool
+	deinitFinishedChan chan bool
+}
+
+func (s *recorderStruct) isRecording() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.frameChan != nil
+}
+
+func (s *recorderStruct) queueFrame(d []byte, tx bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.frameChan == nil {
+		return
+	}
+	f := recorderFrame{data: audioBufPool.get(len(d)), tx: tx}
+	copy(f.data, d)
+
+	// Non-blocking send, the audio path should not wait for the disk.
+	select {
+	case s.frameChan <- f:
+	default:
+		log.Error(s.sess.logPrefix(), "recorder can't keep up, dropping audio")
+		audioBufPool.put(f.data)
+	}
+}
+
+// Queues 48kHz, s16le, mono RX audio for writing. The data is copied.
+func (s *recorderStruct) writeRx(d []byte) {
+	s.queueFrame(d, false)
+}
+
+// Queues 48kHz, s16le, mono TX audio for writing. The data is copied.
+func (s *recorderStruct) writeTx(d []byte) {
+	if !s.sess.conf.wavRecordTx {
+		return
+	}
+	s.queueFrame(d, true)
+}
+
+// Returns the radio's name, or the session name if the radio did not tell its name yet.
+func (s *recorderStruct) getRadioName() string {
+	if s.sess.audio.devName != "" {
+		return s.sess.audio.devName
+	}
+	return s.sess.conf.name
+}
+
+// Returns the metadata stored in the LIST INFO chunk of the file.
+func (s *recorderStruct) getMetadata() (title, comment string) {
+	radioName := s.getRadioName()
+
+	s.sess.civControl.state.mutex.Lock()
+	freq := s.sess.civControl.state.freq
+	mode := civOperatingModes[s.sess.civControl.state.operatingModeIdx].name
+	if s.sess.civControl.state.dataMode {
+		mode += "-D"
+	}
+	s.sess.civControl.state.mutex.Unlock()
+
+	title = fmt.Sprint(radioName, " ", s.startedAt.Format("2006-01-02 15:04:05"))
+	comment = fmt.Sprintf("radio=%s freq=%d mode=%s start=%s", radioName, freq, mode,
+		s.startedAt.UTC().Format(time.RFC3339))
+	return
+}
+
+// Returns a LIST chunk containing the given id, value pairs.
+func (s *recorderStruct) getInfoChunk(fields ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("INFO")
+	for i := 0; i+1 < len(fields); i += 2 {
+		v := fields[i+1] + "\x00"
+		b.WriteString(fields[i])
+		_ = binary.Write(&b, binary.LittleEndian, uint32(len(v)))
+		b.WriteString(v)
+		if len(v)%2 != 0 { // Chunks are word aligned, the padding is not included in the chunk size.
+			b.WriteByte(0)
+		}
+	}
+	res := []byte("LIST")
+	res = append(res, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(res[4:], uint32(b.Len()))
+	return append(res, b.Bytes()...)
+}
+
+func (s *recorderStruct) openFile() (err error) {
+	s.startedAt = time.Now()
+	basePath := filepath.Join(s.sess.conf.wavDir, fmt.Sprint("kappanhang-", s.getRadioName(), "-",
+		s.startedAt.Format("20060102-150405")))
+	path := basePath + ".wav"
+	// If the file was rotated in the same second, then a counter is added to the file name.
+	for i := 1; ; i++ {
+		s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			break
+		}
+		path = fmt.Sprint(basePath, "-", i, ".wav")
+	}
+	if err != nil {
+		s.file = nil
+		return
+	}
+	s.writer = bufio.NewWriter(s.file)
+	s.dataLength = 0
+	s.txBuf.Reset()
+
+	title, comment := s.getMetadata()
+	info := s.getInfoChunk("INAM", title, "ICMT", comment, "ICRD", s.startedAt.Format("2006-01-02"),
+		"ISFT", "kappanhang")
+
+	blockAlign := s.channels * audioSampleBytes
+	hdr := []byte("RIFF\x00\x00\x00\x00WAVEfmt ")
+	hdr = append(hdr, make([]byte, 20)...)
+	binary.LittleEndian.PutUint32(hdr[16:], 16)
+	binary.LittleEndian.PutUint16(hdr[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(hdr[22:], uint16(s.channels))
+	binary.LittleEndian.PutUint32(hdr[24:], audioSampleRate)
+	binary.LittleEndian.PutUint32(hdr[28:], uint32(audioSampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(hdr[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(hdr[34:], audioSampleBytes*8)
+	hdr = append(hdr, info...)
+	hdr = append(hdr, []byte("data\x00\x00\x00\x00")...)
+	if _, err = s.writer.Write(hdr); err != nil {
+		s.file.Close()
+		s.file = nil
+		return
+	}
+	s.headerLength = len(hdr)
+
+	log.Print(s.sess.logPrefix(), "recording to ", path, " (", comment, ")")
+	return nil
+}
+
+// Writes the current data length to the RIFF and data chunk headers.
+func (s *recorderStruct) updateHeader() error {
+	if s.file == nil {
+		return nil
+	}
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(int64(s.headerLength-8)+s.dataLength))
+	if _, err := s.file.WriteAt(b, 4); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(b, uint32(s.dataLength))
+	_, err := s.file.WriteAt(b, int64(s.headerLength-4))
+	return err
+}
+
+func (s *recorderStruct) closeFile() {
+	if s.file == nil {
+		return
+	}
+	if err := s.updateHeader(); err != nil {
+		log.Error(s.sess.logPrefix(), "can't update wav header: ", err)
+	}
+	if err := s.file.Close(); err != nil {
+		log.Error(s.sess.logPrefix(), "can't close wav file: ", err)
+	}
+	s.file = nil
+	log.Print(s.sess.logPrefix(), "recording stopped after ", time.Since(s.startedAt).Round(time.Second))
+}
+
+func (s *recorderStruct) rotate() error {
+	s.closeFile()
+	return s.openFile()
+}
+
+func (s *recorderStruct) rotateIfNeeded() error {
+	c := s.sess.conf
+	if (c.wavMaxSize == 0 || s.dataLength < c.wavMaxSize) &&
+		(c.wavMaxDuration == 0 || time.Since(s.startedAt) < c.wavMaxDuration) {
+		return nil
+	}
+	return s.rotate()
+}
+
+func (s *recorderStruct) writeFrame(f recorderFrame) error {
+	defer audioBufPool.put(f.data)
+
+	if s.file == nil { // Recording stopped because of an error.
+		return nil
+	}
+
+	if f.tx {
+		s.txBuf.Write(f.data)
+		maxTxBufLength := int(audioSampleRate * audioSampleBytes * recorderMaxTxBufferLength / time.Second)
+		if s.txBuf.Len() > maxTxBufLength {
+			s.txBuf.Next(s.txBuf.Len() - maxTxBufLength)
+		}
+		return nil
+	}
+
+	if int64(s.headerLength)+s.dataLength+int64(len(f.data)*s.channels) >= recorderMaxFileSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if s.channels == 1 {
+		if _, err := s.writer.Write(f.data); err != nil {
+			return err
+		}
+		s.dataLength += int64(len(f.data))
+	} else {
+		var txSample [audioSampleBytes]byte
+		for i := 0; i+audioSampleBytes <= len(f.data); i += audioSampleBytes {
+			if _, err := s.writer.Write(f.data[i : i+audioSampleBytes]); err != nil {
+				return err
+			}
+			if s.txBuf.Len() >= audioSampleBytes {
+				_, _ = s.txBuf.Read(txSample[:])
+			} else {
+				txSample = [audioSampleBytes]byte{}
+			}
+			if _, err := s.writer.Write(txSample[:]); err != nil {
+				return err
+			}
+			s.dataLength += 2 * audioSampleBytes
+		}
+	}
+	return s.rotateIfNeeded()
+}
+
+func (s *recorderStruct) loop() {
+	headerUpdateTicker := time.NewTicker(recorderHeaderUpdateInterval)
+	defer headerUpdateTicker.Stop()
+
+	for {
+		select {
+		case f := <-s.frameChan:
+			if err := s.writeFrame(f); err != nil {
+				log.Error(s.sess.logPrefix(), "can't write wav file: ", err)
+				s.closeFile()
+				s.sess.statusLog.reportWavRecording(false)
+			}
+		case <-headerUpdateTicker.C:
+			if err := s.updateHeader(); err != nil {
+				log.Error(s.sess.logPrefix(), "can't update wav header: ", err)
+			}
+		case <-s.deinitNeededChan:
+			s.closeFile()
+			s.deinitFinishedChan <- true
+			return
+		}
+	}
+}
+
+func (s *recorderStruct) start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.frameChan != nil {
+		return errors.New("already recording")
+	}
+
+	s.channels = 1
+	if s.sess.conf.wavRecordTx {
+		s.channels = 2
+	}
+	if err := s.openFile(); err != nil {
+		return err
+	}
+	s.sess.statusLog.reportWavRecording(true)
+
+	s.frameChan = make(chan recorderFrame, recorderFrameChanLength)
+	s.deinitNeededChan = make(chan bool)
+	s.deinitFinishedChan = make(chan bool)
+	go s.loop()
+	return nil
+}
+
+func (s *recorderStruct) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.frameChan == nil {
+		return
+	}
+
+	s.deinitNeededChan <- true
+	<-s.deinitFinishedChan
+
+	// Returning the remaining queued frames to the pool.
+	for len(s.frameChan) > 0 {
+		audioBufPool.put((<-s.frameChan).data)
+	}
+	s.frameChan = nil
+	s.sess.statusLog.reportWavRecording(false)
+}
+
+func (s *recorderStruct) toggle() error {
+	if s.isRecording() {
+		s.stop()
+		return nil
+	}
+	return s.start()
+}
+
+func (s *recorderStruct) deinit() {
+	s.stop()
+}
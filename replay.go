@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Replay feeds the packets of a capture file (written with --capture, or by Wireshark or tcpdump) to the
+// packet handlers of a session without connecting to a radio. The packets received from the radio are
+// replayed with their original timing or as fast as possible. Packets sent by the handlers (retransmit
+// requests, ping replies etc.) go to a local socket which only counts them. At the end the decoded state
+// (frequency, mode, S meter, lost packets etc.) is logged and checked against the expected values, so a
+// capture from a bug report can be turned into a reproducible regression case.
+
+// Time to wait after the last packet, so the seqbufs can return their entries or time out.
+const replayDrainDuration = 500 * time.Millisecond
+
+type replayPacket struct {
+	at  time.Time
+	src *net.UDPAddr
+	dst *net.UDPAddr
+	d   []byte
+}
+
+type pcapngInterface struct {
+	linkType      uint16
+	tsUnitsPerSec uint64
+}
+
+const (
+	pcapngLinkTypeEthernet  = 1
+	pcapngLinkTypeLinuxSLL  = 113
+	pcapngLinkTypeLinuxSLL2 = 276
+)
+
+// Returns the timestamp unit from the if_tsresol option of an interface description block.
+func getPcapngTsUnitsPerSec(options []byte, byteOrder binary.ByteOrder) uint64 {
+	for len(options) >= 4 {
+		code := byteOrder.Uint16(options[0:2])
+		l := int(byteOrder.Uint16(options[2:4]))
+		if code == 0 || 4+l > len(options) {
+			break
+		}
+		if code == 9 && l >= 1 { // if_tsresol
+			v := options[4]
+			if v&0x80 != 0 {
+				return 1 << (v & 0x7f)
+			}
+			res := uint64(1)
+			for i := byte(0); i < v; i++ {
+				res *= 10
+			}
+			return res
+		}
+		options = options[4+l+(4-l%4)%4:]
+	}
+	return 1000000
+}
+
+// Returns the UDP addresses and payload from a captured frame. ok is false if the frame does not contain
+// an unfragmented UDP datagram.
+func getUDPDatagram(linkType uint16, d []byte) (src, dst *net.UDPAddr, payload []byte, ok bool) {
+	switch linkType {
+	case pcapngLinkTypeRaw:
+	case pcapngLinkTypeEthernet:
+		if len(d) < 14 {
+			return
+		}
+		etherType := binary.BigEndian.Uint16(d[12:14])
+		d = d[14:]
+		if etherType == 0x8100 && len(d) >= 4 { // VLAN tag.
+			d = d[4:]
+		}
+	case pcapngLinkTypeLinuxSLL:
+		if len(d) < 16 {
+			return
+		}
+		d = d[16:]
+	case pcapngLinkTypeLinuxSLL2:
+		if len(d) < 20 {
+			return
+		}
+		d = d[20:]
+	default:
+		return
+	}
+
+	var udp []byte
+	var srcIP, dstIP net.IP
+	switch {
+	case len(d) >= 20 && d[0]>>4 == 4:
+		headerLen := int(d[0]&0x0f) * 4
+		totalLen := int(binary.BigEndian.Uint16(d[2:4]))
+		fragmented := binary.BigEndian.Uint16(d[6:8])&0x3fff != 0
+		if d[9] != 17 || fragmented || headerLen < 20 || totalLen < headerLen || totalLen > len(d) {
+			return
+		}
+		srcIP = net.IP(d[12:16])
+		dstIP = net.IP(d[16:20])
+		udp = d[headerLen:totalLen]
+	case len(d) >= 40 && d[0]>>4 == 6:
+		payloadLen := int(binary.BigEndian.Uint16(d[4:6]))
+		if d[6] != 17 || 40+payloadLen > len(d) {
+			return
+		}
+		srcIP = net.IP(d[8:24])
+		dstIP = net.IP(d[24:40])
+		udp = d[40 : 40+payloadLen]
+	default:
+		return
+	}
+
+	if len(udp) < 8 {
+		return
+	}
+	udpLen := int(binary.BigEndian.Uint16(udp[4:6]))
+	if udpLen < 8 || udpLen > len(udp) {
+		return
+	}
+	src = &net.UDPAddr{IP: srcIP, Port: int(binary.BigEndian.Uint16(udp[0:2]))}
+	dst = &net.UDPAddr{IP: dstIP, Port: int(binary.BigEndian.Uint16(udp[2:4]))}
+	return src, dst, udp[8:udpLen], true
+}
+
+// Reads the UDP datagrams from a pcapng file. See captureStruct.writeHeader() for the block formats.
+func readReplayPackets(path string) (res []replayPacket, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 12 || !bytes.Equal(b[0:4], []byte{0x0a, 0x0d, 0x0d, 0x0a}) {
+		return nil, errors.New("not a pcapng file")
+	}
+
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	var ifaces []pcapngInterface
+	for len(b) >= 12 {
+		if bytes.Equal(b[0:4], []byte{0x0a, 0x0d, 0x0d, 0x0a}) { // Section header block.
+			switch {
+			case binary.LittleEndian.Uint32(b[8:12]) == 0x1a2b3c4d:
+				byteOrder = binary.LittleEndian
+			case binary.BigEndian.Uint32(b[8:12]) == 0x1a2b3c4d:
+				byteOrder = binary.BigEndian
+			default:
+				return nil, errors.New("invalid pcapng byte order magic")
+			}
+			ifaces = nil
+		}
+
+		blockType := byteOrder.Uint32(b[0:4])
+		l := int(byteOrder.Uint32(b[4:8]))
+		if l < 12 || l%4 != 0 || l > len(b) {
+			return nil, fmt.Errorf("invalid pcapng block length %d", l)
+		}
+		body := b[8 : l-4]
+		b = b[l:]
+
+		switch blockType {
+		case 1: // Interface description block.
+			if len(body) < 8 {
+				return nil, errors.New("invalid pcapng interface description block")
+			}
+			ifaces = append(ifaces, pcapngInterface{
+				linkType:      byteOrder.Uint16(body[0:2]),
+				tsUnitsPerSec: getPcapngTsUnitsPerSec(body[8:], byteOrder),
+			})
+		case 6: // Enhanced packet block.
+			if len(body) < 20 {
+				return nil, errors.New("invalid pcapng enhanced packet block")
+			}
+			ifaceID := int(byteOrder.Uint32(body[0:4]))
+			ts := uint64(byteOrder.Uint32(body[4:8]))<<32 | uint64(byteOrder.Uint32(body[8:12]))
+			capLen := int(byteOrder.Uint32(body[12:16]))
+			if ifaceID >= len(ifaces) || 20+capLen > len(body) {
+				return nil, errors.New("invalid pcapng enhanced packet block")
+			}
+			iface := ifaces[ifaceID]
+			src, dst, d, ok := getUDPDatagram(iface.linkType, body[20:20+capLen])
+			if !ok {
+				continue
+			}
+			secs := ts / iface.tsUnitsPerSec
+			nsecs := (ts % iface.tsUnitsPerSec) * uint64(time.Second) / iface.tsUnitsPerSec
+			res = append(res, replayPacket{
+				at:  time.Unix(int64(secs), int64(nsecs)),
+				src: src,
+				dst: dst,
+				d:   d,
+			})
+		}
+	}
+	return res, nil
+}
+
+type replayExpectation struct {
+	name  string
+	value string
+}
+
+// Parses the comma separated list of name=value pairs given with --replay-expect.
+func parseReplayExpectations(s string) (res []replayExpectation, err error) {
+	if s == "" {
+		return nil, nil
+	}
+	for _, e := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(e), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid expectation %q, use name=value", e)
+		}
+		res = append(res, replayExpectation{name: kv[0], value: kv[1]})
+	}
+	return
+}
+
+type replayer struct {
+	sess    session
+	control streamCommon
+	serial  serialStream
+	audio   audioStream
+
+	// The addresses of the radio's streams, packets from these addresses are replayed.
+	radioAddrs map[string]bool
+
+	// Packets sent by the streams are received on this socket.
+	sink                   *net.UDPConn
+	sinkReaderFinishedChan chan bool
+	lastRetransmitRequest  []byte
+	retransmitRequests     int // The number of packets requested to be retransmitted.
+
+	audioDrainDeinitNeededChan   chan bool
+	audioDrainDeinitFinishedChan chan bool
+	receivedAudio                time.Duration
+}
+
+// Finds the addresses of the radio's streams. Only the radio sends pkt4 packets, and the radio's pkt7
+// packets start with 0x00 while ours start with 0x15. The audio format is taken from the stream request,
+// as it is needed before the audio packets arrive.
+func (r *replayer) scan(packets []replayPacket) {
+	r.radioAddrs = make(map[string]bool)
+	for _, p := range packets {
+		if (len(p.d) == 16 && bytes.Equal(p.d[:6], []byte{0x10, 0x00, 0x00, 0x00, 0x04, 0x00})) ||
+			(r.control.pkt7.isPkt7(p.d) && p.d[0] == 0x00) {
+			r.radioAddrs[p.src.String()] = true
+		}
+	}
+
+	for _, p := range packets {
+		if !r.radioAddrs[p.src.String()] && p.dst.Port == int(r.sess.conf.controlStreamPort) &&
+			len(p.d) == 144 && p.d[0] == 0x90 {
+			format, err := parseStreamRequestAudioFormat(p.d)
+			if err != nil {
+				log.Error("invalid stream request: ", err)
+				continue
+			}
+			if format != r.sess.conf.audioFormat {
+				log.Print("using the audio format from the stream request: ", format)
+				r.sess.conf.audioFormat = format
+			}
+		}
+	}
+}
+
+func (r *replayer) sinkReader() {
+	b := make([]byte, 1500)
+	for {
+		n, err := r.sink.Read(b)
+		if err != nil {
+			r.sinkReaderFinishedChan <- true
+			return
+		}
+		d := b[:n]
+		if len(d) < 16 || !bytes.Equal(d[1:6], []byte{0x00, 0x00, 0x00, 0x01, 0x00}) {
+			continue
+		}
+		// Retransmit requests are sent twice, the duplicate is not counted.
+		if bytes.Equal(d, r.lastRetransmitRequest) {
+			r.lastRetransmitRequest = nil
+			continue
+		}
+		r.lastRetransmitRequest = append([]byte{}, d...)
+
+		if d[0] == 0x18 {
+			for d = d[16:]; len(d) >= 4; d = d[4:] {
+				rr := seqNumRange{seqNum(binary.LittleEndian.Uint16(d[0:2])), seqNum(binary.LittleEndian.Uint16(d[2:4]))}
+				r.retransmitRequests += rr.getDiff(0xffff) + 1
+			}
+		} else {
+			r.retransmitRequests++
+		}
+	}
+}
+
+func (r *replayer) audioDrainLoop() {
+	for {
+		select {
+		case d := <-r.sess.audio.play:
+			r.receivedAudio += time.Duration(len(d)/audioSampleBytes) * time.Second / audioSampleRate
+			audioBufPool.put(d)
+		case <-r.audioDrainDeinitNeededChan:
+			r.audioDrainDeinitFinishedChan <- true
+			return
+		}
+	}
+}
+
+func (r *replayer) initStream(s *streamCommon, name string) (err error) {
+	s.sess = &r.sess
+	s.name = name
+	s.kind = name
+	s.stats = r.sess.netstat.getStream(name)
+	if s.conn, err = net.DialUDP("udp", nil, r.sink.LocalAddr().(*net.UDPAddr)); err != nil {
+		return err
+	}
+	s.readChan = make(chan []byte)
+	return nil
+}
+
+func (r *replayer) initSerialStream() error {
+	s := &r.serial
+	s.sess = &r.sess
+	if err := r.initStream(&s.common, "serial"); err != nil {
+		return err
+	}
+	s.common.pkt0.init(&s.common)
+
+	s.rxSeqBufEntryChan = make(chan seqBufEntry)
+	s.rxSeqBuf.init(&r.sess, serialRxSeqBufLength, 0xffff, 0, s.rxSeqBufEntryChan, s.common.requestRetransmit)
+
+	s.deinitNeededChan = make(chan bool)
+	s.deinitFinishedChan = make(chan bool)
+
+	s.readFromSerialPort.frameTimeout = time.NewTimer(0)
+	<-s.readFromSerialPort.frameTimeout.C
+
+	go s.loop()
+	return nil
+}
+
+func (r *replayer) initAudioStream() error {
+	s := &r.audio
+	s.sess = &r.sess
+	s.converter = audioConverter{format: r.sess.conf.audioFormat}
+	s.concealer.init(r.sess.conf.audioPLCMode)
+	if err := r.initStream(&s.common, "audio"); err != nil {
+		return err
+	}
+
+	s.rxSeqBufEntryChan = make(chan seqBufEntry)
+	s.jitterBuffer.init(r.sess.conf.audioJitterBufferMin, r.sess.conf.audioJitterBufferMax)
+	s.rxSeqBuf.init(&r.sess, s.jitterBuffer.getDepth(), 0xffff, 0, s.rxSeqBufEntryChan, s.requestRetransmit)
+
+	s.timeoutTimer = time.NewTimer(audioTimeoutDuration)
+
+	r.sess.audio.play = make(chan []byte)
+	r.audioDrainDeinitNeededChan = make(chan bool)
+	r.audioDrainDeinitFinishedChan = make(chan bool)
+	go r.audioDrainLoop()
+
+	s.deinitNeededChan = make(chan bool)
+	s.deinitFinishedChan = make(chan bool)
+	go s.loop()
+	return nil
+}
+
+// Feeds a packet received from the radio to the handlers of its stream.
+func (r *replayer) handlePacket(p replayPacket) {
+	var s *streamCommon
+	switch p.src.Port {
+	case int(r.sess.conf.controlStreamPort):
+		s = &r.control
+	case int(r.sess.conf.serialStreamPort):
+		s = &r.serial.common
+	case int(r.sess.conf.audioStreamPort):
+		s = &r.audio.common
+	default:
+		return
+	}
+
+	if s.remoteSID == 0 && len(p.d) >= 16 {
+		s.remoteSID = binary.BigEndian.Uint32(p.d[8:12])
+		s.localSID = binary.BigEndian.Uint32(p.d[12:16])
+	}
+
+	// The handlers expect pooled buffers like the ones streamCommon.read() returns.
+	d := pktBufPool.get(len(p.d))
+	copy(d, p.d)
+
+	s.stats.add(0, len(d))
+	if !s.handleCommonPacket(d) {
+		pktBufPool.put(d)
+		return
+	}
+
+	if s == &r.control {
+		if len(d) == 168 && bytes.Equal(d[:6], []byte{0xa8, 0x00, 0x00, 0x00, 0x00, 0x00}) && r.sess.radioCaps == nil {
+			caps := parseRadioCapabilities(d)
+			log.Print("radio: ", caps)
+			r.sess.radioCaps = &caps
+		}
+		pktBufPool.put(d)
+		return
+	}
+	s.readChan <- d
+}
+
+// Returns the decoded state which can be checked with --replay-expect.
+func (r *replayer) getResults() []replayExpectation {
+	civ := &r.sess.civControl
+	civ.state.mutex.Lock()
+	defer civ.state.mutex.Unlock()
+
+	getModeName := func(idx int) string {
+		if idx < 0 || idx >= len(civOperatingModes) {
+			return "?"
+		}
+		return civOperatingModes[idx].name
+	}
+	getFilterName := func(idx int) string {
+		if idx < 0 || idx >= len(civFilters) {
+			return "?"
+		}
+		return civFilters[idx].name
+	}
+
+	r.sess.netstat.mutex.Lock()
+	lost := r.sess.netstat.lostPkts
+	concealed := r.sess.netstat.concealed
+	r.sess.netstat.mutex.Unlock()
+
+	r.sess.statusLog.mutex.Lock()
+	sValue := r.sess.statusLog.data.s
+	r.sess.statusLog.mutex.Unlock()
+
+	radio := "?"
+	if r.sess.radioCaps != nil {
+		radio = r.sess.radioCaps.name
+	}
+
+	return []replayExpectation{
+		{name: "radio", value: radio},
+		{name: "freq", value: fmt.Sprint(civ.state.freq)},
+		{name: "sub-freq", value: fmt.Sprint(civ.state.subFreq)},
+		{name: "mode", value: getModeName(civ.state.operatingModeIdx)},
+		{name: "data-mode", value: fmt.Sprint(civ.state.dataMode)},
+		{name: "filter", value: getFilterName(civ.state.filterIdx)},
+		{name: "s", value: sValue},
+		{name: "ptt", value: fmt.Sprint(civ.state.ptt)},
+		{name: "lost", value: fmt.Sprint(lost)},
+		{name: "retransmit-requests", value: fmt.Sprint(r.retransmitRequests)},
+		{name: "concealed-ms", value: fmt.Sprint(concealed.Milliseconds())},
+		{name: "audio-ms", value: fmt.Sprint(r.receivedAudio.Milliseconds())},
+		{name: "jitter-buffer-ms", value: fmt.Sprint(r.audio.jitterBuffer.getDepth().Milliseconds())},
+	}
+}
+
+func (r *replayer) init(conf sessionConfig) (err error) {
+	r.sess.init(conf)
+	r.sess.statusLog.data = &statusLogData{
+		s:         "S0",
+		startTime: time.Now(),
+	}
+
+	if r.sink, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}); err != nil {
+		return err
+	}
+	r.sinkReaderFinishedChan = make(chan bool)
+	go r.sinkReader()
+
+	if err := r.initStream(&r.control, "control"); err != nil {
+		return err
+	}
+	if err := r.initSerialStream(); err != nil {
+		return err
+	}
+	return r.initAudioStream()
+}
+
+func (r *replayer) deinit() {
+	if r.audio.deinitNeededChan != nil {
+		r.audio.deinit()
+	}
+	if r.audioDrainDeinitNeededChan != nil {
+		r.audioDrainDeinitNeededChan <- true
+		<-r.audioDrainDeinitFinishedChan
+	}
+	if r.serial.deinitNeededChan != nil {
+		r.serial.deinit()
+	}
+	r.control.deinit()
+
+	if r.sink != nil {
+		r.sink.Close()
+		<-r.sinkReaderFinishedChan
+	}
+}
+
+// Replays the packets of the capture file and returns the decoded state.
+func replayCapture(conf sessionConfig, path string) ([]replayExpectation, error) {
+	packets, err := readReplayPackets(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read capture file: %w", err)
+	}
+
+	r := &replayer{}
+	r.sess.conf = conf
+	r.scan(packets)
+	if len(r.radioAddrs) == 0 {
+		return nil, errors.New("no packets from the radio found in " + path)
+	}
+
+	if err := r.init(r.sess.conf); err != nil {
+		r.deinit()
+		return nil, err
+	}
+
+	osSignal := make(chan os.Signal, 1)
+	signal.Notify(osSignal, os.Interrupt, syscall.SIGTERM)
+
+	log.Print("replaying ", len(packets), " packets from ", path)
+	var replayed int
+	startedAt := time.Now()
+	for _, p := range packets {
+		if !r.radioAddrs[p.src.String()] {
+			continue
+		}
+
+		if !replayFast {
+			select {
+			case <-time.After(time.Until(startedAt.Add(p.at.Sub(packets[0].at)))):
+			case <-osSignal:
+				r.deinit()
+				return nil, errors.New("sigterm received")
+			}
+		}
+		r.handlePacket(p)
+		replayed++
+	}
+	time.Sleep(replayDrainDuration)
+	r.deinit()
+	log.Print("replayed ", replayed, " packets from the radio")
+	return r.getResults(), nil
+}
+
+func runReplay(conf sessionConfig, path string) (exitCode int) {
+	expectations, err := parseReplayExpectations(replayExpect)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	results, err := replayCapture(conf, path)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	for _, res := range results {
+		log.Print(res.name, "=", res.value)
+	}
+
+	for _, e := range expectations {
+		found := false
+		for _, res := range results {
+			if res.name != e.name {
+				continue
+			}
+			found = true
+			if res.value != e.value {
+				log.Error("expectation failed: ", e.name, "=", res.value, ", expected ", e.value)
+				exitCode = 1
+			}
+		}
+		if !found {
+			log.Error("unknown expectation: ", e.name)
+			exitCode = 1
+		}
+	}
+	return
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// The capture was written with --capture by a session connected to the simulator running with
+// --sim-loss 5, the simulator's ports were 51001-51003. Audio packets 67, 133 and 134 were removed from
+// it, so they are lost even after the retransmit requests.
+const replayTestFile = "testdata/replay-loss.pcapng"
+
+func TestReplay(t *testing.T) {
+	replayFast = true
+	defer func() { replayFast = false }()
+
+	conf := sessionConfig{
+		controlStreamPort:    51001,
+		serialStreamPort:     51002,
+		audioStreamPort:      51003,
+		audioJitterBufferMin: defaultAudioJitterBufferMin,
+		audioJitterBufferMax: defaultAudioJitterBufferMax,
+		audioPLCMode:         audioPLCModeSilence,
+	}
+	results, err := replayCapture(conf, replayTestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string]string)
+	for _, r := range results {
+		values[r.name] = r.value
+	}
+
+	expected := []replayExpectation{
+		{name: "radio", value: "IC-705"},
+		{name: "freq", value: "7074000"},
+		{name: "mode", value: "USB"},
+		{name: "lost", value: "3"},
+		{name: "concealed-ms", value: "60"},
+	}
+	for _, e := range expected {
+		if values[e.name] != e.value {
+			t.Error(e.name, " is ", values[e.name], ", expected ", e.value)
+		}
+	}
+
+	// The number of retransmit requests depends on the timing of the seqbuf, but all lost packets
+	// should be requested at least once.
+	retransmitRequests, err := strconv.Atoi(values["retransmit-requests"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retransmitRequests < 3 {
+		t.Error("retransmit-requests is ", retransmitRequests, ", expected at least 3")
+	}
+}
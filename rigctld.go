@@ -16,6 +16,7 @@ const (
 )
 
 type rigctldStruct struct {
+	sess     *session
 	listener net.Listener
 	client   net.Conn
 
@@ -26,8 +27,6 @@ type rigctldStruct struct {
 	deinitFinishedChan chan bool
 }
 
-var rigctld rigctldStruct
-
 func (s *rigctldStruct) disconnectClient() {
 	if s.client != nil {
 		s.client.Close()
@@ -131,10 +130,10 @@ func (s *rigctldStruct) processCmd(cmd string) (close bool, err error) {
 		err = s.sendReplyCode(rigctldNoError)
 		close = true
 	case cmd == "f":
-		civControl.state.mutex.Lock()
-		defer civControl.state.mutex.Unlock()
+		s.sess.civControl.state.mutex.Lock()
+		defer s.sess.civControl.state.mutex.Unlock()
 
-		err = s.send(civControl.state.freq, "\n")
+		err = s.send(s.sess.civControl.state.freq, "\n")
 	case cmdSplit[0] == "F":
 		var f float64
 		f, err = strconv.ParseFloat(cmdSplit[1], 0)
@@ -142,25 +141,25 @@ func (s *rigctldStruct) processCmd(cmd string) (close bool, err error) {
 			_ = s.sendReplyCode(rigctldInvalidParam)
 			return
 		}
-		err = civControl.setMainVFOFreq(uint(f))
+		err = s.sess.civControl.setMainVFOFreq(uint(f))
 		if err != nil {
 			_ = s.sendReplyCode(rigctldInvalidParam)
 			return
 		}
 		err = s.sendReplyCode(rigctldNoError)
 	case cmd == "m":
-		civControl.state.mutex.Lock()
-		defer civControl.state.mutex.Unlock()
+		s.sess.civControl.state.mutex.Lock()
+		defer s.sess.civControl.state.mutex.Unlock()
 
 		var mode string
-		if civControl.state.dataMode {
+		if s.sess.civControl.state.dataMode {
 			mode = "PKT"
 		}
-		mode += civOperatingModes[civControl.state.operatingModeIdx].name
+		mode += civOperatingModes[s.sess.civControl.state.operatingModeIdx].name
 
 		// This can be queried with a CIV command for accurate values by the way.
 		width := "3000"
-		switch civControl.state.filterIdx {
+		switch s.sess.civControl.state.filterIdx {
 		case 1:
 			width = "2400"
 		case 2:
@@ -200,11 +199,11 @@ func (s *rigctldStruct) processCmd(cmd string) (close bool, err error) {
 		} else if width <= 2400 {
 			filterCode = 1
 		}
-		err = civControl.setOperatingModeAndFilter(modeCode, filterCode)
+		err = s.sess.civControl.setOperatingModeAndFilter(modeCode, filterCode)
 		if err != nil {
 			_ = s.sendReplyCode(rigctldInvalidParam)
 		} else {
-			err = civControl.setDataMode(dataMode)
+			err = s.sess.civControl.setDataMode(dataMode)
 			if err != nil {
 				_ = s.sendReplyCode(rigctldInvalidParam)
 				return
@@ -212,26 +211,16 @@ func (s *rigctldStruct) processCmd(cmd string) (close bool, err error) {
 			_ = s.sendReplyCode(rigctldNoError)
 		}
 	case cmd == "t":
-		civControl.state.mutex.Lock()
-		defer civControl.state.mutex.Unlock()
+		s.sess.civControl.state.mutex.Lock()
+		defer s.sess.civControl.state.mutex.Unlock()
 
 		res := "0"
-		if civControl.state.ptt {
+		if s.sess.civControl.state.ptt {
 			res = "1"
 		}
 		err = s.send(res, "\n")
 	case cmdSplit[0] == "T":
-		if cmdSplit[1] != "0" {
-			if setDataModeOnTx {
-				if err := civControl.setDataMode(true); err != nil {
-					log.Error("can't enable data mode: ", err)
-				}
-			}
-
-			err = civControl.setPTT(true)
-		} else {
-			err = civControl.setPTT(false)
-		}
+		err = s.sess.civControl.setPTTWithDataMode(cmdSplit[1] != "0")
 		if err != nil {
 			_ = s.sendReplyCode(rigctldInvalidParam)
 		} else {
@@ -239,9 +228,9 @@ func (s *rigctldStruct) processCmd(cmd string) (close bool, err error) {
 		}
 	case cmdSplit[0] == "V":
 		if cmdSplit[1] == "VFOB" {
-			err = civControl.setVFO(1)
+			err = s.sess.civControl.setVFO(1)
 		} else {
-			err = civControl.setVFO(0)
+			err = s.sess.civControl.setVFO(0)
 		}
 		if err != nil {
 			_ = s.sendReplyCode(rigctldInvalidParam)
@@ -249,11 +238,11 @@ func (s *rigctldStruct) processCmd(cmd string) (close bool, err error) {
 			_ = s.sendReplyCode(rigctldNoError)
 		}
 	case cmd == "s":
-		civControl.state.mutex.Lock()
-		defer civControl.state.mutex.Unlock()
+		s.sess.civControl.state.mutex.Lock()
+		defer s.sess.civControl.state.mutex.Unlock()
 
 		res := "0"
-		if civControl.state.splitMode == splitModeOn {
+		if s.sess.civControl.state.splitMode == splitModeOn {
 			res = "1"
 		}
 		err = s.send(res, "\n")
@@ -261,7 +250,7 @@ func (s *rigctldStruct) processCmd(cmd string) (close bool, err error) {
 			_ = s.sendReplyCode(rigctldInvalidParam)
 			return
 		}
-		if civControl.state.vfoBActive {
+		if s.sess.civControl.state.vfoBActive {
 			res = "VFOA"
 		} else {
 			res = "VFOB"
@@ -269,9 +258,9 @@ func (s *rigctldStruct) processCmd(cmd string) (close bool, err error) {
 		err = s.send(res, "\n")
 	case cmdSplit[0] == "S":
 		if cmdSplit[1] == "1" {
-			err = civControl.setSplit(splitModeOn)
+			err = s.sess.civControl.setSplit(splitModeOn)
 		} else {
-			err = civControl.setSplit(splitModeOff)
+			err = s.sess.civControl.setSplit(splitModeOff)
 		}
 		if err != nil {
 			_ = s.sendReplyCode(rigctldInvalidParam)
@@ -279,10 +268,10 @@ func (s *rigctldStruct) processCmd(cmd string) (close bool, err error) {
 			_ = s.sendReplyCode(rigctldNoError)
 		}
 	case cmd == "i":
-		civControl.state.mutex.Lock()
-		defer civControl.state.mutex.Unlock()
+		s.sess.civControl.state.mutex.Lock()
+		defer s.sess.civControl.state.mutex.Unlock()
 
-		err = s.send(civControl.state.subFreq, "\n")
+		err = s.send(s.sess.civControl.state.subFreq, "\n")
 	case cmdSplit[0] == "I":
 		var f float64
 		f, err = strconv.ParseFloat(cmdSplit[1], 0)
@@ -290,25 +279,25 @@ func (s *rigctldStruct) processCmd(cmd string) (close bool, err error) {
 			_ = s.sendReplyCode(rigctldInvalidParam)
 			return
 		}
-		err = civControl.setSubVFOFreq(uint(f))
+		err = s.sess.civControl.setSubVFOFreq(uint(f))
 		if err != nil {
 			_ = s.sendReplyCode(rigctldInvalidParam)
 			return
 		}
 		err = s.sendReplyCode(rigctldNoError)
 	case cmd == "x":
-		civControl.state.mutex.Lock()
-		defer civControl.state.mutex.Unlock()
+		s.sess.civControl.state.mutex.Lock()
+		defer s.sess.civControl.state.mutex.Unlock()
 
 		var mode string
-		if civControl.state.subDataMode {
+		if s.sess.civControl.state.subDataMode {
 			mode = "PKT"
 		}
-		mode += civOperatingModes[civControl.state.subOperatingModeIdx].name
+		mode += civOperatingModes[s.sess.civControl.state.subOperatingModeIdx].name
 
 		// This can be queried with a CIV command for accurate values by the way.
 		width := "3000"
-		switch civControl.state.subFilterIdx {
+		switch s.sess.civControl.state.subFilterIdx {
 		case 1:
 			width = "2400"
 		case 2:
@@ -348,12 +337,77 @@ func (s *rigctldStruct) processCmd(cmd string) (close bool, err error) {
 		} else if width <= 2400 {
 			filterCode = 1
 		}
-		err = civControl.setSubVFOMode(modeCode, dataMode, filterCode)
+		err = s.sess.civControl.setSubVFOMode(modeCode, dataMode, filterCode)
+		if err != nil {
+			_ = s.sendReplyCode(rigctldInvalidParam)
+		} else {
+			_ = s.sendReplyCode(rigctldNoError)
+		}
+	case cmd == "\\get_wav_rec":
+		res := "0"
+		if s.sess.recorder.isRecording() {
+			res = "1"
+		}
+		err = s.send(res, "\n")
+	case cmdSplit[0] == "\\set_wav_rec":
+		if len(cmdSplit) < 2 {
+			_ = s.sendReplyCode(rigctldInvalidParam)
+			return
+		}
+		if cmdSplit[1] != "0" {
+			if !s.sess.recorder.isRecording() {
+				err = s.sess.recorder.start()
+			}
+		} else {
+			s.sess.recorder.stop()
+		}
 		if err != nil {
 			_ = s.sendReplyCode(rigctldInvalidParam)
 		} else {
 			_ = s.sendReplyCode(rigctldNoError)
 		}
+	case cmdSplit[0] == "\\send_voice_mem":
+		if len(cmdSplit) < 2 {
+			_ = s.sendReplyCode(rigctldInvalidParam)
+			return
+		}
+		var n int
+		n, err = strconv.Atoi(cmdSplit[1])
+		if err == nil {
+			err = s.sess.voiceKeyer.play(n)
+		}
+		if err != nil {
+			_ = s.sendReplyCode(rigctldInvalidParam)
+		} else {
+			_ = s.sendReplyCode(rigctldNoError)
+		}
+	case cmd == "\\stop_voice_mem":
+		s.sess.voiceKeyer.stop()
+		_ = s.sendReplyCode(rigctldNoError)
+	case cmdSplit[0] == "\\get_audio_level":
+		if len(cmdSplit) < 2 {
+			_ = s.sendReplyCode(rigctldInvalidParam)
+			return
+		}
+		var v float64
+		switch cmdSplit[1] {
+		case "RX_PEAK":
+			v = s.sess.rxAudioLevel.get().PeakDBFS
+		case "RX_RMS":
+			v = s.sess.rxAudioLevel.get().RMSDBFS
+		case "RX_CLIPS":
+			v = float64(s.sess.rxAudioLevel.get().Clips)
+		case "TX_PEAK":
+			v = s.sess.txAudioLevel.get().PeakDBFS
+		case "TX_RMS":
+			v = s.sess.txAudioLevel.get().RMSDBFS
+		case "TX_CLIPS":
+			v = float64(s.sess.txAudioLevel.get().Clips)
+		default:
+			_ = s.sendReplyCode(rigctldInvalidParam)
+			return false, fmt.Errorf("unknown level %s", cmdSplit[1])
+		}
+		err = s.send(strconv.FormatFloat(v, 'f', 1, 64), "\n")
 	case cmd == "v": // Ignore this command.
 		_ = s.sendReplyCode(rigctldUnsupportedCmd)
 		return
@@ -428,7 +482,7 @@ func (s *rigctldStruct) loop() {
 
 		if err != nil {
 			if err != io.EOF {
-				reportError(err)
+				s.sess.reportError(err)
 			}
 			<-s.deinitNeededChan
 			s.deinitFinishedChan <- true
@@ -448,13 +502,13 @@ func (s *rigctldStruct) initIfNeeded() (err error) {
 		return
 	}
 
-	s.listener, err = net.Listen("tcp", fmt.Sprint(":", rigctldPort))
+	s.listener, err = net.Listen("tcp", fmt.Sprint(":", s.sess.conf.rigctldPort))
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	log.Print("starting internal rigctld on tcp port ", rigctldPort)
+	log.Print(s.sess.logPrefix(), "starting internal rigctld on tcp port ", s.sess.conf.rigctldPort)
 
 	s.deinitNeededChan = make(chan bool)
 	s.deinitFinishedChan = make(chan bool)
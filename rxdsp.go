@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultRxBandpassFilters = "300-2700,300-2100,500-900"
+
+// Noise reducer and auto-notch filter parameters.
+const rxNRTaps = 64
+const rxNRDelay = 16
+const rxNRMu = 0.01
+const rxANFTaps = 64
+const rxANFDelay = 32
+const rxANFMu = 0.005
+const rxLMSLeakage = 0.9999
+
+type rxBandpassFilter struct {
+	low  float64 // Hz
+	high float64
+}
+
+func (f rxBandpassFilter) String() string {
+	return fmt.Sprint(f.low, "-", f.high)
+}
+
+type rxDSPConfig struct {
+	nr              bool // Initial state, can be toggled with the hotkeys.
+	anf             bool
+	bandpassIdx     int
+	bandpassFilters []rxBandpassFilter
+}
+
+// Parses a comma separated list of low-high filters, like 300-2700,500-900
+func parseRxBandpassFilters(s string) (res []rxBandpassFilter, err error) {
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		fields := strings.Split(f, "-")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid bandpass filter %s, the format is low-high", f)
+		}
+		var bp rxBandpassFilter
+		if bp.low, err = strconv.ParseFloat(fields[0], 64); err != nil {
+			return nil, fmt.Errorf("invalid bandpass filter %s, the format is low-high", f)
+		}
+		if bp.high, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return nil, fmt.Errorf("invalid bandpass filter %s, the format is low-high", f)
+		}
+		if bp.low <= 0 || bp.high <= bp.low || bp.high >= audioSampleRate/2 {
+			return nil, fmt.Errorf("invalid bandpass filter %s", f)
+		}
+		res = append(res, bp)
+	}
+	return
+}
+
+// Processes the received audio before it's played: noise reduction, auto-notch and bandpass filter, in this
+// order. All of them are sample based, so the frames keep their length and timing.
+type rxDSPStruct struct {
+	sess *session
+
+	mutex       sync.Mutex
+	nrEnabled   bool
+	anfEnabled  bool
+	bandpassIdx int // 0 means the bandpass filter is off, 1 is the first filter.
+
+	nr       lmsFilter
+	anf      lmsFilter
+	bandpass []biquadFilter
+}
+
+func (s *rxDSPStruct) init() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.nrEnabled = s.sess.conf.rxDSP.nr
+	s.anfEnabled = s.sess.conf.rxDSP.anf
+	s.bandpassIdx = s.sess.conf.rxDSP.bandpassIdx
+	s.nr = newLMSFilter(rxNRTaps, rxNRDelay, rxNRMu, rxLMSLeakage)
+	s.anf = newLMSFilter(rxANFTaps, rxANFDelay, rxANFMu, rxLMSLeakage)
+	s.initBandpass()
+}
+
+// Expects the mutex to be locked.
+func (s *rxDSPStruct) initBandpass() {
+	s.bandpass = nil
+	if s.bandpassIdx == 0 {
+		return
+	}
+	f := s.sess.conf.rxDSP.bandpassFilters[s.bandpassIdx-1]
+	s.bandpass = []biquadFilter{
+		newHighpassFilter(f.low, butterworthQ),
+		newHighpassFilter(f.low, butterworthQ),
+		newLowpassFilter(f.high, butterworthQ),
+		newLowpassFilter(f.high, butterworthQ),
+	}
+}
+
+// The log functions are called with the mutex unlocked, as they lock the status log, which calls
+// getStatusStr().
+
+func (s *rxDSPStruct) toggleNR() {
+	s.mutex.Lock()
+	s.nrEnabled = !s.nrEnabled
+	s.nr = newLMSFilter(rxNRTaps, rxNRDelay, rxNRMu, rxLMSLeakage)
+	enabled := s.nrEnabled
+	s.mutex.Unlock()
+
+	if enabled {
+		log.Print("noise reduction on")
+	} else {
+		log.Print("noise reduction off")
+	}
+}
+
+func (s *rxDSPStruct) toggleANF() {
+	s.mutex.Lock()
+	s.anfEnabled = !s.anfEnabled
+	s.anf = newLMSFilter(rxANFTaps, rxANFDelay, rxANFMu, rxLMSLeakage)
+	enabled := s.anfEnabled
+	s.mutex.Unlock()
+
+	if enabled {
+		log.Print("auto-notch on")
+	} else {
+		log.Print("auto-notch off")
+	}
+}
+
+// Switches to the next bandpass filter, after the last one the filter is turned off.
+func (s *rxDSPStruct) nextBandpass() {
+	filters := s.sess.conf.rxDSP.bandpassFilters
+	if len(filters) == 0 {
+		log.Error("no bandpass filters set")
+		return
+	}
+
+	s.mutex.Lock()
+	s.bandpassIdx = (s.bandpassIdx + 1) % (len(filters) + 1)
+	s.initBandpass()
+	idx := s.bandpassIdx
+	s.mutex.Unlock()
+
+	if idx == 0 {
+		log.Print("bandpass filter off")
+	} else {
+		log.Print("bandpass filter ", filters[idx-1], " Hz")
+	}
+}
+
+// Returns the active processing steps, or an empty string if none of them are active.
+func (s *rxDSPStruct) getStatusStr() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var res string
+	if s.nrEnabled {
+		res += " NR"
+	}
+	if s.anfEnabled {
+		res += " ANF"
+	}
+	if s.bandpassIdx > 0 {
+		res += " BP" + s.sess.conf.rxDSP.bandpassFilters[s.bandpassIdx-1].String()
+	}
+	return res
+}
+
+// Expects the mutex to be locked.
+func (s *rxDSPStruct) processSample(x float64) float64 {
+	if s.nrEnabled {
+		x, _ = s.nr.process(x)
+	}
+	if s.anfEnabled {
+		_, x = s.anf.process(x)
+	}
+	for i := range s.bandpass {
+		x = s.bandpass[i].process(x)
+	}
+	return x
+}
+
+// Processes a frame of 48kHz, s16le, mono audio in place.
+func (s *rxDSPStruct) process(d []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.nrEnabled && !s.anfEnabled && s.bandpassIdx == 0 {
+		return
+	}
+	processAudioSamples(d, s.processSample)
+}
@@ -46,6 +46,7 @@ type seqBufEntry struct {
 type requestRetransmitCallbackType func(r seqNumRange) error
 
 type seqBuf struct {
+	sess                      *session
 	length                    time.Duration
 	maxSeqNum                 seqNum
 	maxSeqNumDiff             seqNum
@@ -78,6 +79,7 @@ type seqBuf struct {
 	watcherCloseDoneChan   chan bool
 
 	errOutOfOrder error
+	errEmpty      error
 }
 
 // func (s *seqBuf) string() (out string) {
@@ -108,10 +110,7 @@ func (s *seqBuf) notifyWatcher() {
 }
 
 func (s *seqBuf) addToFront(seq seqNum, data []byte) {
-	e := s.createEntry(seq, data)
-	s.entries = append([]seqBufEntry{e}, s.entries...)
-
-	s.notifyWatcher()
+	s.insert(seq, data, 0)
 }
 
 func (s *seqBuf) addToBack(seq seqNum, data []byte) {
@@ -121,19 +120,15 @@ func (s *seqBuf) addToBack(seq seqNum, data []byte) {
 	s.notifyWatcher()
 }
 
+// Entries are moved in place, so the entries slice is only reallocated when it grows.
 func (s *seqBuf) insert(seq seqNum, data []byte, toPos int) {
-	if toPos == 0 {
-		s.addToFront(seq, data)
-		return
-	}
 	if toPos >= len(s.entries) {
 		s.addToBack(seq, data)
 		return
 	}
-	sliceBefore := s.entries[:toPos]
-	sliceAfter := s.entries[toPos:]
-	e := s.createEntry(seq, data)
-	s.entries = append(sliceBefore, append([]seqBufEntry{e}, sliceAfter...)...)
+	s.entries = append(s.entries, seqBufEntry{})
+	copy(s.entries[toPos+1:], s.entries[toPos:])
+	s.entries[toPos] = s.createEntry(seq, data)
 
 	s.notifyWatcher()
 }
@@ -178,6 +173,7 @@ func (s *seqBuf) compareSeq(seq, toSeq seqNum) seqBufCompareResult {
 	return larger
 }
 
+// Takes over the ownership of data, see bufpool.go.
 func (s *seqBuf) add(seq seqNum, data []byte) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -188,6 +184,7 @@ func (s *seqBuf) add(seq seqNum, data []byte) error {
 	// }()
 
 	if seq > s.maxSeqNum {
+		pktBufPool.put(data)
 		return errors.New("seq out of range")
 	}
 
@@ -197,6 +194,7 @@ func (s *seqBuf) add(seq seqNum, data []byte) error {
 	}
 
 	if s.entries[0].seq == seq { // Dropping duplicate seq.
+		pktBufPool.put(data)
 		return nil
 	}
 
@@ -210,6 +208,7 @@ func (s *seqBuf) add(seq seqNum, data []byte) error {
 	for i := 1; i < len(s.entries); i++ {
 		// This seqnum is already in the queue? Ignoring it.
 		if s.entries[i].seq == seq {
+			pktBufPool.put(data)
 			return nil
 		}
 
@@ -226,11 +225,27 @@ func (s *seqBuf) add(seq seqNum, data []byte) error {
 	return nil
 }
 
+// Returns true if entries with seq have already been passed, so they would be dropped.
+func (s *seqBuf) isLate(seq seqNum) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.alreadyReturnedFirstSeq && s.compareSeq(seq, s.lastReturnedSeq) != larger
+}
+
+// Changes the time to wait for missing entries.
+func (s *seqBuf) setLength(length time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.length = length
+}
+
 func (s *seqBuf) checkLockTimeout() (timeout bool, shouldRetryIn time.Duration) {
 	timeSinceLastInvalidSeq := time.Since(s.lockedAt)
 	lockDuration := s.length
-	if lockDuration < controlStreamLatency*2 {
-		lockDuration = controlStreamLatency * 2
+	if lockDuration < s.sess.controlStreamLatency*2 {
+		lockDuration = s.sess.controlStreamLatency * 2
 	}
 	if lockDuration > timeSinceLastInvalidSeq {
 		shouldRetryIn = lockDuration - timeSinceLastInvalidSeq
@@ -280,7 +295,7 @@ func (s *seqBuf) get() (e seqBufEntry, shouldRetryIn time.Duration, err error) {
 	defer s.mutex.Unlock()
 
 	if len(s.entries) == 0 {
-		return e, 0, errors.New("seqbuf is empty")
+		return e, 0, s.errEmpty
 	}
 
 	entryCount := len(s.entries)
@@ -301,6 +316,8 @@ func (s *seqBuf) get() (e seqBufEntry, shouldRetryIn time.Duration, err error) {
 		} else {
 			if s.compareSeq(e.seq, seqNum(s.lastReturnedSeq)) != larger {
 				// log.Debug("ignoring out of order seq ", e.seq)
+				pktBufPool.put(e.data)
+				s.entries[lastEntryIdx] = seqBufEntry{}
 				s.entries = s.entries[:lastEntryIdx]
 				err = s.errOutOfOrder
 				return
@@ -340,6 +357,7 @@ func (s *seqBuf) get() (e seqBufEntry, shouldRetryIn time.Duration, err error) {
 	s.lastReturnedSeq = e.seq
 	s.alreadyReturnedFirstSeq = true
 
+	s.entries[lastEntryIdx] = seqBufEntry{}
 	s.entries = s.entries[:lastEntryIdx]
 	return e, 0, nil
 }
@@ -394,8 +412,9 @@ func (s *seqBuf) watcher() {
 
 // Setting a max. seqnum diff is optional. If it's 0 then the diff will be half of the maxSeqNum range.
 // Available entries coming out from the seqbuf will be sent to entryChan.
-func (s *seqBuf) init(length time.Duration, maxSeqNum, maxSeqNumDiff seqNum, entryChan chan seqBufEntry,
+func (s *seqBuf) init(sess *session, length time.Duration, maxSeqNum, maxSeqNumDiff seqNum, entryChan chan seqBufEntry,
 	requestRetransmitCallback requestRetransmitCallbackType) {
+	s.sess = sess
 	s.length = length
 	s.maxSeqNum = maxSeqNum
 	s.maxSeqNumDiff = maxSeqNumDiff
@@ -407,6 +426,7 @@ func (s *seqBuf) init(length time.Duration, maxSeqNum, maxSeqNumDiff seqNum, ent
 	s.watcherCloseDoneChan = make(chan bool)
 
 	s.errOutOfOrder = errors.New("out of order pkt")
+	s.errEmpty = errors.New("seqbuf is empty")
 
 	go s.watcher()
 }
@@ -417,4 +437,11 @@ func (s *seqBuf) deinit() {
 	}
 	s.watcherCloseNeededChan <- true
 	<-s.watcherCloseDoneChan
+
+	s.mutex.Lock()
+	for _, e := range s.entries {
+		pktBufPool.put(e.data)
+	}
+	s.entries = nil
+	s.mutex.Unlock()
 }
@@ -9,6 +9,7 @@ import (
 )
 
 type serialPortStruct struct {
+	sess    *session
 	pty     *term.PTY
 	symlink string
 
@@ -23,8 +24,6 @@ type serialPortStruct struct {
 	write chan []byte
 }
 
-var serialPort serialPortStruct
-
 func (s *serialPortStruct) writeLoop() {
 	var b []byte
 	for {
@@ -39,7 +38,7 @@ func (s *serialPortStruct) writeLoop() {
 			written, err := s.pty.Master.Write(b)
 			if err != nil {
 				if _, ok := err.(*os.PathError); !ok {
-					reportError(err)
+					s.sess.reportError(err)
 				}
 			}
 			b = b[written:]
@@ -53,7 +52,7 @@ func (s *serialPortStruct) readLoop() {
 		n, err := s.pty.Master.Read(b)
 		if err != nil {
 			if _, ok := err.(*os.PathError); !ok {
-				reportError(err)
+				s.sess.reportError(err)
 			}
 		}
 
@@ -10,6 +10,7 @@ const maxSerialFrameLength = 80 // Max. frame length according to Hamlib.
 const serialRxSeqBufLength = 100 * time.Millisecond
 
 type serialStream struct {
+	sess   *session
 	common streamCommon
 
 	sendSeq uint16
@@ -68,6 +69,7 @@ func (s *serialStream) handleRxSeqBufEntry(e seqBufEntry) {
 		// Out of order packets can happen if we receive a retransmitted packet, but too late.
 		if s.rxSeqBuf.compareSeq(e.seq, seqNum(s.lastReceivedSeq)) != larger {
 			log.Debug("got out of order pkt seq #", e.seq)
+			pktBufPool.put(e.data)
 			return
 		}
 
@@ -79,33 +81,38 @@ func (s *serialStream) handleRxSeqBufEntry(e seqBufEntry) {
 			} else {
 				missingPkts = int(gotSeq) + 65536 - int(expectedSeq)
 			}
-			netstat.reportLoss(missingPkts)
-			log.Error("lost ", missingPkts, " packets")
+			s.common.stats.reportLoss(missingPkts)
+			log.Error(s.sess.logPrefix(), "lost ", missingPkts, " packets")
 		}
 	}
 	s.lastReceivedSeq = gotSeq
 	s.receivedSerialData = true
 
 	if s.common.pkt0.isPkt0(e.data) {
+		pktBufPool.put(e.data)
 		return
 	}
 
-	e.data = e.data[21:]
-
-	if !civControl.decode(e.data) {
+	if !s.sess.civControl.decode(e.data[21:]) {
+		pktBufPool.put(e.data)
 		return
 	}
 
-	if serialPort.write != nil {
-		serialPort.write <- e.data
+	// The data is handed over to the virtual serial port and the TCP server, so it's not returned to the
+	// pool.
+	e.data = e.data[21:]
+
+	if s.sess.serialPort.write != nil {
+		s.sess.serialPort.write <- e.data
 	}
-	if serialTCPSrv.isClientConnected() {
-		serialTCPSrv.toClient <- e.data
+	if s.sess.serialTCPSrv.isClientConnected() {
+		s.sess.serialTCPSrv.toClient <- e.data
 	}
 }
 
 func (s *serialStream) handleSerialPacket(r []byte) error {
 	gotSeq := binary.LittleEndian.Uint16(r[6:8])
+	s.common.stats.reportRxSeq(gotSeq, s.rxSeqBuf.isLate(seqNum(gotSeq)))
 	return s.rxSeqBuf.add(seqNum(gotSeq), r)
 }
 
@@ -114,6 +121,7 @@ func (s *serialStream) handleRead(r []byte) error {
 	if s.common.pkt0.isIdlePkt0(r) || (len(r) >= 22 && r[16] == 0xc1 && r[0]-0x15 == r[17]) {
 		return s.handleSerialPacket(r)
 	}
+	pktBufPool.put(r)
 	return nil
 }
 
@@ -152,7 +160,7 @@ func (s *serialStream) gotDataForRadio(r []byte) {
 		s.readFromSerialPort.buf.WriteByte(b)
 		if b == 0xfc || b == 0xfd || s.readFromSerialPort.buf.Len() == maxSerialFrameLength {
 			if err := s.send(s.readFromSerialPort.buf.Bytes()); err != nil {
-				reportError(err)
+				s.sess.reportError(err)
 			}
 			if !s.readFromSerialPort.frameTimeout.Stop() {
 				<-s.readFromSerialPort.frameTimeout.C
@@ -165,19 +173,19 @@ func (s *serialStream) gotDataForRadio(r []byte) {
 }
 
 func (s *serialStream) loop() {
-	if enableSerialDevice {
+	if s.sess.conf.enableSerialDevice {
 		for {
 			select {
-			case r := <-serialPort.read:
+			case r := <-s.sess.serialPort.read:
 				s.gotDataForRadio(r)
 
 			case r := <-s.common.readChan:
 				if err := s.handleRead(r); err != nil {
-					reportError(err)
+					s.sess.reportError(err)
 				}
 			case e := <-s.rxSeqBufEntryChan:
 				s.handleRxSeqBufEntry(e)
-			case r := <-serialTCPSrv.fromClient:
+			case r := <-s.sess.serialTCPSrv.fromClient:
 				s.gotDataForRadio(r)
 			case <-s.readFromSerialPort.frameTimeout.C:
 				s.readFromSerialPort.buf.Reset()
@@ -192,11 +200,11 @@ func (s *serialStream) loop() {
 			select {
 			case r := <-s.common.readChan:
 				if err := s.handleRead(r); err != nil {
-					reportError(err)
+					s.sess.reportError(err)
 				}
 			case e := <-s.rxSeqBufEntryChan:
 				s.handleRxSeqBufEntry(e)
-			case r := <-serialTCPSrv.fromClient:
+			case r := <-s.sess.serialTCPSrv.fromClient:
 				s.gotDataForRadio(r)
 			case <-s.readFromSerialPort.frameTimeout.C:
 				s.readFromSerialPort.buf.Reset()
@@ -210,16 +218,16 @@ func (s *serialStream) loop() {
 }
 
 func (s *serialStream) init(devName string) error {
-	if err := s.common.init("serial", serialStreamPort); err != nil {
+	if err := s.common.init(s.sess, "serial", s.sess.conf.serialStreamPort, s.sess.conf.localSerialStreamPort); err != nil {
 		return err
 	}
 
-	if enableSerialDevice {
-		if err := serialPort.initIfNeeded(devName); err != nil {
+	if s.sess.conf.enableSerialDevice {
+		if err := s.sess.serialPort.initIfNeeded(devName); err != nil {
 			return err
 		}
 	}
-	if err := serialTCPSrv.initIfNeeded(); err != nil {
+	if err := s.sess.serialTCPSrv.initIfNeeded(); err != nil {
 		return err
 	}
 
@@ -238,7 +246,7 @@ func (s *serialStream) init(devName string) error {
 	log.Print("stream started")
 
 	s.rxSeqBufEntryChan = make(chan seqBufEntry)
-	s.rxSeqBuf.init(serialRxSeqBufLength, 0xffff, 0, s.rxSeqBufEntryChan, s.common.requestRetransmit)
+	s.rxSeqBuf.init(s.sess, serialRxSeqBufLength, 0xffff, 0, s.rxSeqBufEntryChan, s.common.requestRetransmit)
 
 	s.deinitNeededChan = make(chan bool)
 	s.deinitFinishedChan = make(chan bool)
@@ -246,9 +254,9 @@ func (s *serialStream) init(devName string) error {
 	s.readFromSerialPort.frameTimeout = time.NewTimer(0)
 	<-s.readFromSerialPort.frameTimeout.C
 
-	civControl.deinit()
-	civControl = civControlStruct{}
-	if err := civControl.init(s); err != nil {
+	s.sess.civControl.deinit()
+	s.sess.civControl = civControlStruct{}
+	if err := s.sess.civControl.init(s); err != nil {
 		return err
 	}
 
@@ -265,7 +273,7 @@ func (s *serialStream) deinit() {
 		s.deinitNeededChan <- true
 		<-s.deinitFinishedChan
 	}
-	civControl.deinit()
+	s.sess.civControl.deinit()
 	s.common.deinit()
 	s.rxSeqBuf.deinit()
 }
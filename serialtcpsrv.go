@@ -8,6 +8,7 @@ import (
 )
 
 type serialTCPSrvStruct struct {
+	sess     *session
 	listener net.Listener
 	client   net.Conn
 
@@ -24,8 +25,6 @@ type serialTCPSrvStruct struct {
 	mutex           sync.Mutex
 }
 
-var serialTCPSrv serialTCPSrvStruct
-
 func (s *serialTCPSrvStruct) isClientConnected() bool {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -134,7 +133,7 @@ func (s *serialTCPSrvStruct) loop() {
 
 		if err != nil {
 			if err != io.EOF {
-				reportError(err)
+				s.sess.reportError(err)
 			}
 			<-s.deinitNeededChan
 			s.deinitFinishedChan <- true
@@ -161,13 +160,13 @@ func (s *serialTCPSrvStruct) initIfNeeded() (err error) {
 		}
 	}
 
-	s.listener, err = net.Listen("tcp", fmt.Sprint(":", serialTCPPort))
+	s.listener, err = net.Listen("tcp", fmt.Sprint(":", s.sess.conf.serialTCPPort))
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	log.Print("exposing serial port on tcp port ", serialTCPPort)
+	log.Print(s.sess.logPrefix(), "exposing serial port on tcp port ", s.sess.conf.serialTCPPort)
 
 	s.fromClient = make(chan []byte)
 	s.toClient = make(chan []byte)
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const waitBetweenRetries = time.Second
+const retryCount = 5
+const waitOnRetryFailure = 65 * time.Second
+const busyPollInterval = 10 * time.Second
+
+type sessionConfig struct {
+	name                      string
+	connectAddress            string
+	username                  string
+	password                  string
+	civAddress                byte
+	civAddressSet             bool // True if the CI-V address was not the default value.
+	serialTCPPort             uint16
+	enableSerialDevice        bool
+	rigctldPort               uint16
+	runCmd                    string
+	runCmdOnSerialPortCreated string
+	setDataModeOnTx           bool
+	audioFormat               audioFormat
+	audioJitterBufferMin      time.Duration
+	audioJitterBufferMax      time.Duration
+	audioPLCMode              audioPLCMode
+	deviceName                string // Used instead of the radio's name in the virtual device names if not empty.
+	virtualSoundcardName      string // Empty means the name is generated from the radio's name.
+	virtualSoundcardDesc      string
+	virtualSoundcardFormat    soundcardFormat
+	monitorDevice             string // Empty means the PulseAudio default.
+	recordDevice              string
+	wavDir                    string
+	wavRecordTx               bool
+	wavMaxSize                int64 // In bytes, 0 means no limit.
+	wavMaxDuration            time.Duration
+	voiceKeyerFiles           []string // Message files for F1, F2...
+	voiceKeyerRepeat          time.Duration
+	voxEnabled                bool
+	voxThreshold              float64 // dBFS
+	voxAttack                 time.Duration
+	voxHang                   time.Duration
+	voxMaxTx                  time.Duration
+	txDSP                     txDSPConfig
+	rxDSP                     rxDSPConfig
+	waitIfBusy                bool
+	noSoundcard               bool // Used by the tests, the received audio is discarded and nothing is transmitted.
+
+	controlStreamPort      uint16
+	serialStreamPort       uint16
+	audioStreamPort        uint16
+	localAddress           string
+	localControlStreamPort uint16
+	localSerialStreamPort  uint16
+	localAudioStreamPort   uint16
+
+	// Contains where each setting's value came from.
+	argSources []argSource
+}
+
+// A session holds everything needed to drive one transceiver: the streams, the CI-V state, the virtual
+// soundcard, the virtual serial port and the TCP servers.
+type session struct {
+	conf sessionConfig
+
+	civControl      civControlStruct
+	audio           audioStruct
+	recorder        recorderStruct
+	voiceKeyer      voiceKeyerStruct
+	vox             voxStruct
+	txDSP           txDSPStruct
+	rxDSP           rxDSPStruct
+	rxAudioLevel    audioLevelMeter
+	txAudioLevel    audioLevelMeter
+	serialPort      serialPortStruct
+	serialTCPSrv    serialTCPSrvStruct
+	rigctld         rigctldStruct
+	statusLog       statusLogStruct
+	netstat         netstatStruct
+	metrics         metricsStruct
+	runCmdRunner    cmdRunner
+	serialCmdRunner cmdRunner
+
+	controlStreamLatency time.Duration
+
+	// Last used frequency for each band. It's here and not in civControl, so it's kept on reconnects.
+	bandFreqs [len(civBands)]uint
+
+	// Sent to the radio as the computer name in the login packet. The radio reports it if it's in use, so
+	// it's unique for each session, and kept on reconnects to recognize our previous session.
+	clientName string
+
+	// Capabilities of the radio, nil until the radio sends them after the login.
+	radioCaps *radioCapabilities
+
+	connState  connStateMachine
+	gotErrChan chan error
+}
+
+type sessionsStruct struct {
+	mutex     sync.Mutex
+	list      []*session
+	activeIdx int
+}
+
+var sessions sessionsStruct
+
+// Returns a prefix which can be used in log messages to tell sessions apart. It's empty if only one
+// session is running.
+func (s *session) logPrefix() string {
+	if sessions.count() < 2 {
+		return ""
+	}
+	return s.conf.name + "/"
+}
+
+// Returns the error message prefixed with the session's log prefix, if it doesn't already contain it.
+func (s *session) errorStr(err error) string {
+	p := s.logPrefix()
+	if strings.HasPrefix(err.Error(), p) {
+		return err.Error()
+	}
+	return p + err.Error()
+}
+
+func (s *session) logArgSources() {
+	for _, a := range s.conf.argSources {
+		if a.source == "default" {
+			log.Debug(s.logPrefix(), a.name, " = ", a.value, " (", a.source, ")")
+		} else {
+			log.Print(s.logPrefix(), a.name, " = ", a.value, " (", a.source, ")")
+		}
+
+		if a.name == "password" {
+			switch a.source {
+			case "default":
+				log.Print(s.logPrefix(), "warning: using the default password, please change it on the radio")
+			case "command line":
+				log.Print(s.logPrefix(), "warning: the password is visible to other users when set on the command line, ",
+					"use the credentials file or the ", argEnvVars["password"], " environment variable instead")
+			}
+		}
+	}
+}
+
+func (s *session) isActive() bool {
+	return sessions.getActive() == s
+}
+
+func (s *session) wait(d time.Duration, stopChan chan bool) (shouldExit bool) {
+	for sec := d.Seconds(); sec > 0; sec-- {
+		log.Print(s.logPrefix(), "waiting ", sec, " seconds...")
+		select {
+		case <-time.After(time.Second):
+		case <-stopChan:
+			return true
+		}
+	}
+	return false
+}
+
+// Runs the control stream until an error happens or stopChan is closed. Returns the error which stopped the
+// control stream, or nil if stopChan was closed.
+func (s *session) runControlStream(stopChan chan bool) (deauthConfirmed bool, err error) {
+	// Depleting gotErrChan.
+	var finished bool
+	for !finished {
+		select {
+		case <-s.gotErrChan:
+		default:
+			finished = true
+		}
+	}
+
+	ctrl := &controlStream{}
+
+	if err = ctrl.init(s); err != nil {
+		log.Error(s.errorStr(err))
+		ctrl.deinit()
+		return ctrl.deauthConfirmed, err
+	}
+
+	select {
+	case err = <-s.gotErrChan:
+	case <-stopChan:
+	}
+	ctrl.deinit()
+	return ctrl.deauthConfirmed, err
+}
+
+func (s *session) reportError(err error) {
+	if !getConnErrorKind(err).isSilent() {
+		log.ErrorC(log.GetCallerFileName(true), ": ", s.errorStr(err))
+	}
+
+	// Non-blocking notify.
+	select {
+	case s.gotErrChan <- err:
+	default:
+	}
+}
+
+func (s *session) handleConnStateChange(e connStateEvent) {
+	log.Debug(s.logPrefix(), "connection state: ", e.from, " -> ", e.to)
+
+	if e.from == connStateRequestingStreams && e.to == connStateStreaming {
+		s.runCmdRunner.startIfNeeded(s.conf.runCmd)
+		if s.conf.enableSerialDevice {
+			s.serialCmdRunner.startIfNeeded(s.conf.runCmdOnSerialPortCreated)
+		}
+		if err := s.rigctld.initIfNeeded(); err != nil {
+			s.reportError(err)
+		}
+	}
+}
+
+// Connects to the radio and keeps reconnecting until stopChan is closed or an unrecoverable error
+// happens.
+func (s *session) run(stopChan chan bool) (exitCode int) {
+	var retries int
+	var shouldExit bool
+
+	for {
+		deauthConfirmed, err := s.runControlStream(stopChan)
+
+		select {
+		case <-stopChan:
+			shouldExit = true
+		default:
+		}
+		if shouldExit {
+			break
+		}
+
+		kind := getConnErrorKind(err)
+		if kind.isFatal() || (kind == connErrorRadioBusy && !s.conf.waitIfBusy) {
+			s.connState.set(connStateFailed, err)
+			return 1
+		}
+		s.connState.set(connStateBackoff, err)
+
+		if kind == connErrorRadioBusy {
+			// Polling in a fixed interval until the other client disconnects.
+			log.Print(s.logPrefix(), "waiting for the radio to become free...")
+			retries = 0
+			if s.wait(busyPollInterval, stopChan) {
+				break
+			}
+			continue
+		}
+
+		// Need to wait before reinit because the IC-705 will disconnect our audio stream eventually if we
+		// relogin in a too short interval without a deauth. If the radio confirmed our deauth, then we can
+		// relogin immediately.
+		if kind.requireWait() && !deauthConfirmed {
+			if retries < retryCount {
+				retries++
+				shouldExit = s.wait(waitBetweenRetries, stopChan)
+			} else {
+				retries = 0
+				shouldExit = s.wait(waitOnRetryFailure, stopChan)
+			}
+		} else {
+			retries = 0
+			shouldExit = s.wait(time.Second, stopChan)
+		}
+
+		if shouldExit {
+			break
+		}
+		log.Print(s.logPrefix(), "restarting control stream...")
+		s.metrics.reportReconnect()
+	}
+	s.connState.set(connStateIdle, nil)
+	return
+}
+
+func (s *session) init(conf sessionConfig) {
+	s.conf = conf
+	s.clientName = getClientName()
+	s.civControl.sess = s
+	s.audio.sess = s
+	s.recorder.sess = s
+	s.voiceKeyer.sess = s
+	s.vox.sess = s
+	s.txDSP.sess = s
+	s.rxDSP.sess = s
+	s.rxDSP.init()
+	s.serialPort.sess = s
+	s.serialTCPSrv.sess = s
+	s.rigctld.sess = s
+	s.statusLog.sess = s
+	s.gotErrChan = make(chan error)
+	s.connState.subscribe(s.handleConnStateChange)
+	s.connState.subscribe(s.statusLog.handleConnStateChange)
+}
+
+// Returns a client name like kappanhang-1a2b, it has to fit in 15 bytes.
+func getClientName() string {
+	var id [2]byte
+	_, _ = rand.Read(id[:])
+	return fmt.Sprintf("kappanhang-%02x%02x", id[0], id[1])
+}
+
+func (s *session) deinit() {
+	s.rigctld.deinit()
+	s.serialTCPSrv.deinit()
+	s.runCmdRunner.stop()
+	s.serialCmdRunner.stop()
+	s.voiceKeyer.deinit()
+	s.vox.deinit()
+	s.recorder.deinit()
+	s.audio.deinit()
+	s.serialPort.deinit()
+}
+
+func (s *sessionsStruct) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.list)
+}
+
+// Returns the session which is controlled by the hotkeys and displayed on the realtime status bar.
+func (s *sessionsStruct) getActive() *session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.list) == 0 {
+		return nil
+	}
+	return s.list[s.activeIdx]
+}
+
+func (s *sessionsStruct) switchToNext() {
+	s.mutex.Lock()
+	if len(s.list) < 2 {
+		s.mutex.Unlock()
+		return
+	}
+	s.activeIdx = (s.activeIdx + 1) % len(s.list)
+	name := s.list[s.activeIdx].conf.name
+	s.mutex.Unlock()
+
+	log.Print("switched to ", name)
+}
+
+// Runs all sessions until stopChan is closed or all sessions exit. Returns the largest exit code of the
+// sessions.
+func (s *sessionsStruct) run(confs []sessionConfig, stopChan chan bool, finishedChan chan int) {
+	s.mutex.Lock()
+	for _, c := range confs {
+		sess := &session{}
+		sess.init(c)
+		s.list = append(s.list, sess)
+	}
+	list := s.list
+	s.mutex.Unlock()
+
+	for _, sess := range list {
+		sess.logArgSources()
+	}
+
+	exitCodes := make(chan int, len(list))
+	for _, sess := range list {
+		go func(sess *session) {
+			exitCode := sess.run(stopChan)
+			sess.deinit()
+			exitCodes <- exitCode
+		}(sess)
+	}
+
+	var exitCode int
+	for range list {
+		if c := <-exitCodes; c > exitCode {
+			exitCode = c
+		}
+	}
+	finishedChan <- exitCode
+}
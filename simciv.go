@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// Address of the controller (the PC) in CI-V frames.
+const simCIVControllerAddress = 0xe0
+
+const simTuneDuration = 2 * time.Second
+
+// A setting of the simulated transceiver. It can be read by sending the command (and subcommand) bytes
+// without data, and set by sending them followed by the new value.
+type simCIVRegister struct {
+	cmd      []byte
+	value    []byte
+	readOnly bool
+}
+
+// Emulates the CI-V interface of a transceiver for the simulator.
+type simCIV struct {
+	mutex         sync.Mutex
+	address       byte
+	registers     []*simCIVRegister
+	tuneStartedAt time.Time
+}
+
+func (c *simCIV) getRegister(cmd ...byte) *simCIVRegister {
+	for _, r := range c.registers {
+		if bytes.Equal(r.cmd, cmd) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Returns the register which can handle the given command, which can contain the value to set.
+func (c *simCIV) findRegister(d []byte) *simCIVRegister {
+	for _, r := range c.registers {
+		if bytes.HasPrefix(d, r.cmd) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (c *simCIV) getFrame(d ...byte) []byte {
+	return append(append([]byte{0xfe, 0xfe, simCIVControllerAddress, c.address}, d...), 0xfd)
+}
+
+// Returns the frequency of the main VFO in Hz.
+func (c *simCIV) getFreq() uint {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var civ civControlStruct
+	return civ.decodeFreqData(c.getRegister(0x25, 0x00).value)
+}
+
+// Returns the operating mode code of the main VFO.
+func (c *simCIV) getMode() byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.getRegister(0x26, 0x00).value[0]
+}
+
+func (c *simCIV) getPTT() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.getRegister(0x1c, 0x00).value[0] == 1
+}
+
+// Handles a CI-V frame sent to the transceiver and returns the frames to send back.
+func (c *simCIV) handle(d []byte) (res [][]byte) {
+	if len(d) < 6 || d[0] != 0xfe || d[1] != 0xfe || d[len(d)-1] != 0xfd {
+		return nil
+	}
+	if d[2] != c.address && d[2] != 0x00 {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ok := c.getFrame(0xfb)
+	ng := c.getFrame(0xfa)
+	d = d[4 : len(d)-1]
+	mainFreq := c.getRegister(0x25, 0x00)
+	mainMode := c.getRegister(0x26, 0x00)
+
+	// The legacy frequency and mode commands are mapped to the registers of the main VFO. Like a transceiver
+	// with CI-V transceive enabled, changes are also sent using the transceive commands (0x00 and 0x01).
+	switch {
+	case d[0] == 0x03:
+		return [][]byte{c.getFrame(append([]byte{0x03}, mainFreq.value...)...)}
+	case d[0] == 0x04:
+		return [][]byte{c.getFrame(0x04, mainMode.value[0], mainMode.value[2])}
+	case d[0] == 0x05:
+		if len(d) != 6 {
+			return [][]byte{ng}
+		}
+		copy(mainFreq.value, d[1:])
+		return [][]byte{ok, c.getFrame(append([]byte{0x00}, mainFreq.value...)...)}
+	case d[0] == 0x06:
+		if len(d) < 2 {
+			return [][]byte{ng}
+		}
+		mainMode.value[0] = d[1]
+		if len(d) > 2 {
+			mainMode.value[2] = d[2]
+		}
+		return [][]byte{ok, c.getFrame(0x01, mainMode.value[0], mainMode.value[2])}
+	case len(d) > 1 && d[0] == 0x1a && d[1] == 0x06: // Data mode, stored in the mode register of the main VFO.
+		if len(d) > 2 {
+			mainMode.value[1] = d[2]
+			if d[2] != 0 && len(d) > 3 {
+				mainMode.value[2] = d[3]
+			}
+			res = append(res, ok)
+		}
+		return append(res, c.getFrame(0x1a, 0x06, mainMode.value[1], mainMode.value[2]))
+	}
+
+	r := c.findRegister(d)
+	if r == nil {
+		return [][]byte{ng}
+	}
+	if len(d) > len(r.cmd) {
+		if r.readOnly {
+			return [][]byte{ng}
+		}
+		r.value = append([]byte{}, d[len(r.cmd):]...)
+		if bytes.Equal(r.cmd, []byte{0x1c, 0x01}) && r.value[0] == 2 {
+			c.tuneStartedAt = time.Now()
+		}
+		res = append(res, ok)
+	}
+
+	if bytes.Equal(r.cmd, []byte{0x1c, 0x01}) && r.value[0] == 2 && time.Since(c.tuneStartedAt) > simTuneDuration {
+		r.value[0] = 1 // Tune finished.
+	}
+	return append(res, c.getFrame(append(append([]byte{}, r.cmd...), r.value...)...))
+}
+
+func (c *simCIV) init(address byte) {
+	var civ civControlStruct
+	mainFreq := civ.encodeFreqData(7074000)
+	subFreq := civ.encodeFreqData(145500000)
+
+	c.address = address
+	c.registers = []*simCIVRegister{
+		{cmd: []byte{0x25, 0x00}, value: mainFreq[:]},
+		{cmd: []byte{0x25, 0x01}, value: subFreq[:]},
+		{cmd: []byte{0x26, 0x00}, value: []byte{0x01, 0x00, 0x01}},           // USB, no data mode, FIL1
+		{cmd: []byte{0x26, 0x01}, value: []byte{0x05, 0x00, 0x01}},           // FM, no data mode, FIL1
+		{cmd: []byte{0x07}, value: []byte{0x00}},                             // VFO A
+		{cmd: []byte{0x0f}, value: []byte{0x00}},                             // Split off
+		{cmd: []byte{0x10}, value: []byte{0x03}},                             // 1kHz tuning step
+		{cmd: []byte{0x14, 0x02}, value: []byte{0x02, 0x55}},                 // RF gain
+		{cmd: []byte{0x14, 0x03}, value: []byte{0x00, 0x00}},                 // Squelch
+		{cmd: []byte{0x14, 0x06}, value: []byte{0x01, 0x28}},                 // NR level
+		{cmd: []byte{0x14, 0x0a}, value: []byte{0x01, 0x28}},                 // TX power
+		{cmd: []byte{0x15, 0x02}, value: []byte{0x01, 0x20}, readOnly: true}, // S meter, about S9
+		{cmd: []byte{0x15, 0x12}, value: []byte{0x00, 0x00}, readOnly: true}, // SWR 1.0
+		{cmd: []byte{0x15, 0x15}, value: []byte{0x02, 0x13}, readOnly: true}, // Vd
+		{cmd: []byte{0x16, 0x02}, value: []byte{0x00}},                       // Preamp off
+		{cmd: []byte{0x16, 0x12}, value: []byte{0x02}},                       // AGC mid
+		{cmd: []byte{0x16, 0x40}, value: []byte{0x00}},                       // NR off
+		{cmd: []byte{0x1a, 0x09}, value: []byte{0x00}, readOnly: true},       // No overflow
+		{cmd: []byte{0x1c, 0x00}, value: []byte{0x00}},                       // PTT off
+		{cmd: []byte{0x1c, 0x01}, value: []byte{0x01}},                       // Tuner on, not tuning
+	}
+}
@@ -0,0 +1,744 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The simulator is a fake RS-BA1 server for testing kappanhang without a radio. It listens on the control,
+// serial and audio UDP ports, accepts the login and the stream request, answers pings and retransmit
+// requests, emulates a CI-V transceiver and sends a test tone on the audio stream. Packet loss, reordering
+// and latency can be injected to test reconnects and retransmits.
+//
+// It can be used from Go tests too: call simulator.init() with the ports set to 0, so the OS chooses free
+// ports, then connect a session to the ports returned by simulator.getPorts().
+
+const simPingInterval = 100 * time.Millisecond
+const simIdleInterval = time.Second
+const simAudioFrameInterval = 20 * time.Millisecond
+const simReorderDelay = 30 * time.Millisecond
+
+const simRadioName = "IC-705"
+const simAudioDeviceName = "ICOM_VAUDIO"
+const simToneFrequency = 1000
+const simToneAmplitude = 0.25
+
+type simulatorConfig struct {
+	address     string // Listen address, empty means all addresses.
+	controlPort uint16 // 0 means that the OS chooses a port.
+	serialPort  uint16
+	audioPort   uint16
+	username    string
+	password    string
+	civAddress  byte
+
+	lossPercent     float64       // Applied to packets in both directions.
+	reorderPercent  float64       // Packets sent by the simulator are delayed, so the next packets overtake them.
+	latency         time.Duration // Added to packets sent by the simulator.
+	disconnectAfter time.Duration // The client is disconnected after streaming for this long, 0 disables.
+	randSeed        int64         // Seed for the loss and reorder injection.
+}
+
+type simDelayedPacket struct {
+	sendAt time.Time
+	d      []byte
+	addr   *net.UDPAddr
+}
+
+// Injects packet loss, reordering and latency.
+type simLink struct {
+	conn *net.UDPConn
+	conf *simulatorConfig
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+	queue []simDelayedPacket // Sorted by sendAt.
+
+	queueChangedChan   chan bool
+	deinitNeededChan   chan bool
+	deinitFinishedChan chan bool
+}
+
+func (l *simLink) shouldDrop() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.rand.Float64()*100 < l.conf.lossPercent
+}
+
+func (l *simLink) send(d []byte, addr *net.UDPAddr) {
+	l.mutex.Lock()
+	if l.rand.Float64()*100 < l.conf.lossPercent {
+		l.mutex.Unlock()
+		return
+	}
+	delay := l.conf.latency
+	if l.rand.Float64()*100 < l.conf.reorderPercent {
+		delay += simReorderDelay
+	}
+	if delay == 0 {
+		l.mutex.Unlock()
+		_, _ = l.conn.WriteToUDP(d, addr)
+		return
+	}
+
+	p := simDelayedPacket{sendAt: time.Now().Add(delay), d: d, addr: addr}
+	i := len(l.queue)
+	for i > 0 && l.queue[i-1].sendAt.After(p.sendAt) {
+		i--
+	}
+	l.queue = append(l.queue, simDelayedPacket{})
+	copy(l.queue[i+1:], l.queue[i:])
+	l.queue[i] = p
+	l.mutex.Unlock()
+
+	// Non-blocking notify.
+	select {
+	case l.queueChangedChan <- true:
+	default:
+	}
+}
+
+func (l *simLink) loop() {
+	for {
+		l.mutex.Lock()
+		for len(l.queue) > 0 && !l.queue[0].sendAt.After(time.Now()) {
+			_, _ = l.conn.WriteToUDP(l.queue[0].d, l.queue[0].addr)
+			l.queue = l.queue[1:]
+		}
+		wait := time.Hour
+		if len(l.queue) > 0 {
+			wait = time.Until(l.queue[0].sendAt)
+		}
+		l.mutex.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-l.queueChangedChan:
+		case <-l.deinitNeededChan:
+			timer.Stop()
+			l.deinitFinishedChan <- true
+			return
+		}
+		timer.Stop()
+	}
+}
+
+func (l *simLink) init(conn *net.UDPConn, conf *simulatorConfig, seed int64) {
+	l.conn = conn
+	l.conf = conf
+	l.rand = rand.New(rand.NewSource(seed))
+	l.queueChangedChan = make(chan bool)
+	l.deinitNeededChan = make(chan bool)
+	l.deinitFinishedChan = make(chan bool)
+	go l.loop()
+}
+
+func (l *simLink) deinit() {
+	if l.deinitNeededChan != nil {
+		l.deinitNeededChan <- true
+		<-l.deinitFinishedChan
+	}
+}
+
+// The server side of a stream, it handles the parts of the protocol which are common for all streams.
+type simStream struct {
+	sim  *simulator
+	name string
+	conn *net.UDPConn
+	link simLink
+
+	readerFinishedChan chan bool
+
+	// The fields below are protected by the mutex.
+	mutex        sync.Mutex
+	clientAddr   *net.UDPAddr
+	localSID     uint32
+	remoteSID    uint32
+	ready        bool // True if the client finished the pkt3/pkt6 handshake.
+	sendSeq      uint16
+	innerSendSeq uint16 // Used by the serial and audio data packets.
+	pingSeq      uint16
+	txSeqBuf     txSeqBufStruct
+	gotRxSeq     bool
+	lastRxSeq    uint16
+	rxSeqSeen    [0x10000]bool
+}
+
+// Returns a packet of the given length with the length and the session IDs already filled in.
+func (s *simStream) newPacket(l int) []byte {
+	p := make([]byte, l)
+	binary.LittleEndian.PutUint16(p[0:2], uint16(l))
+	binary.BigEndian.PutUint32(p[8:12], s.localSID)
+	binary.BigEndian.PutUint32(p[12:16], s.remoteSID)
+	return p
+}
+
+// The mutex must be held when calling the send functions.
+func (s *simStream) send(d []byte) {
+	if s.clientAddr != nil {
+		s.link.send(d, s.clientAddr)
+	}
+}
+
+func (s *simStream) sendTracked(d []byte) {
+	binary.LittleEndian.PutUint16(d[6:8], s.sendSeq)
+	s.txSeqBuf.add(seqNum(s.sendSeq), d)
+	s.send(d)
+	s.sendSeq++
+}
+
+func (s *simStream) sendUntracked(pktType byte, seq uint16) {
+	p := s.newPacket(16)
+	p[4] = pktType
+	binary.LittleEndian.PutUint16(p[6:8], seq)
+	s.send(p)
+}
+
+func (s *simStream) sendIdle() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.ready {
+		s.sendTracked(s.newPacket(16))
+	}
+}
+
+func (s *simStream) sendPing() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.ready {
+		return
+	}
+	// See pkt7Type.sendDo() for an example.
+	p := s.newPacket(21)
+	p[4] = 0x07
+	binary.LittleEndian.PutUint16(p[6:8], s.pingSeq)
+	binary.LittleEndian.PutUint32(p[17:21], rand.Uint32())
+	s.send(p)
+	s.pingSeq++
+}
+
+func (s *simStream) retransmit(start, end uint16) {
+	for i := 0; i <= maxRetransmitRequestPacketCount; i++ {
+		if d := s.txSeqBuf.get(seqNum(start)); d != nil {
+			log.Debug(s.name+"/retransmitting #", start)
+			s.send(d)
+		} else {
+			log.Debug(s.name+"/can't retransmit #", start, " - not found")
+			s.sendUntracked(0x00, start)
+		}
+		if start == end {
+			break
+		}
+		start++
+	}
+}
+
+// Checks the sequence number of a tracked packet and requests the retransmit of the missing packets.
+// Returns false if the packet was already received.
+func (s *simStream) handleRxSeq(seq uint16) bool {
+	if s.rxSeqSeen[seq] {
+		return false
+	}
+	s.rxSeqSeen[seq] = true
+	// Forgetting the sequence numbers which are far behind, so they can be received again after a
+	// wraparound.
+	s.rxSeqSeen[seq+0x8000] = false
+
+	if s.gotRxSeq {
+		diff := seq - s.lastRxSeq
+		if diff >= 0x8000 { // An older packet, probably a retransmitted one.
+			return true
+		}
+		if diff > 1 && int(diff)-1 <= maxRetransmitRequestPacketCount {
+			start := s.lastRxSeq + 1
+			end := seq - 1
+			log.Debug(s.name+"/requesting pkt #", start, "-#", end, " retransmit")
+			if start == end {
+				s.sendUntracked(0x01, start)
+			} else {
+				p := s.newPacket(20)
+				p[0] = 0x18 // The client expects this value instead of the length.
+				p[4] = 0x01
+				binary.LittleEndian.PutUint16(p[16:18], start)
+				binary.LittleEndian.PutUint16(p[18:20], end)
+				s.send(p)
+			}
+		}
+	}
+	s.gotRxSeq = true
+	s.lastRxSeq = seq
+	return true
+}
+
+func (s *simStream) reset(clientAddr *net.UDPAddr) {
+	s.clientAddr = clientAddr
+	s.localSID = rand.Uint32()
+	s.ready = false
+	s.sendSeq = 1
+	s.innerSendSeq = 0
+	s.pingSeq = 0
+	s.txSeqBuf = txSeqBufStruct{}
+	s.gotRxSeq = false
+	s.rxSeqSeen = [0x10000]bool{}
+}
+
+// Handles the packets which are common for all streams. Returns true in forward if the packet should be
+// passed to the stream's handler, and true in reset if the client connected again or disconnected.
+func (s *simStream) handleCommon(r []byte, addr *net.UDPAddr) (forward, reset bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pktType := binary.LittleEndian.Uint16(r[4:6])
+	seq := binary.LittleEndian.Uint16(r[6:8])
+	isCurrentClient := s.clientAddr != nil && s.clientAddr.String() == addr.String()
+
+	switch {
+	case len(r) == 16 && pktType == 0x03: // Are you there?
+		// The client sends this packet twice, the second one should not reset the stream.
+		if !isCurrentClient || s.ready {
+			log.Print(s.name+"/new connection from ", addr)
+			s.reset(addr)
+			reset = true
+		}
+		s.remoteSID = binary.BigEndian.Uint32(r[8:12])
+		s.sendUntracked(0x04, 0)
+		return
+	case !isCurrentClient:
+		return
+	case len(r) == 16 && pktType == 0x06: // Are you ready?
+		s.sendUntracked(0x06, 1)
+		s.ready = true
+	case len(r) == 16 && pktType == 0x05:
+		log.Print(s.name + "/client disconnected")
+		s.clientAddr = nil
+		s.ready = false
+		reset = true
+	case len(r) == 21 && pktType == 0x07:
+		if r[16] == 0x00 { // Ping request, ping replies from the client are not checked.
+			p := s.newPacket(21)
+			copy(p[4:8], r[4:8])
+			p[16] = 0x01
+			copy(p[17:21], r[17:21])
+			s.send(p)
+		}
+	case len(r) == 16 && pktType == 0x01:
+		log.Debug(s.name+"/got retransmit request for #", seq)
+		s.retransmit(seq, seq)
+	case r[0] == 0x18 && pktType == 0x01:
+		for d := r[16:]; len(d) >= 4; d = d[4:] {
+			start := binary.LittleEndian.Uint16(d[0:2])
+			end := binary.LittleEndian.Uint16(d[2:4])
+			log.Debug(s.name+"/got retransmit request for #", start, "-", end)
+			s.retransmit(start, end)
+		}
+	case pktType == 0x00: // A tracked packet.
+		forward = s.ready && s.handleRxSeq(seq) && len(r) > 16
+	}
+	return
+}
+
+func (s *simStream) reader() {
+	for {
+		b := make([]byte, 1500)
+		n, addr, err := s.conn.ReadFromUDP(b)
+		if err != nil {
+			if getConnErrorKind(wrapNetError(err)) == connErrorClosed {
+				s.readerFinishedChan <- true
+				return
+			}
+			log.Error(s.name+"/", err)
+			continue
+		}
+		if n < 16 || s.link.shouldDrop() {
+			continue
+		}
+
+		forward, reset := s.handleCommon(b[:n], addr)
+		if reset && s == &s.sim.control {
+			s.sim.resetSession()
+		}
+		if forward {
+			s.sim.handlePacket(s, b[:n])
+		}
+	}
+}
+
+func (s *simStream) getPort() uint16 {
+	return uint16(s.conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func (s *simStream) init(sim *simulator, name string, port uint16, seed int64) error {
+	s.sim = sim
+	s.name = "sim-" + name
+	addr, err := net.ResolveUDPAddr("udp", fmt.Sprint(sim.conf.address, ":", port))
+	if err != nil {
+		return err
+	}
+	if s.conn, err = net.ListenUDP("udp", addr); err != nil {
+		return err
+	}
+	log.Print(s.name+"/listening on ", s.conn.LocalAddr())
+
+	s.link.init(s.conn, &sim.conf, seed)
+	s.readerFinishedChan = make(chan bool)
+	go s.reader()
+	return nil
+}
+
+func (s *simStream) deinit() {
+	if s.conn == nil {
+		return
+	}
+	s.conn.Close()
+	<-s.readerFinishedChan
+	s.link.deinit()
+}
+
+type simulator struct {
+	conf simulatorConfig
+
+	control simStream
+	serial  simStream
+	audio   simStream
+	civ     simCIV
+
+	deinitNeededChan   chan bool
+	deinitFinishedChan chan bool
+
+	// The fields below are protected by the mutex.
+	mutex           sync.Mutex
+	loggedIn        bool
+	authID          [6]byte
+	clientName      [16]byte
+	streamsOpened   bool
+	streamsOpenedAt time.Time
+	audioConverter  audioConverter
+	tonePhase       float64
+}
+
+// Returns the UDP ports of the control, serial and audio streams.
+func (s *simulator) getPorts() (control, serial, audio uint16) {
+	return s.control.getPort(), s.serial.getPort(), s.audio.getPort()
+}
+
+func (s *simulator) resetSession() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.loggedIn = false
+	s.streamsOpened = false
+}
+
+func (s *simulator) handlePacket(st *simStream, r []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	switch st {
+	case &s.control:
+		s.handleControlPacket(r)
+	case &s.serial:
+		// See serialStream.send() for the packet format.
+		if len(r) > 21 && r[16] == 0xc1 && int(r[17]) == len(r)-21 {
+			for _, f := range s.civ.handle(r[21:]) {
+				s.sendSerialData(f)
+			}
+		}
+	case &s.audio:
+		// TX audio is discarded.
+	}
+}
+
+func (s *simulator) handleControlPacket(r []byte) {
+	st := &s.control
+
+	switch {
+	case len(r) == 128 && r[0] == 0x80: // Login, see controlStream.sendPktLogin().
+		p := st.newPacket(96)
+		copy(p[16:20], []byte{0x00, 0x00, 0x00, 0x50})
+		p[20] = 0x02
+		copy(p[22:26], r[22:26])
+		if !bytes.Equal(r[64:80], passcode(s.conf.username)) || !bytes.Equal(r[80:96], passcode(s.conf.password)) {
+			log.Print(st.name + "/invalid username/password")
+			copy(p[48:52], []byte{0xff, 0xff, 0xff, 0xfe})
+			st.sendTracked(p)
+			return
+		}
+
+		// The first 2 bytes of the auth ID are set by the client.
+		copy(s.authID[:2], r[26:28])
+		binary.BigEndian.PutUint32(s.authID[2:], rand.Uint32())
+		copy(s.clientName[:], r[96:112])
+		s.loggedIn = true
+		log.Print(st.name+"/client logged in: ", parseNullTerminatedString(s.clientName[:]))
+
+		copy(p[26:32], s.authID[:])
+		copy(p[64:68], "FTTH")
+		st.sendTracked(p)
+		s.sendCapabilities()
+	case len(r) == 64 && r[0] == 0x40 && s.loggedIn: // Auth, see controlStream.sendPktAuth().
+		p := st.newPacket(64)
+		copy(p[16:20], []byte{0x00, 0x00, 0x00, 0x30})
+		p[20] = 0x02
+		p[21] = r[21]
+		copy(p[22:26], r[22:26])
+		copy(p[26:32], s.authID[:])
+		st.sendTracked(p)
+
+		if r[21] == 0x01 {
+			log.Print(st.name + "/client deauthenticated")
+			s.loggedIn = false
+			s.streamsOpened = false
+		}
+	case len(r) == 144 && r[0] == 0x90 && s.loggedIn: // Stream request, see controlStream.sendRequestSerialAndAudio().
+		s.handleStreamRequest(r)
+	}
+}
+
+// Sends the 0xa8 packet, see controlStream.handleRead() for an example and parseRadioCapabilities() for the
+// fields.
+func (s *simulator) sendCapabilities() {
+	st := &s.control
+	p := st.newPacket(168)
+	copy(p[16:24], []byte{0x00, 0x00, 0x00, 0x98, 0x02, 0x02, 0x00, 0x07})
+	copy(p[26:32], s.authID[:])
+	p[65] = 0x01
+	copy(p[66:82], []byte{0x93, 0x8a, 0x01, 0x24, 0x17, 0x64, 0xbc, 0x4b, 0xa3, 0xa0, 0x13, 0x58, 0x41, 0x04, 0x58, 0x2d})
+	copy(p[82:114], simRadioName)
+	copy(p[114:146], simAudioDeviceName)
+	p[148] = s.conf.civAddress
+	var rxCodecs, txCodecs uint16
+	for _, c := range audioCodecs {
+		rxCodecs |= uint16(c.code)
+		txCodecs |= uint16(c.code)
+	}
+	binary.BigEndian.PutUint16(p[149:151], rxCodecs)
+	binary.BigEndian.PutUint16(p[151:153], txCodecs)
+	binary.BigEndian.PutUint32(p[156:160], 115200)
+	st.sendTracked(p)
+}
+
+func (s *simulator) handleStreamRequest(r []byte) {
+	st := &s.control
+
+	format, err := parseStreamRequestAudioFormat(r)
+	if err != nil {
+		log.Error(st.name+"/invalid stream request: ", err)
+		return
+	}
+	s.audioConverter = audioConverter{format: format}
+	log.Print(st.name+"/streams requested, audio format: ", format)
+
+	// See controlStream.handleRead() for an example.
+	p := st.newPacket(144)
+	copy(p[16:24], []byte{0x00, 0x00, 0x00, 0x80, 0x03, 0x00, 0x00, 0x00})
+	copy(p[26:32], s.authID[:])
+	copy(p[64:96], simRadioName)
+	p[96] = 0x01
+	copy(p[100:116], s.clientName[:])
+	if ip := st.clientAddr.IP.To4(); ip != nil {
+		copy(p[132:136], ip)
+	}
+	st.sendTracked(p)
+
+	s.streamsOpened = true
+	s.streamsOpenedAt = time.Now()
+}
+
+// The mutex of the serial stream must be held.
+func (s *simulator) sendSerialData(d []byte) {
+	st := &s.serial
+	p := st.newPacket(21 + len(d))
+	p[16] = 0xc1
+	p[17] = byte(len(d))
+	binary.BigEndian.PutUint16(p[19:21], st.innerSendSeq)
+	copy(p[21:], d)
+	st.sendTracked(p)
+	st.innerSendSeq++
+}
+
+// Sends 20ms of a sine wave in the requested audio format.
+func (s *simulator) sendAudioFrame() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	st := &s.audio
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if !s.streamsOpened || !st.ready {
+		return
+	}
+
+	frame := make([]byte, audioSampleRate*audioSampleBytes*int(simAudioFrameInterval/time.Millisecond)/1000)
+	for i := 0; i < len(frame); i += audioSampleBytes {
+		v := int16(simToneAmplitude * math.MaxInt16 * math.Sin(s.tonePhase))
+		binary.LittleEndian.PutUint16(frame[i:], uint16(v))
+		s.tonePhase += 2 * math.Pi * simToneFrequency / audioSampleRate
+	}
+	s.tonePhase = math.Mod(s.tonePhase, 2*math.Pi)
+
+	d := s.audioConverter.encode(frame)
+
+	// See audioStream.sendAudioPacket() for the packet format.
+	for len(d) > 0 {
+		n := len(d)
+		if n > maxAudioPacketDataLength {
+			n = maxAudioPacketDataLength
+		}
+		p := st.newPacket(24 + n)
+		p[16] = 0x80
+		binary.BigEndian.PutUint16(p[18:20], st.innerSendSeq)
+		binary.BigEndian.PutUint16(p[22:24], uint16(n))
+		copy(p[24:], d[:n])
+		st.sendTracked(p)
+		st.innerSendSeq++
+		d = d[n:]
+	}
+}
+
+// Sends idle packets on the control and serial streams. The login reply must be the first tracked packet on
+// the control stream, so nothing is sent before the login.
+func (s *simulator) sendIdle() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.loggedIn {
+		s.control.sendIdle()
+	}
+	if s.streamsOpened {
+		s.serial.sendIdle()
+	}
+}
+
+// Sends the "radio disconnected" status packet if the client streamed for longer than the configured time.
+func (s *simulator) disconnectIfNeeded() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conf.disconnectAfter == 0 || !s.streamsOpened || time.Since(s.streamsOpenedAt) < s.conf.disconnectAfter {
+		return
+	}
+
+	st := &s.control
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	log.Print(st.name + "/disconnecting the client")
+	// See controlStream.handleRead() for an example.
+	p := st.newPacket(80)
+	copy(p[16:24], []byte{0x00, 0x00, 0x00, 0x40, 0x02, 0x03, 0x00, 0x00})
+	copy(p[26:32], s.authID[:])
+	p[64] = 0x01
+	st.sendTracked(p)
+
+	s.loggedIn = false
+	s.streamsOpened = false
+}
+
+func (s *simulator) loop() {
+	pingTicker := time.NewTicker(simPingInterval)
+	idleTicker := time.NewTicker(simIdleInterval)
+	audioTicker := time.NewTicker(simAudioFrameInterval)
+	defer pingTicker.Stop()
+	defer idleTicker.Stop()
+	defer audioTicker.Stop()
+
+	for {
+		select {
+		case <-pingTicker.C:
+			s.control.sendPing()
+			s.serial.sendPing()
+			s.audio.sendPing()
+		case <-idleTicker.C:
+			s.sendIdle()
+			s.disconnectIfNeeded()
+		case <-audioTicker.C:
+			s.sendAudioFrame()
+		case <-s.deinitNeededChan:
+			s.deinitFinishedChan <- true
+			return
+		}
+	}
+}
+
+func (s *simulator) init(conf simulatorConfig) error {
+	s.conf = conf
+	s.civ.init(conf.civAddress)
+
+	if err := s.control.init(s, "control", conf.controlPort, conf.randSeed); err != nil {
+		return err
+	}
+	if err := s.serial.init(s, "serial", conf.serialPort, conf.randSeed+1); err != nil {
+		return err
+	}
+	if err := s.audio.init(s, "audio", conf.audioPort, conf.randSeed+2); err != nil {
+		return err
+	}
+
+	s.deinitNeededChan = make(chan bool)
+	s.deinitFinishedChan = make(chan bool)
+	go s.loop()
+	return nil
+}
+
+func (s *simulator) deinit() {
+	if s.deinitNeededChan != nil {
+		s.deinitNeededChan <- true
+		<-s.deinitFinishedChan
+	}
+	s.audio.deinit()
+	s.serial.deinit()
+	s.control.deinit()
+}
+
+// Runs the simulator until a signal is received. The listen address, the ports, the credentials and the
+// CI-V address are taken from the given config. Returns the exit code.
+func runSimulate(conf sessionConfig) int {
+	rand.Seed(time.Now().UnixNano())
+
+	simConf := simulatorConfig{
+		address:         conf.localAddress,
+		controlPort:     conf.controlStreamPort,
+		serialPort:      conf.serialStreamPort,
+		audioPort:       conf.audioStreamPort,
+		username:        conf.username,
+		password:        conf.password,
+		civAddress:      conf.civAddress,
+		lossPercent:     float64(simLossPercent),
+		reorderPercent:  float64(simReorderPercent),
+		latency:         simLatency,
+		disconnectAfter: simDisconnectAfter,
+		randSeed:        rand.Int63(),
+	}
+
+	var sim simulator
+	if err := sim.init(simConf); err != nil {
+		log.Error(err)
+		sim.deinit()
+		return 1
+	}
+	log.Print("simulating ", simRadioName, " with CI-V address 0x", fmt.Sprintf("%.2x", simConf.civAddress),
+		", loss: ", simConf.lossPercent, "%, reorder: ", simConf.reorderPercent, "%, latency: ", simConf.latency)
+
+	osSignal := make(chan os.Signal, 1)
+	signal.Notify(osSignal, os.Interrupt, syscall.SIGTERM)
+	<-osSignal
+	log.Print("sigterm received")
+
+	sim.deinit()
+	return 0
+}
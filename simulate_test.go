@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Only fatal errors are logged, and the status log does not touch the terminal.
+	quietLog = true
+	log.Init()
+	os.Exit(m.Run())
+}
+
+// Returns a free local UDP port.
+func getFreeUDPPort(t testing.TB) uint16 {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func startSimulator(t testing.TB, conf simulatorConfig) *simulator {
+	conf.address = "127.0.0.1"
+	conf.username = "beer"
+	conf.password = "beerbeer"
+	conf.civAddress = 0xa4
+	conf.randSeed = 1
+
+	sim := &simulator{}
+	if err := sim.init(conf); err != nil {
+		t.Fatal(err)
+	}
+	return sim
+}
+
+func newTestSessionConfig(t testing.TB, sim *simulator) sessionConfig {
+	audioFormat, err := newAudioFormat("pcm16", audioSampleRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := sessionConfig{
+		name:                   "test",
+		connectAddress:         "127.0.0.1",
+		username:               "beer",
+		password:               "beerbeer",
+		civAddress:             0xa4,
+		audioFormat:            audioFormat,
+		audioJitterBufferMin:   defaultAudioJitterBufferMin,
+		audioJitterBufferMax:   defaultAudioJitterBufferMax,
+		audioPLCMode:           audioPLCModeSilence,
+		noSoundcard:            true,
+		localAddress:           "127.0.0.1",
+		localControlStreamPort: getFreeUDPPort(t),
+		localSerialStreamPort:  getFreeUDPPort(t),
+		localAudioStreamPort:   getFreeUDPPort(t),
+	}
+	conf.controlStreamPort, conf.serialStreamPort, conf.audioStreamPort = sim.getPorts()
+	return conf
+}
+
+// Records the connection state changes of a session.
+type connStateRecorder struct {
+	mutex  sync.Mutex
+	states []connState
+}
+
+func (r *connStateRecorder) handleConnStateChange(e connStateEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.states = append(r.states, e.to)
+}
+
+func (r *connStateRecorder) get() []connState {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]connState{}, r.states...)
+}
+
+// Returns true if the recorded states contain the given states in the given order.
+func (r *connStateRecorder) contains(states ...connState) bool {
+	var i int
+	for _, s := range r.get() {
+		if i < len(states) && s == states[i] {
+			i++
+		}
+	}
+	return i == len(states)
+}
+
+// Waits until the recorded states contain the given states in the given order.
+func (r *connStateRecorder) waitFor(t testing.TB, timeout time.Duration, states ...connState) {
+	deadline := time.Now().Add(timeout)
+	for !r.contains(states...) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for states %v, got %v", states, r.get())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Starts a session connecting to the simulator. The returned function stops the session.
+func startTestSession(t testing.TB, conf sessionConfig) (*session, *connStateRecorder, func()) {
+	sess := &session{}
+	sess.init(conf)
+	rec := &connStateRecorder{}
+	sess.connState.subscribe(rec.handleConnStateChange)
+
+	stopChan := make(chan bool)
+	finishedChan := make(chan bool)
+	go func() {
+		sess.run(stopChan)
+		sess.deinit()
+		finishedChan <- true
+	}()
+
+	return sess, rec, func() {
+		close(stopChan)
+		<-finishedChan
+	}
+}
+
+func TestSimulatorConnect(t *testing.T) {
+	sim := startSimulator(t, simulatorConfig{})
+	defer sim.deinit()
+
+	_, rec, stop := startTestSession(t, newTestSessionConfig(t, sim))
+	defer stop()
+
+	rec.waitFor(t, 10*time.Second, connStateConnecting, connStateAuthenticating, connStateRequestingStreams,
+		connStateStreaming)
+}
+
+func TestSimulatorLoss(t *testing.T) {
+	sim := startSimulator(t, simulatorConfig{lossPercent: 2})
+	defer sim.deinit()
+
+	sess, rec, stop := startTestSession(t, newTestSessionConfig(t, sim))
+	defer stop()
+
+	rec.waitFor(t, 10*time.Second, connStateStreaming)
+
+	// Waiting for a few retransmit requests, and then for 50 more audio packets, so the requested packets
+	// would have been reported as lost by then if they were not retransmitted.
+	deadline := time.Now().Add(10 * time.Second)
+	var audioPacketsNeeded int
+	for {
+		var retransmitRequests int
+		for _, name := range []string{"serial", "audio"} {
+			r := sess.netstat.getStream(name).getReport()
+			retransmitRequests += r.Total.RetransmitRequestsSent
+			if r.Total.Lost != 0 {
+				t.Fatal(name, " stream lost ", r.Total.Lost, " packets")
+			}
+		}
+		audioPackets := sess.netstat.getStream("audio").getReport().Total.ReceivedPackets
+		if audioPacketsNeeded == 0 && retransmitRequests >= 3 {
+			audioPacketsNeeded = audioPackets + 50
+		}
+		if audioPacketsNeeded != 0 && audioPackets >= audioPacketsNeeded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout, ", retransmitRequests, " retransmit requests sent, ", audioPackets,
+				" audio packets received")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSimulatorDisconnect(t *testing.T) {
+	sim := startSimulator(t, simulatorConfig{disconnectAfter: 2 * time.Second})
+	defer sim.deinit()
+
+	_, rec, stop := startTestSession(t, newTestSessionConfig(t, sim))
+	defer stop()
+
+	rec.waitFor(t, 20*time.Second, connStateStreaming, connStateBackoff, connStateConnecting, connStateStreaming)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// The sample formats which can be used for the virtual sound card. The names are the PulseAudio names.
+var soundcardSampleFormats = []struct {
+	name  string
+	bytes int
+}{
+	{name: "u8", bytes: 1},
+	{name: "s16le", bytes: 2},
+	{name: "s24le", bytes: 3},
+	{name: "s32le", bytes: 4},
+	{name: "float32le", bytes: 4},
+}
+
+const defaultSoundcardSampleFormat = "s16le"
+
+// Cutoff frequency of the lowpass filter which is used before decimating the sound card's audio.
+const soundcardDecoderLowpass = 20000 // Hz
+
+// The sound card side of the audio pipeline, and also the audio sent to audio.play, is always 48kHz,
+// s16le, mono. The virtual sound card can expose a different format to the applications.
+type soundcardFormat struct {
+	sampleFormat string
+	sampleBytes  int
+	sampleRate   int
+}
+
+func getSoundcardSampleFormatNames() (res []string) {
+	for _, f := range soundcardSampleFormats {
+		res = append(res, f.name)
+	}
+	return
+}
+
+func newSoundcardFormat(sampleFormat string, sampleRate int) (f soundcardFormat, err error) {
+	for _, sf := range soundcardSampleFormats {
+		if sf.name == sampleFormat {
+			f.sampleFormat = sf.name
+			f.sampleBytes = sf.bytes
+		}
+	}
+	if f.sampleFormat == "" {
+		return f, fmt.Errorf("unknown sample format %s, available formats: %s", sampleFormat,
+			strings.Join(getSoundcardSampleFormatNames(), ", "))
+	}
+	if sampleRate < 8000 || sampleRate > 192000 {
+		return f, fmt.Errorf("unsupported virtual sound card sample rate %d, it must be between 8000 and 192000",
+			sampleRate)
+	}
+	f.sampleRate = sampleRate
+	return
+}
+
+// Returns true if no conversion is needed between the audio stream and the sound card.
+func (f soundcardFormat) isNative() bool {
+	return f == nativeSoundcardFormat
+}
+
+// Returns the length of audio with the given duration in this format.
+func (f soundcardFormat) getDataLength(d time.Duration) int {
+	return int(time.Duration(f.sampleRate*f.sampleBytes) * d / time.Second)
+}
+
+func (f soundcardFormat) String() string {
+	return fmt.Sprint(f.sampleFormat, " ", f.sampleRate, "Hz")
+}
+
+// Converts between 48kHz, s16le, mono and a sound card format. It keeps state between the calls to keep
+// the resampling continuous, so each direction needs its own converter. Resampling is done by linear
+// interpolation, which is good enough as the radio's audio is bandlimited by its IF filters. The sound
+// card's audio is not bandlimited, so it's lowpass filtered before decimation to avoid aliasing.
+type soundcardConverter struct {
+	format  soundcardFormat
+	inRate  int
+	outRate int
+	decoder bool // True if the output is 48kHz, s16le, mono.
+
+	// Used by the decoder if the sound card's sample rate is higher than 48kHz.
+	lowpass []biquadFilter
+
+	// The position of the next output sample after lastSample, in 1/outRate units of the input sample
+	// interval.
+	pos        int
+	lastSample int16
+
+	// The result is returned in this buffer, it's reused between the calls.
+	buf []byte
+}
+
+// Returns a converter which converts the audio coming from the sound card to 48kHz, s16le, mono.
+func newSoundcardDecoder(f soundcardFormat) *soundcardConverter {
+	c := &soundcardConverter{format: f, inRate: f.sampleRate, outRate: audioSampleRate, decoder: true}
+	if f.sampleRate > audioSampleRate { // 4th order, 24dB/octave.
+		c.lowpass = []biquadFilter{
+			newLowpassFilterForRate(soundcardDecoderLowpass, butterworthQ, f.sampleRate),
+			newLowpassFilterForRate(soundcardDecoderLowpass, butterworthQ, f.sampleRate),
+		}
+	}
+	return c
+}
+
+// Returns a converter which converts 48kHz, s16le, mono audio to the sound card format.
+func newSoundcardEncoder(f soundcardFormat) *soundcardConverter {
+	return &soundcardConverter{format: f, inRate: audioSampleRate, outRate: f.sampleRate}
+}
+
+func (c *soundcardConverter) output(s int16) {
+	if c.decoder {
+		c.buf = append(c.buf, byte(s), byte(s>>8))
+	} else {
+		c.buf = appendSoundcardSample(c.format, c.buf, s)
+	}
+}
+
+// Appends the resampled version of sample to the output.
+func (c *soundcardConverter) resample(sample int16) {
+	if c.inRate == c.outRate {
+		c.output(sample)
+		return
+	}
+	for ; c.pos < c.outRate; c.pos += c.inRate {
+		c.output(int16(int(c.lastSample) + (int(sample)-int(c.lastSample))*c.pos/c.outRate))
+	}
+	c.pos -= c.outRate
+	c.lastSample = sample
+}
+
+// Returns the 48kHz, s16le, mono version of d which is in the sound card format. The result is only
+// valid until the next call.
+func (c *soundcardConverter) decode(d []byte) []byte {
+	c.buf = c.buf[:0]
+	for i := 0; i+c.format.sampleBytes <= len(d); i += c.format.sampleBytes {
+		sample := decodeSoundcardSample(c.format, d[i:])
+		if c.lowpass != nil {
+			sample = c.filter(sample)
+		}
+		c.resample(sample)
+	}
+	return c.buf
+}
+
+func (c *soundcardConverter) filter(sample int16) int16 {
+	v := float64(sample) / 32768
+	for i := range c.lowpass {
+		v = c.lowpass[i].process(v)
+	}
+	return floatToSample(v)
+}
+
+// Returns d (which is 48kHz, s16le, mono) converted to the sound card format. The result is only valid
+// until the next call.
+func (c *soundcardConverter) encode(d []byte) []byte {
+	c.buf = c.buf[:0]
+	for i := 0; i+audioSampleBytes <= len(d); i += audioSampleBytes {
+		c.resample(int16(binary.LittleEndian.Uint16(d[i:])))
+	}
+	return c.buf
+}
+
+func decodeSoundcardSample(f soundcardFormat, d []byte) int16 {
+	switch f.sampleFormat {
+	case "u8":
+		return (int16(d[0]) - 128) << 8
+	case "s24le":
+		return int16(binary.LittleEndian.Uint16(d[1:]))
+	case "s32le":
+		return int16(binary.LittleEndian.Uint16(d[2:]))
+	case "float32le":
+		v := math.Float32frombits(binary.LittleEndian.Uint32(d)) * 32768
+		if v > math.MaxInt16 {
+			return math.MaxInt16
+		}
+		if v < math.MinInt16 {
+			return math.MinInt16
+		}
+		return int16(v)
+	default:
+		return int16(binary.LittleEndian.Uint16(d))
+	}
+}
+
+func appendSoundcardSample(f soundcardFormat, b []byte, s int16) []byte {
+	switch f.sampleFormat {
+	case "u8":
+		return append(b, byte(int(s>>8)+128))
+	case "s24le":
+		return append(b, 0, byte(s), byte(s>>8))
+	case "s32le":
+		return append(b, 0, 0, byte(s), byte(s>>8))
+	case "float32le":
+		v := math.Float32bits(float32(s) / 32768)
+		return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	default:
+		return append(b, byte(s), byte(s>>8))
+	}
+}
+
+var nativeSoundcardFormat = soundcardFormat{
+	sampleFormat: defaultSoundcardSampleFormat,
+	sampleBytes:  audioSampleBytes,
+	sampleRate:   audioSampleRate,
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const statsFileWriteInterval = 10 * time.Second
+
+type statsFileSession struct {
+	Name         string                `json:"name"`
+	Streams      []netstatStreamReport `json:"streams"`
+	RxAudioLevel audioLevelReport      `json:"rx_audio_level"`
+	TxAudioLevel audioLevelReport      `json:"tx_audio_level"`
+}
+
+type statsFileContent struct {
+	Time                     time.Time          `json:"time"`
+	HistoryIntervalSec       int                `json:"history_interval_sec"`
+	JitterHistogramBucketsMs []int              `json:"jitter_histogram_buckets_ms"`
+	Sessions                 []statsFileSession `json:"sessions"`
+}
+
+// Periodically dumps the per-stream network statistics of all sessions to a JSON file.
+type statsFileStruct struct {
+	path string
+
+	deinitNeededChan   chan bool
+	deinitFinishedChan chan bool
+}
+
+var statsFile statsFileStruct
+
+func (s *statsFileStruct) write() error {
+	c := statsFileContent{
+		Time:                     time.Now(),
+		HistoryIntervalSec:       int(netstatHistoryInterval / time.Second),
+		JitterHistogramBucketsMs: netstatJitterBucketsMs,
+	}
+
+	sessions.mutex.Lock()
+	list := sessions.list
+	sessions.mutex.Unlock()
+
+	for _, sess := range list {
+		c.Sessions = append(c.Sessions, statsFileSession{
+			Name:         sess.conf.name,
+			Streams:      sess.netstat.getStreamReports(),
+			RxAudioLevel: sess.rxAudioLevel.get(),
+			TxAudioLevel: sess.txAudioLevel.get(),
+		})
+	}
+
+	d, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Writing to a temp file first, so readers of the stats file never see a partially written file.
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, append(d, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+func (s *statsFileStruct) loop() {
+	ticker := time.NewTicker(statsFileWriteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.write(); err != nil {
+				log.Error("can't write stats file: ", err)
+			}
+		case <-s.deinitNeededChan:
+			s.deinitFinishedChan <- true
+			return
+		}
+	}
+}
+
+func (s *statsFileStruct) init(path string) error {
+	s.path = path
+	if err := s.write(); err != nil {
+		return err
+	}
+
+	s.deinitNeededChan = make(chan bool)
+	s.deinitFinishedChan = make(chan bool)
+	go s.loop()
+	return nil
+}
+
+func (s *statsFileStruct) deinit() {
+	if s.deinitNeededChan == nil {
+		return
+	}
+
+	s.deinitNeededChan <- true
+	<-s.deinitFinishedChan
+
+	if err := s.write(); err != nil {
+		log.Error("can't write stats file: ", err)
+	}
+}
@@ -42,13 +42,17 @@ type statusLogData struct {
 
 	startTime time.Time
 	rttStr    string
+	jbuf      time.Duration
+	connState connState
 
 	audioMonOn    bool
 	audioRecOn    bool
 	audioStateStr string
+	wavRecording  bool
 }
 
 type statusLogStruct struct {
+	sess             *session
 	ticker           *time.Ticker
 	stopChan         chan bool
 	stopFinishedChan chan bool
@@ -71,13 +75,12 @@ type statusLogStruct struct {
 		}
 
 		ovf string
+		wav string
 	}
 
 	data *statusLogData
 }
 
-var statusLog statusLogStruct
-
 func (s *statusLogStruct) reportRTTLatency(l time.Duration) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -88,6 +91,27 @@ func (s *statusLogStruct) reportRTTLatency(l time.Duration) {
 	s.data.rttStr = fmt.Sprint(l.Milliseconds())
 }
 
+func (s *statusLogStruct) reportJitterBuffer(depth time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data == nil {
+		return
+	}
+	// Only storing the value here, as this is called for every audio packet.
+	s.data.jbuf = depth
+}
+
+func (s *statusLogStruct) handleConnStateChange(e connStateEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data == nil {
+		return
+	}
+	s.data.connState = e.to
+}
+
 func (s *statusLogStruct) updateAudioStateStr() {
 	if s.data.audioRecOn {
 		s.data.audioStateStr = s.preGenerated.audioStateStr.rec
@@ -120,6 +144,16 @@ func (s *statusLogStruct) reportAudioRec(enabled bool) {
 	s.updateAudioStateStr()
 }
 
+func (s *statusLogStruct) reportWavRecording(enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data == nil {
+		return
+	}
+	s.data.wavRecording = enabled
+}
+
 func (s *statusLogStruct) reportFrequency(f uint) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -338,6 +372,10 @@ func (s *statusLogStruct) print() {
 	defer s.mutex.Unlock()
 
 	if s.isRealtimeInternal() {
+		// Only the active session is displayed on the realtime status bar.
+		if !s.sess.isActive() {
+			return
+		}
 		s.clearInternal()
 		fmt.Println(s.data.line1)
 		s.clearInternal()
@@ -345,7 +383,7 @@ func (s *statusLogStruct) print() {
 		s.clearInternal()
 		fmt.Printf(s.data.line3+"%c[1A%c[1A", 27, 27)
 	} else {
-		log.PrintStatusLog(s.data.line3)
+		log.PrintStatusLog(s.sess.logPrefix(), s.data.line3)
 	}
 }
 
@@ -404,7 +442,25 @@ func (s *statusLogStruct) update() {
 	if s.data.sql != "" {
 		sqlStr = " sql " + s.data.sql
 	}
-	s.data.line1 = fmt.Sprint(s.data.audioStateStr, filterStr, preampStr, agcStr, nrStr, rfGainStr, sqlStr)
+	var rxDSPStr string
+	if str := s.sess.rxDSP.getStatusStr(); str != "" {
+		rxDSPStr = " dsp" + str
+	}
+	var wavStr string
+	if s.data.wavRecording {
+		wavStr = " " + s.preGenerated.wav
+	}
+	rxLevel := s.sess.rxAudioLevel.get()
+	txLevel := s.sess.txAudioLevel.get()
+	levelStr := fmt.Sprint(" rx ", rxLevel.getBargraph(), " tx ", txLevel.getBargraph())
+	if rxLevel.Clips > 0 || txLevel.Clips > 0 {
+		levelStr += " clip " + s.preGenerated.lostColor.Sprint(" ", rxLevel.Clips, "/", txLevel.Clips, " ")
+	}
+	s.data.line1 = fmt.Sprint(s.data.audioStateStr, wavStr, filterStr, preampStr, agcStr, nrStr, rxDSPStr, rfGainStr,
+		sqlStr, levelStr)
+	if sessions.count() > 1 {
+		s.data.line1 = fmt.Sprint(s.sess.conf.name, " ", s.data.line1)
+	}
 
 	var stateStr string
 	if s.data.tune {
@@ -454,7 +510,7 @@ func (s *statusLogStruct) update() {
 	s.data.line2 = fmt.Sprint(stateStr, " ", fmt.Sprintf("%.6f", float64(s.data.frequency)/1000000),
 		tsStr, modeStr, splitStr, vdStr, txPowerStr, swrStr)
 
-	up, down, lost, retransmits := netstat.get()
+	up, down, lost, retransmits, concealed := s.sess.netstat.get()
 	lostStr := "0"
 	if lost > 0 {
 		lostStr = s.preGenerated.lostColor.Sprint(" ", lost, " ")
@@ -463,11 +519,23 @@ func (s *statusLogStruct) update() {
 	if retransmits > 0 {
 		retransmitsStr = s.preGenerated.retransmitsColor.Sprint(" ", retransmits, " ")
 	}
+	jbufStr := "?"
+	if s.data.jbuf > 0 {
+		jbufStr = fmt.Sprint(s.data.jbuf.Milliseconds())
+	}
+	concealedStr := "0"
+	if concealed > 0 {
+		concealedStr = s.preGenerated.lostColor.Sprint(" ", concealed.Milliseconds(), " ")
+	}
 
 	s.data.line3 = fmt.Sprint("up ", s.padLeft(fmt.Sprint(time.Since(s.data.startTime).Round(time.Second)), 6),
-		" rtt ", s.padLeft(s.data.rttStr, 3), "ms up ",
-		s.padLeft(netstat.formatByteCount(up), 8), "/s down ",
-		s.padLeft(netstat.formatByteCount(down), 8), "/s retx ", retransmitsStr, "/1m lost ", lostStr, "/1m\r")
+		" rtt ", s.padLeft(s.data.rttStr, 3), "ms jbuf ", s.padLeft(jbufStr, 3), "ms up ",
+		s.padLeft(s.sess.netstat.formatByteCount(up), 8), "/s down ",
+		s.padLeft(s.sess.netstat.formatByteCount(down), 8), "/s retx ", retransmitsStr, "/1m lost ", lostStr, "/1m plc ", concealedStr, "ms/1m")
+	if s.data.connState != connStateStreaming {
+		s.data.line3 += " " + s.data.connState.String()
+	}
+	s.data.line3 += "\r"
 
 	if s.isRealtimeInternal() {
 		t := time.Now().Format("2006-01-02T15:04:05.000Z0700")
@@ -519,6 +587,7 @@ func (s *statusLogStruct) startPeriodicPrint() {
 		startTime:     time.Now(),
 		rttStr:        "?",
 		audioStateStr: s.preGenerated.audioStateStr.off,
+		connState:     s.sess.connState.get(),
 	}
 
 	s.stopChan = make(chan bool)
@@ -531,13 +600,17 @@ func (s *statusLogStruct) stopPeriodicPrint() {
 	if !s.isActive() {
 		return
 	}
-	s.ticker.Stop()
-	s.ticker = nil
 
+	// The loop has to be stopped first, as it reads the ticker.
 	s.stopChan <- true
 	<-s.stopFinishedChan
 
-	if s.isRealtimeInternal() {
+	s.mutex.Lock()
+	s.ticker.Stop()
+	s.ticker = nil
+	s.mutex.Unlock()
+
+	if s.isRealtimeInternal() && s.sess.isActive() {
 		s.clearInternal()
 		fmt.Println()
 		s.clearInternal()
@@ -547,16 +620,26 @@ func (s *statusLogStruct) stopPeriodicPrint() {
 	}
 }
 
+var statusLogInitOnce sync.Once
+
+// Sets the status log interval and takes over the terminal, only once, as these are shared by all
+// sessions. Sessions call this too, for the modes which do not call it on startup.
+func initStatusLog() {
+	statusLogInitOnce.Do(func() {
+		if quietLog || (!isatty.IsTerminal(os.Stdout.Fd()) && statusLogInterval < time.Second) {
+			statusLogInterval = time.Second
+		} else {
+			keyboard.init()
+		}
+	})
+}
+
 func (s *statusLogStruct) initIfNeeded() {
 	if s.data != nil { // Already initialized?
 		return
 	}
 
-	if quietLog || (!isatty.IsTerminal(os.Stdout.Fd()) && statusLogInterval < time.Second) {
-		statusLogInterval = time.Second
-	} else {
-		keyboard.init()
-	}
+	initStatusLog()
 
 	c := color.New(color.FgHiWhite)
 	c.Add(color.BgWhite)
@@ -575,6 +658,7 @@ func (s *statusLogStruct) initIfNeeded() {
 	c = color.New(color.FgHiWhite)
 	c.Add(color.BgRed)
 	s.preGenerated.ovf = c.Sprint(" OVF ")
+	s.preGenerated.wav = c.Sprint(" WAV ")
 
 	s.preGenerated.retransmitsColor = color.New(color.FgHiWhite)
 	s.preGenerated.retransmitsColor.Add(color.BgYellow)
@@ -13,11 +13,14 @@ const expectTimeoutDuration = time.Second
 const maxRetransmitRequestPacketCount = 10
 
 type streamCommon struct {
+	sess                    *session
 	name                    string
+	kind                    string // control, serial, audio or discover, the name also contains the session's prefix.
 	conn                    *net.UDPConn
 	localSID                uint32
 	remoteSID               uint32
 	gotRemoteSID            bool
+	stats                   *netstatStream
 	readChan                chan []byte
 	readerCloseNeededChan   chan bool
 	readerCloseFinishedChan chan bool
@@ -28,36 +31,53 @@ type streamCommon struct {
 
 func (s *streamCommon) send(d []byte) error {
 	if _, err := s.conn.Write(d); err != nil {
-		return err
+		return wrapNetError(err)
 	}
-	netstat.add(len(d), 0)
+	capture.write(s, d, true)
+	s.stats.add(len(d), 0)
 	return nil
 }
 
+// Returns a buffer from pktBufPool.
 func (s *streamCommon) read() ([]byte, error) {
-	b := make([]byte, 1500)
-	n, _, err := s.conn.ReadFromUDP(b)
-	if err == nil {
-		netstat.add(0, n)
+	b := pktBufPool.get(pktBufSize)
+	// The socket is connected, so only packets from the radio are received.
+	n, err := s.conn.Read(b)
+	if err != nil {
+		pktBufPool.put(b)
+		return nil, wrapNetError(err)
+	}
+	capture.write(s, b[:n], false)
+	s.stats.add(0, n)
+	return b[:n], nil
+}
+
+// Handles the pkt7 and pkt0 packets which are common for all streams. Returns false if the packet should
+// not be sent further downstream.
+func (s *streamCommon) handleCommonPacket(r []byte) bool {
+	if s.pkt7.isPkt7(r) {
+		if err := s.pkt7.handle(s, r); err != nil {
+			s.sess.reportError(err)
+		}
+		// Don't let pkt7 packets further downstream.
+		return false
 	}
-	return b[:n], err
+	if s.pkt0.isPkt0(r) {
+		if err := s.pkt0.handle(s, r); err != nil {
+			s.sess.reportError(err)
+		}
+	}
+	return true
 }
 
 func (s *streamCommon) reader() {
 	for {
 		r, err := s.read()
 		if err != nil {
-			reportError(err)
-		} else if s.pkt7.isPkt7(r) {
-			if err := s.pkt7.handle(s, r); err != nil {
-				reportError(err)
-			}
-			// Don't let pkt7 packets further downstream.
+			s.sess.reportError(err)
+		} else if !s.handleCommonPacket(r) {
+			pktBufPool.put(r)
 			continue
-		} else if s.pkt0.isPkt0(r) {
-			if err := s.pkt0.handle(s, r); err != nil {
-				reportError(err)
-			}
 		}
 
 		select {
@@ -82,6 +102,7 @@ func (s *streamCommon) tryReceivePacket(timeout time.Duration, packetLength, mat
 		if len(r) == packetLength && bytes.Equal(r[matchStartByte:len(b)+matchStartByte], b) {
 			break
 		}
+		pktBufPool.put(r)
 	}
 	return r
 }
@@ -89,7 +110,7 @@ func (s *streamCommon) tryReceivePacket(timeout time.Duration, packetLength, mat
 func (s *streamCommon) expect(packetLength int, b []byte) ([]byte, error) {
 	r := s.tryReceivePacket(expectTimeoutDuration, packetLength, 0, b)
 	if r == nil {
-		return nil, errors.New(s.name + "/expect timeout - the server did not answer, check if it's running")
+		return nil, newConnError(connErrorTimeout, s.name, "/expect timeout - the server did not answer, check if it's running")
 	}
 	return r, nil
 }
@@ -182,13 +203,13 @@ func (s *streamCommon) requestRetransmit(r seqNumRange) error {
 
 	if diff == 0 {
 		log.Debug(s.name+"/requesting pkt #", r[0], " retransmit")
-		netstat.reportRetransmit(diff)
+		s.stats.reportRetransmitRequestSent(diff + 1)
 		if err := s.sendRetransmitRequest(uint16(r[0])); err != nil {
 			return err
 		}
 	} else {
 		log.Debug(s.name+"/requesting pkt #", r[0], "-#", r[1], " retransmit")
-		netstat.reportRetransmit(diff)
+		s.stats.reportRetransmitRequestSent(diff + 1)
 		if err := s.sendRetransmitRequestForRanges([]seqNumRange{r}); err != nil {
 			return err
 		}
@@ -223,16 +244,29 @@ func (s *streamCommon) start() error {
 	return s.waitForPkt6Answer()
 }
 
-func (s *streamCommon) init(name string, portNumber int) error {
-	s.name = name
-	hostPort := fmt.Sprint(connectAddress, ":", portNumber)
+// If localPort is 0, then the local port will be the same as the remote port. If multiple sessions are
+// running, then they can't bind to the same local port, so the OS chooses one in this case.
+func (s *streamCommon) init(sess *session, name string, remotePort, localPort uint16) error {
+	s.sess = sess
+	s.name = sess.logPrefix() + name
+	s.kind = name
+	s.stats = sess.netstat.getStream(name)
+	hostPort := fmt.Sprint(s.sess.conf.connectAddress, ":", remotePort)
 	log.Print(s.name+"/connecting to ", hostPort)
 	raddr, err := net.ResolveUDPAddr("udp", hostPort)
 	if err != nil {
 		return err
 	}
 
-	s.conn, err = net.DialUDP("udp", &net.UDPAddr{Port: portNumber}, raddr)
+	if localPort == 0 && sessions.count() < 2 {
+		localPort = remotePort
+	}
+	localHostPort := fmt.Sprint(s.sess.conf.localAddress, ":", localPort)
+	bindAddr, err := net.ResolveUDPAddr("udp", localHostPort)
+	if err != nil {
+		return err
+	}
+	s.conn, err = net.DialUDP("udp", bindAddr, raddr)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+// Inits the stream without the reader goroutine, and returns a socket connected to it which can be used to
+// send packets as the radio. The stream's socket is not connected, so it can only be used for reading.
+func initTestStream(tb testing.TB, s *streamCommon, sess *session, name string) (radio *net.UDPConn) {
+	s.sess = sess
+	s.name = name
+	s.kind = name
+	s.stats = sess.netstat.getStream(name)
+	var err error
+	if s.conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}); err != nil {
+		tb.Fatal(err)
+	}
+	if radio, err = net.DialUDP("udp", nil, s.conn.LocalAddr().(*net.UDPAddr)); err != nil {
+		tb.Fatal(err)
+	}
+	return radio
+}
+
+func BenchmarkStreamCommonRead(b *testing.B) {
+	s := &streamCommon{}
+	radio := initTestStream(b, s, &session{}, "audio")
+	defer s.conn.Close()
+	defer radio.Close()
+
+	p := make([]byte, 24+maxAudioPacketDataLength)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := radio.Write(p); err != nil {
+			b.Fatal(err)
+		}
+		d, err := s.read()
+		if err != nil {
+			b.Fatal(err)
+		}
+		pktBufPool.put(d)
+	}
+}
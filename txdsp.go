@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const defaultTxDSPModes = "LSB,USB,AM,FM"
+const defaultTxHighpass = 100      // Hz
+const defaultTxLowpass = 2900      // Hz
+const defaultTxCompThreshold = -20 // dBFS
+const defaultTxCompRatio = 3
+const defaultTxCompMakeup = 6 // dB
+const defaultTxLimit = -1     // dBFS
+
+type txEQBand struct {
+	freq   float64
+	gainDB float64
+	q      float64
+}
+
+type txDSPConfig struct {
+	enabled  bool
+	modes    []string // The chain is only used in these modes, data modes have a -D suffix.
+	gainDB   float64
+	highpass float64 // Hz, 0 disables.
+	lowpass  float64 // Hz, 0 disables.
+	eq       []txEQBand
+
+	compThresholdDB float64 // 0 disables the compressor.
+	compRatio       float64
+	compMakeupDB    float64
+
+	limitDB float64
+}
+
+// Parses a comma separated list of freq:gain[:q] EQ bands, like 300:-3,2000:4:1.5
+func parseTxEQBands(s string) (res []txEQBand, err error) {
+	if s == "" {
+		return
+	}
+	for _, b := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(b), ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid eq band %s, the format is freq:gain[:q]", b)
+		}
+		band := txEQBand{q: 1}
+		values := []*float64{&band.freq, &band.gainDB, &band.q}
+		for i, f := range fields {
+			if *values[i], err = strconv.ParseFloat(f, 64); err != nil {
+				return nil, fmt.Errorf("invalid eq band %s, the format is freq:gain[:q]", b)
+			}
+		}
+		if band.freq <= 0 || band.freq >= audioSampleRate/2 || band.q <= 0 {
+			return nil, fmt.Errorf("invalid eq band %s", b)
+		}
+		res = append(res, band)
+	}
+	return
+}
+
+// Processes the TX audio before it's sent to the radio: gain, highpass and lowpass filters, EQ,
+// compressor and limiter, in this order.
+type txDSPStruct struct {
+	sess *session
+
+	active  bool
+	ptt     bool // The PTT state when the previous frame was processed.
+	gain    float64
+	filters []biquadFilter
+	comp    compressor
+	limiter limiter
+}
+
+func (s *txDSPStruct) init() {
+	c := &s.sess.conf.txDSP
+	s.gain = dbToLinear(c.gainDB)
+
+	s.filters = nil
+	if c.highpass > 0 { // 4th order, 24dB/octave.
+		s.filters = append(s.filters, newHighpassFilter(c.highpass, butterworthQ),
+			newHighpassFilter(c.highpass, butterworthQ))
+	}
+	if c.lowpass > 0 {
+		s.filters = append(s.filters, newLowpassFilter(c.lowpass, butterworthQ),
+			newLowpassFilter(c.lowpass, butterworthQ))
+	}
+	for _, b := range c.eq {
+		s.filters = append(s.filters, newPeakingFilter(b.freq, b.q, b.gainDB))
+	}
+
+	s.comp = newCompressor(c.compThresholdDB, c.compRatio, c.compMakeupDB)
+	s.limiter = newLimiter(c.limitDB)
+}
+
+// Returns true if the chain should be used in the current operating mode of the radio.
+func (s *txDSPStruct) isEnabledForCurrentMode() bool {
+	c := &s.sess.conf.txDSP
+	if !c.enabled {
+		return false
+	}
+
+	s.sess.civControl.state.mutex.Lock()
+	mode := civOperatingModes[s.sess.civControl.state.operatingModeIdx].name
+	if s.sess.civControl.state.dataMode {
+		mode += "-D"
+	}
+	s.sess.civControl.state.mutex.Unlock()
+
+	for _, m := range c.modes {
+		if strings.EqualFold(m, mode) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if PTT was turned off since the previous call.
+func (s *txDSPStruct) checkTxEnd() bool {
+	s.sess.civControl.state.mutex.Lock()
+	ptt := s.sess.civControl.state.ptt
+	s.sess.civControl.state.mutex.Unlock()
+
+	ended := s.ptt && !ptt
+	s.ptt = ptt
+	return ended
+}
+
+func (s *txDSPStruct) processSample(x float64) float64 {
+	x *= s.gain
+	for i := range s.filters {
+		x = s.filters[i].process(x)
+	}
+	if s.sess.conf.txDSP.compThresholdDB < 0 {
+		x = s.comp.process(x)
+	}
+	return s.limiter.process(x)
+}
+
+// Processes a frame of 48kHz, s16le, mono audio in place.
+func (s *txDSPStruct) process(d []byte) {
+	if !s.sess.conf.txDSP.enabled {
+		return
+	}
+
+	txEnded := s.checkTxEnd()
+	if !s.isEnabledForCurrentMode() {
+		if s.active {
+			log.Print("bypassed in the current mode")
+			s.active = false
+		}
+		return
+	}
+	if !s.active {
+		log.Print("active")
+		s.init()
+		s.active = true
+	} else if txEnded {
+		// Starting with a clean state, so the previous transmission does not affect the next one.
+		s.init()
+	}
+	processAudioSamples(d, s.processSample)
+}
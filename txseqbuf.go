@@ -1,6 +1,9 @@
 package main
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 // This value is sent to the transceiver and - according to my observations - it will use
 // this as it's RX buf length. Note that if it is set to larger than 500-600ms then audio TX
@@ -14,27 +17,41 @@ type txSeqBufEntry struct {
 }
 
 type txSeqBufStruct struct {
+	mutex   sync.Mutex
 	entries []txSeqBufEntry
 }
 
+// Stores a copy of p, so the caller keeps the ownership of p.
 func (s *txSeqBufStruct) add(seq seqNum, p []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	d := pktBufPool.get(len(p))
+	copy(d, p)
 	s.entries = append(s.entries, txSeqBufEntry{
 		seq:     seq,
-		data:    p,
+		data:    d,
 		addedAt: time.Now(),
 	})
 	s.purgeOldEntries()
 }
 
+// Expects the mutex to be locked.
 func (s *txSeqBufStruct) purgeOldEntries() {
 	// We keep much more entries than the specified length of the TX seqbuf, so we can serve
 	// any requests coming from the server.
 	for len(s.entries) > 0 && time.Since(s.entries[0].addedAt) > txSeqBufLength*10 {
+		pktBufPool.put(s.entries[0].data)
+		s.entries[0] = txSeqBufEntry{}
 		s.entries = s.entries[1:]
 	}
 }
 
+// Returns a copy of the entry's data in a buffer from pktBufPool, or nil if the entry is not found.
 func (s *txSeqBufStruct) get(seq seqNum) (d []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	if len(s.entries) == 0 {
 		return nil
 	}
@@ -42,7 +59,7 @@ func (s *txSeqBufStruct) get(seq seqNum) (d []byte) {
 	// Searching from backwards, as we expect most queries for latest entries.
 	for i := len(s.entries) - 1; i >= 0; i-- {
 		if s.entries[i].seq == seq {
-			d = make([]byte, len(s.entries[i].data))
+			d = pktBufPool.get(len(s.entries[i].data))
 			copy(d, s.entries[i].data)
 			break
 		}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transmits prerecorded messages. The messages are WAV files, or raw 48kHz, s16le, mono PCM files.
+type voiceKeyerStruct struct {
+	sess *session
+
+	mutex        sync.Mutex
+	stopChan     chan bool
+	finishedChan chan bool
+
+	// True while a message is sent to the radio. The sound cards' audio is dropped meanwhile, so it does
+	// not get mixed into the message.
+	sendingMutex sync.Mutex
+	sending      bool
+}
+
+// Returns the contents of a WAV file's data chunk and its format. The channels are mixed down to mono.
+func parseWAV(d []byte) (data []byte, f soundcardFormat, err error) {
+	if len(d) < 12 || string(d[:4]) != "RIFF" || string(d[8:12]) != "WAVE" {
+		return nil, f, errors.New("not a wav file")
+	}
+
+	var gotFmt bool
+	var channels int
+	var bits int
+	var sampleFormat string
+	d = d[12:]
+	for len(d) >= 8 {
+		id := string(d[:4])
+		l := int(binary.LittleEndian.Uint32(d[4:8]))
+		d = d[8:]
+		if l > len(d) {
+			l = len(d)
+		}
+
+		switch id {
+		case "fmt ":
+			if l < 16 {
+				return nil, f, errors.New("invalid wav fmt chunk")
+			}
+			formatTag := binary.LittleEndian.Uint16(d[0:2])
+			channels = int(binary.LittleEndian.Uint16(d[2:4]))
+			bits = int(binary.LittleEndian.Uint16(d[14:16]))
+			if formatTag == 0xfffe && l >= 26 { // WAVE_FORMAT_EXTENSIBLE, the format is in the sub format GUID.
+				formatTag = binary.LittleEndian.Uint16(d[24:26])
+			}
+			switch {
+			case formatTag == 1 && bits == 8:
+				sampleFormat = "u8"
+			case formatTag == 1 && bits == 16:
+				sampleFormat = "s16le"
+			case formatTag == 1 && bits == 24:
+				sampleFormat = "s24le"
+			case formatTag == 1 && bits == 32:
+				sampleFormat = "s32le"
+			case formatTag == 3 && bits == 32:
+				sampleFormat = "float32le"
+			default:
+				return nil, f, fmt.Errorf("unsupported wav format %d with %d bits", formatTag, bits)
+			}
+			if channels < 1 {
+				return nil, f, errors.New("invalid wav channel count")
+			}
+			if f, err = newSoundcardFormat(sampleFormat, int(binary.LittleEndian.Uint32(d[4:8]))); err != nil {
+				return nil, f, err
+			}
+			gotFmt = true
+		case "data":
+			if !gotFmt {
+				return nil, f, errors.New("wav data chunk before fmt chunk")
+			}
+			data = d[:l]
+			if channels == 1 {
+				return data, f, nil
+			}
+
+			// Mixing down to mono, the result is s16le.
+			frameSize := f.sampleBytes * channels
+			mono := make([]byte, 0, len(data)/frameSize*audioSampleBytes)
+			for i := 0; i+frameSize <= len(data); i += frameSize {
+				var sum int
+				for ch := 0; ch < channels; ch++ {
+					sum += int(decodeSoundcardSample(f, data[i+ch*f.sampleBytes:]))
+				}
+				mono = append(mono, byte(sum/channels), byte((sum/channels)>>8))
+			}
+			f, err = newSoundcardFormat(defaultSoundcardSampleFormat, f.sampleRate)
+			return mono, f, err
+		}
+
+		// Chunks are word aligned.
+		if l%2 != 0 && l < len(d) {
+			l++
+		}
+		d = d[l:]
+	}
+	return nil, f, errors.New("no data chunk in wav file")
+}
+
+// Loads a message and returns it as 48kHz, s16le, mono audio.
+func loadVoiceKeyerFile(path string) ([]byte, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".wav") {
+		return d, nil
+	}
+
+	data, f, err := parseWAV(d)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if f.isNative() {
+		return data, nil
+	}
+	return newSoundcardDecoder(f).decode(data), nil
+}
+
+func (s *voiceKeyerStruct) setPTT(enable bool) {
+	if err := s.sess.civControl.setPTTWithDataMode(enable); err != nil {
+		log.Error("can't set ptt: ", err)
+	}
+}
+
+// Sends the message to the audio stream in audioFrameLength frames, in real time. Returns false if the
+// playback got aborted.
+func (s *voiceKeyerStruct) send(d []byte, stopChan chan bool) bool {
+	ticker := time.NewTicker(audioFrameLength)
+	defer ticker.Stop()
+
+	for len(d) > 0 {
+		frame := make([]byte, audioFrameSize)
+		n := copy(frame, d)
+		d = d[n:]
+
+		select {
+		case s.sess.audio.rec <- frame:
+		case <-stopChan:
+			return false
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stopChan:
+			return false
+		}
+	}
+	return true
+}
+
+func (s *voiceKeyerStruct) setSending(sending bool) {
+	s.sendingMutex.Lock()
+	defer s.sendingMutex.Unlock()
+
+	s.sending = sending
+}
+
+func (s *voiceKeyerStruct) isSending() bool {
+	s.sendingMutex.Lock()
+	defer s.sendingMutex.Unlock()
+
+	return s.sending
+}
+
+func (s *voiceKeyerStruct) loop(name string, d []byte, stopChan, finishedChan chan bool) {
+	defer close(finishedChan)
+
+	for {
+		log.Print("transmitting voice keyer message ", name)
+		s.sess.statusLog.reportAudioRec(true)
+		s.setSending(true)
+		s.sess.vox.suspend()
+		s.setPTT(true)
+		finished := s.send(d, stopChan)
+		s.setPTT(false)
+		s.setSending(false)
+		s.sess.statusLog.reportAudioRec(false)
+
+		if !finished {
+			log.Print("voice keyer message aborted")
+			return
+		}
+		if s.sess.conf.voiceKeyerRepeat == 0 {
+			return
+		}
+
+		log.Print("repeating voice keyer message in ", s.sess.conf.voiceKeyerRepeat)
+		select {
+		case <-time.After(s.sess.conf.voiceKeyerRepeat):
+		case <-stopChan:
+			log.Print("voice keyer message aborted")
+			return
+		}
+	}
+}
+
+// Starts transmitting message number n (starting from 1). The currently playing message is aborted.
+func (s *voiceKeyerStruct) play(n int) error {
+	if n < 1 || n > len(s.sess.conf.voiceKeyerFiles) || s.sess.conf.voiceKeyerFiles[n-1] == "" {
+		return fmt.Errorf("no voice keyer message set for #%d", n)
+	}
+	if s.sess.audio.rec == nil {
+		return errors.New("audio is not available yet")
+	}
+
+	path := s.sess.conf.voiceKeyerFiles[n-1]
+	d, err := loadVoiceKeyerFile(path)
+	if err != nil {
+		return err
+	}
+
+	s.stop()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.stopChan = make(chan bool)
+	s.finishedChan = make(chan bool)
+	go s.loop(filepath.Base(path), d, s.stopChan, s.finishedChan)
+	return nil
+}
+
+func (s *voiceKeyerStruct) isPlaying() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.finishedChan == nil {
+		return false
+	}
+	select {
+	case <-s.finishedChan:
+		return false
+	default:
+		return true
+	}
+}
+
+// Aborts the currently playing message, if any.
+func (s *voiceKeyerStruct) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stopChan == nil {
+		return
+	}
+	close(s.stopChan)
+	<-s.finishedChan
+	s.stopChan = nil
+	s.finishedChan = nil
+}
+
+func (s *voiceKeyerStruct) deinit() {
+	s.stop()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultVOXThreshold = -30 // dBFS
+const defaultVOXAttack = 20 * time.Millisecond
+const defaultVOXHang = 500 * time.Millisecond
+const defaultVOXMaxTx = 2 * time.Minute
+
+// Turns PTT on when the audio coming from the virtual sound card is above the threshold for the attack
+// time, and turns it off when the audio is below the threshold for the hang time. If the audio never
+// stops, then PTT is turned off after the max. TX time, and VOX won't turn it on again until the audio
+// stays below the threshold for the hang time.
+type voxStruct struct {
+	sess *session
+
+	mutex      sync.Mutex
+	keyed      bool
+	capped     bool
+	aboveSince time.Time
+	lastAbove  time.Time
+	keyedAt    time.Time
+	hangTimer  *time.Timer
+	maxTxTimer *time.Timer
+}
+
+func (v *voxStruct) setPTT(enable bool) {
+	if err := v.sess.civControl.setPTTWithDataMode(enable); err != nil {
+		log.Error("can't set ptt: ", err)
+	}
+}
+
+// Expects the mutex to be locked.
+func (v *voxStruct) unkey() {
+	if !v.keyed {
+		return
+	}
+	v.keyed = false
+	v.hangTimer.Stop()
+	v.maxTxTimer.Stop()
+	v.setPTT(false)
+}
+
+// Forgets that PTT was keyed by VOX without releasing it, so the voice keyer can take over PTT without
+// VOX releasing it in the middle of the message.
+func (v *voxStruct) suspend() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if !v.keyed {
+		return
+	}
+	v.keyed = false
+	v.hangTimer.Stop()
+	v.maxTxTimer.Stop()
+}
+
+func (v *voxStruct) handleHangTimeout() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	// The timer could have been reset while this function was waiting for the mutex.
+	if !v.keyed || time.Since(v.lastAbove) < v.sess.conf.voxHang {
+		return
+	}
+	log.Debug("audio stopped, releasing ptt")
+	v.unkey()
+}
+
+func (v *voxStruct) handleMaxTxTimeout() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if !v.keyed || time.Since(v.keyedAt) < v.sess.conf.voxMaxTx {
+		return
+	}
+	log.Error("audio did not stop in ", v.sess.conf.voxMaxTx, ", releasing ptt")
+	v.unkey()
+	v.capped = true
+}
+
+// Processes 48kHz, s16le, mono audio coming from the virtual sound card.
+func (v *voxStruct) process(d []byte) {
+	if !v.sess.conf.voxEnabled {
+		return
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if getAudioPeakLevel(d) < v.sess.conf.voxThreshold {
+		v.aboveSince = time.Time{}
+		// Short pauses in the audio which caused the max. TX timeout should not key PTT again.
+		if v.capped && time.Since(v.lastAbove) >= v.sess.conf.voxHang {
+			v.capped = false
+		}
+		return
+	}
+
+	now := time.Now()
+	if v.aboveSince.IsZero() {
+		v.aboveSince = now
+	}
+	v.lastAbove = now
+
+	if v.keyed {
+		v.hangTimer.Reset(v.sess.conf.voxHang)
+		return
+	}
+	if v.capped || now.Sub(v.aboveSince) < v.sess.conf.voxAttack {
+		return
+	}
+
+	log.Debug("got audio, keying ptt")
+	v.keyed = true
+	v.keyedAt = now
+	v.setPTT(true)
+	if v.hangTimer == nil {
+		v.hangTimer = time.AfterFunc(v.sess.conf.voxHang, v.handleHangTimeout)
+		v.maxTxTimer = time.AfterFunc(v.sess.conf.voxMaxTx, v.handleMaxTxTimeout)
+	} else {
+		v.hangTimer.Reset(v.sess.conf.voxHang)
+		v.maxTxTimer.Reset(v.sess.conf.voxMaxTx)
+	}
+}
+
+func (v *voxStruct) deinit() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.unkey()
+}